@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+)
+
+// moveJudgment is how bad a move was, in the categories Lichess uses.
+type moveJudgment int
+
+const (
+	goodMove moveJudgment = iota
+	inaccuracy
+	mistake
+	blunder
+)
+
+func (j moveJudgment) String() string {
+	switch j {
+	case inaccuracy:
+		return "Inaccuracy"
+	case mistake:
+		return "Mistake"
+	case blunder:
+		return "Blunder"
+	default:
+		return "Good move"
+	}
+}
+
+// symbol is the annotation Lichess appends to judged moves.
+func (j moveJudgment) symbol() string {
+	switch j {
+	case inaccuracy:
+		return "?!"
+	case mistake:
+		return "?"
+	case blunder:
+		return "??"
+	default:
+		return ""
+	}
+}
+
+// maxCentipawns caps evaluations for the average centipawn loss, as Lichess
+// does, so a missed mate doesn't outweigh the rest of the game.
+const maxCentipawns = 1000
+
+// judgeMove judges a move by how much it lowered the winning chances of the
+// player who made it. Lichess uses the same thresholds.
+func judgeMove(before, after evalScore, mover chess.Color) moveJudgment {
+	loss := before.winningChances() - after.winningChances()
+	if mover == chess.Black {
+		loss = -loss
+	}
+	switch {
+	case loss >= 0.3:
+		return blunder
+	case loss >= 0.2:
+		return mistake
+	case loss >= 0.1:
+		return inaccuracy
+	default:
+		return goodMove
+	}
+}
+
+// cappedCentipawns returns the score in centipawns from white's point of
+// view, with mates counted as the cap.
+func (s evalScore) cappedCentipawns() int {
+	switch {
+	case s.Mate > 0:
+		return maxCentipawns
+	case s.Mate < 0:
+		return -maxCentipawns
+	case s.Centipawns > maxCentipawns:
+		return maxCentipawns
+	case s.Centipawns < -maxCentipawns:
+		return -maxCentipawns
+	default:
+		return s.Centipawns
+	}
+}
+
+// centipawnLoss is how many centipawns a move gave away.
+func centipawnLoss(before, after evalScore, mover chess.Color) int {
+	loss := before.cappedCentipawns() - after.cappedCentipawns()
+	if mover == chess.Black {
+		loss = -loss
+	}
+	if loss < 0 {
+		return 0
+	}
+	return loss
+}
+
+// winPercent is white's winning chances in percent.
+func (s evalScore) winPercent() float64 {
+	return 50 + 50*s.winningChances()
+}
+
+// moveAccuracy rates a move from 0 to 100 by the winning chances it lost,
+// in percent for the player who made it, with the curve Lichess fitted.
+func moveAccuracy(winBefore, winAfter float64) float64 {
+	if winAfter >= winBefore {
+		return 100
+	}
+	accuracy := 103.1668100711649*math.Exp(-0.04354415386753951*(winBefore-winAfter)) - 3.166924740191411 + 1
+	return math.Max(0, math.Min(100, accuracy))
+}
+
+// gameAccuracy computes the accuracy of a player like Lichess does: the
+// move accuracies are weighted by how volatile the game was around them,
+// and the weighted mean is averaged with the harmonic mean, which punishes
+// single bad moves. scores holds the evaluation of every position of the
+// game, the starting position first.
+func gameAccuracy(scores []evalScore, start chess.Color, player chess.Color) (float64, bool) {
+	if len(scores) < 2 {
+		return 0, false
+	}
+	wins := make([]float64, len(scores))
+	for i, score := range scores {
+		wins[i] = score.winPercent()
+	}
+
+	moves := len(scores) - 1
+	// The window is a tenth of the positions, counting the starting one.
+	windowSize := len(wins) / 10
+	if windowSize < 2 {
+		windowSize = 2
+	} else if windowSize > 8 {
+		windowSize = 8
+	}
+	if windowSize > len(wins) {
+		windowSize = len(wins)
+	}
+
+	// Every move gets a window of positions around it; the first moves
+	// share the window at the start of the game.
+	weight := func(move int) float64 {
+		from := move - windowSize + 2
+		if from < 0 {
+			from = 0
+		}
+		if from+windowSize > len(wins) {
+			from = len(wins) - windowSize
+		}
+		return math.Max(0.5, math.Min(12, standardDeviation(wins[from:from+windowSize])))
+	}
+
+	var weightedSum, weightSum, inverseSum float64
+	var count int
+	mover := start
+	for i := 0; i < moves; i++ {
+		if mover == player {
+			before, after := wins[i], wins[i+1]
+			if player == chess.Black {
+				before, after = 100-before, 100-after
+			}
+			accuracy := moveAccuracy(before, after)
+			w := weight(i)
+			weightedSum += accuracy * w
+			weightSum += w
+			inverseSum += 1 / math.Max(accuracy, 1)
+			count++
+		}
+		mover = mover.Other()
+	}
+	if count == 0 {
+		return 0, false
+	}
+	weighted := weightedSum / weightSum
+	harmonic := float64(count) / inverseSum
+	return (weighted + harmonic) / 2, true
+}
+
+func standardDeviation(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The expected values below were worked out from the formulas Lichess
+// publishes for winning chances, move accuracy and game accuracy.
+
+func TestMoveAccuracy(t *testing.T) {
+	assert.Equal(t, 100.0, moveAccuracy(50, 50))
+	assert.Equal(t, 100.0, moveAccuracy(40, 60))
+	assert.InDelta(t, 64.5798, moveAccuracy(50, 40), 0.0001)
+	assert.InDelta(t, 25.7720, moveAccuracy(60, 30), 0.0001)
+	assert.Equal(t, 0.0, moveAccuracy(100, 0))
+}
+
+func TestJudgeMove(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after evalScore
+		mover         chess.Color
+		want          moveJudgment
+	}{
+		{"small loss", evalScore{Centipawns: 0}, evalScore{Centipawns: -30}, chess.White, goodMove},
+		{"inaccuracy", evalScore{Centipawns: 0}, evalScore{Centipawns: -60}, chess.White, inaccuracy},
+		{"mistake", evalScore{Centipawns: 0}, evalScore{Centipawns: -120}, chess.White, mistake},
+		{"blunder", evalScore{Centipawns: 0}, evalScore{Centipawns: -250}, chess.White, blunder},
+		{"missed mate", evalScore{Mate: 2}, evalScore{Centipawns: 0}, chess.White, blunder},
+		// Black loses chances when the evaluation rises.
+		{"black inaccuracy", evalScore{Centipawns: 0}, evalScore{Centipawns: 60}, chess.Black, inaccuracy},
+		{"black gain", evalScore{Centipawns: 0}, evalScore{Centipawns: -250}, chess.Black, goodMove},
+		{"black mated", evalScore{Centipawns: -30}, evalScore{Mate: 1}, chess.Black, blunder},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, judgeMove(test.before, test.after, test.mover), test.name)
+	}
+}
+
+func TestCentipawnLoss(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after evalScore
+		mover         chess.Color
+		want          int
+	}{
+		{"white loss", evalScore{Centipawns: 50}, evalScore{Centipawns: -100}, chess.White, 150},
+		{"white gain", evalScore{Centipawns: -100}, evalScore{Centipawns: 50}, chess.White, 0},
+		{"black loss", evalScore{Centipawns: 50}, evalScore{Centipawns: 200}, chess.Black, 150},
+		{"black gain", evalScore{Centipawns: 200}, evalScore{Centipawns: 50}, chess.Black, 0},
+		// Mates and large evaluations count as the cap.
+		{"walked into mate", evalScore{Centipawns: -30}, evalScore{Mate: -1}, chess.White, 970},
+		{"capped", evalScore{Centipawns: 3000}, evalScore{Centipawns: 500}, chess.White, 500},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, centipawnLoss(test.before, test.after, test.mover), test.name)
+	}
+}
+
+func TestGameAccuracy(t *testing.T) {
+	cp := func(centipawns ...int) []evalScore {
+		scores := make([]evalScore, len(centipawns))
+		for i, c := range centipawns {
+			scores[i] = evalScore{Centipawns: c}
+		}
+		return scores
+	}
+	// 30 positions make a window of 3.
+	var long []int
+	for i := 0; i < 30; i++ {
+		switch {
+		case i >= 20:
+			long = append(long, 300-5*i)
+		case i%2 == 0:
+			long = append(long, 10*i)
+		default:
+			long = append(long, -10*i)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		scores []evalScore
+		player chess.Color
+		want   float64
+	}{
+		{"short game white", append(cp(15, 20, 10, -150, -140, -160, -400, -390), evalScore{Mate: -1}), chess.White, 71.3564},
+		{"short game black", append(cp(15, 20, 10, -150, -140, -160, -400, -390), evalScore{Mate: -1}), chess.Black, 99.6083},
+		{"long game white", cp(long...), chess.White, 50.2597},
+		{"long game black", cp(long...), chess.Black, 42.7344},
+	}
+	for _, test := range tests {
+		accuracy, ok := gameAccuracy(test.scores, chess.White, test.player)
+		require.True(t, ok, test.name)
+		assert.InDelta(t, test.want, accuracy, 0.0001, test.name)
+	}
+
+	_, ok := gameAccuracy(cp(15), chess.White, chess.White)
+	assert.False(t, ok)
+	_, ok = gameAccuracy(cp(15, 20), chess.White, chess.Black)
+	assert.False(t, ok)
+}
+
+func TestGameReport(t *testing.T) {
+	game := chess.NewGame(chess.Standard.StartingPosition())
+	for _, move := range []string{"f3", "e5", "g4", "Qh4#"} {
+		_, err := game.PlayMove(move)
+		require.NoError(t, err)
+	}
+	evals := []*positionEval{
+		{Score: evalScore{Centipawns: 15}},
+		{Score: evalScore{Centipawns: -40}},
+		{Score: evalScore{Centipawns: -30}, BestMove: "e2e4"},
+		{Score: evalScore{Mate: -1}, BestMove: "d8h4"},
+		// The engine has nothing to say about the final position.
+		nil,
+	}
+
+	scores, ok := gameScores(game, evals)
+	require.True(t, ok)
+	assert.Equal(t, evalScore{Mate: -1}, scores[4])
+
+	white := newGameReport(game, evals, scores, chess.White)
+	assert.True(t, white.hasAccuracy)
+	assert.InDelta(t, 21.2602, white.accuracy, 0.0001)
+	assert.Equal(t, 513, white.acpl)
+	assert.Equal(t, map[moveJudgment]int{inaccuracy: 1, blunder: 1}, white.counts)
+	require.Len(t, white.flagged, 2)
+	assert.Equal(t, flaggedMove{ply: 0, judgment: inaccuracy, san: "f3"}, white.flagged[0])
+	assert.Equal(t, flaggedMove{ply: 2, judgment: blunder, san: "g4", best: "e4"}, white.flagged[1])
+
+	black := newGameReport(game, evals, scores, chess.Black)
+	assert.InDelta(t, 98.4698, black.accuracy, 0.0001)
+	assert.Equal(t, 5, black.acpl)
+	assert.Empty(t, black.counts)
+	assert.Empty(t, black.flagged)
+
+	// Only the final position may go without an evaluation.
+	evals[2] = nil
+	_, ok = gameScores(game, evals)
+	assert.False(t, ok)
+}
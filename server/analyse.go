@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/uci"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	analysisLines     = 3
+	analysisLineMoves = 10
+	positionEvalTime  = 2 * time.Second
+	positionEvalWait  = 15 * time.Second
+	gameAnalysisWait  = 3 * time.Minute
+	maxAnalysedPlies  = 300
+
+	analyseUsage = "Use `/lichess analyse <FEN>`, `/lichess analyse <game ID or link>` or `/lichess analyse <PGN>`."
+)
+
+// commandTextRegexp matches the trigger and the action of a command.
+var commandTextRegexp = regexp.MustCompile(`^\s*\S+\s+\S+\s*`)
+
+// commandText returns the text of a command after its action, keeping the
+// line breaks and quotes that splitArgs drops, e.g. of a pasted PGN.
+func commandText(command string) string {
+	return strings.TrimSpace(commandTextRegexp.ReplaceAllString(command, ""))
+}
+
+// analysedGame is a game to analyse together with what Lichess knows
+// about it.
+type analysedGame struct {
+	game     *chess.Game
+	title    string
+	analysis []lichess.GameAnalysis
+}
+
+func (p *Plugin) executeAnalyseCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 {
+		return ephemeralResponse(analyseUsage)
+	}
+
+	var ag *analysedGame
+	switch {
+	case strings.Contains(params[0], "/") && !strings.Contains(params[0], "://"):
+		pos, err := chess.ParseFEN(strings.Join(params, " "))
+		if err != nil {
+			return ephemeralResponsef("Invalid FEN: %s.", err.Error())
+		}
+		return p.executePositionAnalysis(args, pos)
+	case len(params) == 1 && p.isGameRef(params[0]):
+		gameID, resp := p.resolveGameID(params[0])
+		if resp != nil {
+			return resp
+		}
+		var err error
+		ag, err = p.exportAnalysedGame(gameID)
+		if err != nil {
+			p.API.LogWarn("failed to export game", "gameID", gameID, "error", err.Error())
+			return ephemeralResponse(lichessErrorText(err))
+		}
+	default:
+		games, err := chess.ParsePGN(strings.NewReader(commandText(args.Command)))
+		if err != nil || len(games) == 0 {
+			return ephemeralResponse(analyseUsage)
+		}
+		game, err := games[0].Replay()
+		if err != nil {
+			return ephemeralResponsef("Failed to replay the PGN: %s.", err.Error())
+		}
+		ag = &analysedGame{game: game, title: pgnGameTitle(games[0])}
+	}
+
+	if len(ag.game.Moves()) == 0 {
+		return p.executePositionAnalysis(args, ag.game.Position())
+	}
+	if len(ag.game.Moves()) > maxAnalysedPlies {
+		return ephemeralResponsef("Only games of up to %d moves can be analysed.", maxAnalysedPlies/2)
+	}
+
+	p.goAnalyse(func(ctx context.Context) {
+		p.postGameAnalysis(ctx, args.UserId, args.ChannelId, args.RootId, ag)
+	})
+	return ephemeralResponse("Analysing the game. The analysis will be posted here when it is done.")
+}
+
+// isGameRef tells whether a parameter names a Lichess game rather than a
+// move of a PGN.
+func (p *Plugin) isGameRef(param string) bool {
+	return gameLinkRegexp(p.getConfiguration().getBaseURL()).MatchString(param) || gameIDRegexp.MatchString(param)
+}
+
+func (p *Plugin) exportAnalysedGame(gameID string) (*analysedGame, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	game, err := p.newLichessClient().ExportGame(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := replayLichessGame(game)
+	if err != nil {
+		return nil, err
+	}
+	title := fmt.Sprintf("[%s vs %s](%s%s)", playerText(game.Players.White), playerText(game.Players.Black), p.getConfiguration().getBaseURL(), game.Id)
+	return &analysedGame{game: replayed, title: title, analysis: game.Analysis}, nil
+}
+
+func pgnGameTitle(g *chess.PGNGame) string {
+	white, black := g.Tag("White"), g.Tag("Black")
+	if white == "" || white == "?" || black == "" || black == "?" {
+		return "the game"
+	}
+	return white + " vs " + black
+}
+
+func (p *Plugin) executePositionAnalysis(args *model.CommandArgs, pos *chess.Position) *model.CommandResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), positionEvalWait)
+	defer cancel()
+
+	eval, source, err := p.evaluatePosition(ctx, pos, analysisLines, uci.Request{MoveTime: positionEvalTime})
+	if errors.Cause(err) == errNoEval {
+		if !pos.HasLegalMoves() {
+			return ephemeralResponse("The game is over in this position.")
+		}
+		return ephemeralResponse("Lichess has no cloud evaluation of this position. Ask a system admin to configure a local engine to analyse any position.")
+	}
+	if err != nil {
+		p.API.LogWarn("failed to evaluate position", "fen", pos.FEN(), "error", err.Error())
+		return ephemeralResponse("Failed to evaluate the position.")
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+		Message:   "#### Evaluation: " + eval.Score.String() + "\n" + p.positionEvalText(pos, "", eval, source),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post analysis", "error", err.Error())
+		return ephemeralResponse("Failed to post the analysis.")
+	}
+	return &model.CommandResponse{}
+}
+
+// positionEvalText shows the position with an evaluation bar, the best move
+// and the best lines.
+func (p *Plugin) positionEvalText(pos *chess.Position, lastMove string, eval *positionEval, source string) string {
+	var b strings.Builder
+	b.WriteString(p.evalBoardMarkdown(pos.FEN(), lastMove, pos.Turn == chess.Black, eval.Score))
+	b.WriteString("\n\n")
+
+	if eval.BestMove != "" {
+		if m, err := pos.ParseMove(eval.BestMove); err == nil {
+			fmt.Fprintf(&b, "Best move: **%s**. ", pos.SAN(m))
+		}
+	}
+	if eval.Depth > 0 {
+		fmt.Fprintf(&b, "Depth %d, %s.\n\n", eval.Depth, source)
+	} else {
+		fmt.Fprintf(&b, "%s.\n\n", source)
+	}
+
+	for i, line := range eval.Lines {
+		if i == analysisLines {
+			break
+		}
+		fmt.Fprintf(&b, "%d. `%s` %s\n", i+1, line.Score, lineText(pos, line.Moves, analysisLineMoves))
+	}
+	return b.String()
+}
+
+// goAnalyse runs an analysis in the background. OnDeactivate cancels its
+// context and waits for it before closing the engines.
+func (p *Plugin) goAnalyse(analyse func(ctx context.Context)) {
+	p.analyses.Add(1)
+	go func() {
+		defer p.analyses.Done()
+		defer func() {
+			if x := recover(); x != nil {
+				p.API.LogError("recovered from a panic in an analysis", "error", x)
+			}
+		}()
+		analyse(p.analysesCtx)
+	}()
+}
+
+// postGameAnalysis evaluates every move of a game and posts the moves with
+// their evaluations, followed by the analysis of the final position.
+func (p *Plugin) postGameAnalysis(ctx context.Context, userID, channelID, rootID string, ag *analysedGame) {
+	ctx, cancel := context.WithTimeout(ctx, gameAnalysisWait)
+	defer cancel()
+
+	fail := func(message string) {
+		p.pluginAPI.Post.SendEphemeralPost(userID, &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			RootId:    rootID,
+			Message:   message,
+		})
+	}
+
+	evals, source, err := p.evaluateGame(ctx, ag.game, ag.analysis)
+	if err != nil {
+		p.API.LogWarn("failed to evaluate game", "error", err.Error())
+		fail("Failed to analyse the game.")
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Analysis of %s\n", ag.title)
+	b.WriteString(evaluatedMovesText(ag.game, evals))
+	b.WriteString("\n\n")
+
+	pos := ag.game.Position()
+	ucis := ag.game.UCIMoves()
+	lastMove := ucis[len(ucis)-1]
+	final, finalSource, err := p.evaluatePosition(ctx, pos, analysisLines, uci.Request{MoveTime: positionEvalTime})
+	if err == nil {
+		fmt.Fprintf(&b, "Final position: **%s**\n", final.Score)
+		b.WriteString(p.positionEvalText(pos, lastMove, final, finalSource))
+	} else {
+		if errors.Cause(err) != errNoEval {
+			p.API.LogWarn("failed to evaluate position", "fen", pos.FEN(), "error", err.Error())
+		}
+		b.WriteString(p.boardMarkdown(pos.FEN(), lastMove, false))
+		b.WriteString("\n\n")
+	}
+	if evaluated(evals) {
+		fmt.Fprintf(&b, "\n*Moves evaluated by %s.*", source)
+	} else {
+		b.WriteString("\n*Lichess has no evaluation of these moves. Ask a system admin to configure a local engine to analyse any game.*")
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		RootId:    rootID,
+		Message:   b.String(),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post game analysis", "error", err.Error())
+		fail("Failed to post the analysis.")
+	}
+}
+
+func evaluated(evals []*positionEval) bool {
+	for _, eval := range evals[1:] {
+		if eval != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluatedMovesText formats the moves of a game in SAN, each followed by the
+// evaluation of the position it reached when known.
+func evaluatedMovesText(game *chess.Game, evals []*positionEval) string {
+	start := game.StartingPosition()
+	number, turn := start.FullmoveNumber, start.Turn
+
+	var parts []string
+	for i, san := range game.SANMoves() {
+		if turn == chess.White {
+			parts = append(parts, fmt.Sprintf("**%d.**", number))
+		} else if i == 0 {
+			parts = append(parts, fmt.Sprintf("**%d...**", number))
+		}
+		parts = append(parts, san)
+		if eval := evals[i+1]; eval != nil {
+			parts = append(parts, "`"+eval.Score.String()+"`")
+		}
+		if turn == chess.Black {
+			number++
+		}
+		turn = turn.Other()
+	}
+	return strings.Join(parts, " ")
+}
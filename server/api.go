@@ -4,14 +4,15 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"io"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
 	"github.com/gorilla/mux"
 	"github.com/mattermost/mattermost-plugin-api/experimental/bot/logger"
+	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/plugin"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
@@ -99,12 +100,45 @@ func (p *Plugin) initializeAPI() {
 	p.router = mux.NewRouter()
 	p.router.Use(p.withRecovery)
 
+	p.router.HandleFunc("/board.png", p.handleBoardImage).Methods(http.MethodGet)
+	p.router.HandleFunc("/games/page", p.handleGamesPage).Methods(http.MethodPost)
+	p.router.HandleFunc("/pgn/export", p.handlePGNExport).Methods(http.MethodPost)
+	p.router.HandleFunc("/users/status", p.handleGetStatuses).Methods(http.MethodGet)
+
 	oauthRouter := p.router.PathPrefix("/oauth").Subrouter()
 
 	oauthRouter.HandleFunc("/connect", p.checkAuth(p.attachContext(p.handleLogin), ResponseTypePlain)).Methods(http.MethodGet)
 	oauthRouter.HandleFunc("/complete", p.checkAuth(p.attachContext(p.handleCallback), ResponseTypePlain)).Methods(http.MethodGet)
 }
 
+// actionPost returns the post of a post action request, when the user who
+// clicked may read it.
+func (p *Plugin) actionPost(userID, postID string) (*model.Post, bool) {
+	post, err := p.pluginAPI.Post.GetPost(postID)
+	if err != nil || post.DeleteAt != 0 {
+		return nil, false
+	}
+	if !p.pluginAPI.User.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+		return nil, false
+	}
+	return post, true
+}
+
+// actionContexts returns the contexts of the actions of a post that call the
+// plugin at path. Anyone can call the action URLs with any context, so
+// handlers only trust the contexts stored in the post.
+func actionContexts(post *model.Post, path string) []map[string]interface{} {
+	var contexts []map[string]interface{}
+	for _, attachment := range post.Attachments() {
+		for _, action := range attachment.Actions {
+			if action.Integration != nil && strings.HasSuffix(action.Integration.URL, pluginURLPath+path) {
+				contexts = append(contexts, action.Integration.Context)
+			}
+		}
+	}
+	return contexts
+}
+
 func (p *Plugin) checkAuth(handler http.HandlerFunc, responseType ResponseType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := r.Cookie("MMUSERID")
@@ -294,24 +328,11 @@ func (p *Plugin) handleCallback(c *Context, w http.ResponseWriter, r *http.Reque
 	ts := oauth2.StaticTokenSource(tok)
 	tc := oauth2.NewClient(c.Ctx, ts)
 
-	res, err := tc.Get("https://lichess.org/api/account")
+	account, err := lichess.NewClient(p.getConfiguration().getBaseURL(), tc).Account(c.Ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer res.Body.Close()
-
-	var account lichess.LichessAccount
-	err = json.Unmarshal(body, &account)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 
 	userInfo := &LichessUserInfo{
 		UserID:          oauthState.UserID,
@@ -326,6 +347,10 @@ func (p *Plugin) handleCallback(c *Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if err = p.addConnectedUser(userInfo.UserID, userInfo.LichessUsername); err != nil {
+		c.Log.WithError(err).Warnf("failed to index connected user")
+	}
+
 	html := `
 			<!DOCTYPE html>
 			<html>
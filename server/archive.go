@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// tournamentIDRegexp matches the ids Lichess gives arenas and Swiss
+// tournaments.
+var tournamentIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9]{8}$`)
+
+func (p *Plugin) executeTournamentsCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	action := "history"
+	if len(params) > 0 {
+		action, params = params[0], params[1:]
+	}
+
+	switch action {
+	case "history":
+		return p.executeTournamentHistoryCommand(args, params)
+	case "player":
+		return p.executeTournamentPlayerCommand(args, params)
+	case "track":
+		return p.executeTournamentTrackCommand(args, params)
+	default:
+		return ephemeralResponsef("Unknown tournaments command %q. Use `history`, `player` or `track`.", action)
+	}
+}
+
+// getTeamTournaments returns the tournaments followed in the channels of a
+// team, newest first.
+func (p *Plugin) getTeamTournaments(teamID string) ([]*TrackedTournament, error) {
+	ids, err := p.getTournamentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	channelTeams := map[string]string{}
+	var tournaments []*TrackedTournament
+	for _, id := range ids {
+		t, err := p.getTournament(id)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			continue
+		}
+		channelTeam, ok := channelTeams[t.ChannelID]
+		if !ok {
+			if channel, appErr := p.API.GetChannel(t.ChannelID); appErr == nil {
+				channelTeam = channel.TeamId
+			}
+			channelTeams[t.ChannelID] = channelTeam
+		}
+		if channelTeam == teamID {
+			tournaments = append(tournaments, t)
+		}
+	}
+
+	sort.Slice(tournaments, func(i, j int) bool {
+		return tournaments[i].startTime() > tournaments[j].startTime()
+	})
+	return tournaments, nil
+}
+
+// parseYear parses an optional year parameter, defaulting to this year.
+func parseYear(params []string) (int, []string) {
+	year := time.Now().Year()
+	var rest []string
+	for _, param := range params {
+		if n, err := strconv.Atoi(param); err == nil && n > 1900 && n < 10000 {
+			year = n
+			continue
+		}
+		rest = append(rest, param)
+	}
+	return year, rest
+}
+
+func tournamentYear(t *TrackedTournament) int {
+	return time.UnixMilli(t.startTime()).Year()
+}
+
+func (p *Plugin) executeTournamentHistoryCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	year, rest := parseYear(params)
+	if len(rest) > 0 {
+		return ephemeralResponsef("Unknown option %q, only a year is allowed.", rest[0])
+	}
+
+	tournaments, err := p.getTeamTournaments(args.TeamId)
+	if err != nil {
+		p.API.LogWarn("failed to get tournaments", "error", err.Error())
+		return ephemeralResponse("Failed to load the tournaments.")
+	}
+
+	mentions := p.lichessMentions()
+	var b strings.Builder
+	for _, t := range tournaments {
+		if tournamentYear(t) != year {
+			continue
+		}
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "#### Tournaments in %d\n", year)
+			b.WriteString("| Date | Tournament | Winner | Players |\n")
+			b.WriteString("|:-----|:-----------|:-------|--------:|\n")
+		}
+		winner := "in progress"
+		if t.Finished {
+			winner = "-"
+			if len(t.Standings) > 0 && t.Standings[0].Rank == 1 {
+				winner = playerName(t.Standings[0].Username, "", mentions)
+			}
+		}
+		fmt.Fprintf(&b, "| %s | [%s](%s) | %s | %d |\n",
+			time.UnixMilli(t.startTime()).Format("Jan 2"), t.Name, p.tournamentURL(t), winner, t.NbPlayers)
+	}
+	if b.Len() == 0 {
+		return ephemeralResponsef("No tournaments were followed in this team in %d.", year)
+	}
+	return ephemeralResponse(b.String())
+}
+
+func (p *Plugin) executeTournamentPlayerCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	year, rest := parseYear(params)
+	userID, username := args.UserId, ""
+	for _, param := range rest {
+		user, err := p.userFromMention(param)
+		if err != nil {
+			return ephemeralResponse(err.Error())
+		}
+		userID, username = user.Id, user.Username
+	}
+	if username == "" {
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil {
+			return ephemeralResponse("Failed to load your user.")
+		}
+		username = user.Username
+	}
+
+	tournaments, err := p.getTeamTournaments(args.TeamId)
+	if err != nil {
+		p.API.LogWarn("failed to get tournaments", "error", err.Error())
+		return ephemeralResponse("Failed to load the tournaments.")
+	}
+
+	var rows strings.Builder
+	played, wins, podiums, bestRank := 0, 0, 0, 0
+	for _, t := range tournaments {
+		if !t.Finished || tournamentYear(t) != year {
+			continue
+		}
+		for _, standing := range t.Standings {
+			if standing.UserID != userID {
+				continue
+			}
+			played++
+			if standing.Rank == 1 {
+				wins++
+			}
+			if standing.Rank <= podiumSize {
+				podiums++
+			}
+			if bestRank == 0 || standing.Rank < bestRank {
+				bestRank = standing.Rank
+			}
+			fmt.Fprintf(&rows, "| %s | [%s](%s) | %s of %d | %s |\n",
+				time.UnixMilli(t.startTime()).Format("Jan 2"), t.Name, p.tournamentURL(t), placeEmoji(standing.Rank), t.NbPlayers, formatPoints(standing.Score))
+			break
+		}
+	}
+	if played == 0 {
+		return ephemeralResponsef("@%s has not finished any tournament of this team in %d.", username, year)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Tournaments of @%s in %d\n", username, year)
+	fmt.Fprintf(&b, "%d played, %d won, %d podiums, best rank %d.\n\n", played, wins, podiums, bestRank)
+	b.WriteString("| Date | Tournament | Rank | Score |\n")
+	b.WriteString("|:-----|:-----------|-----:|------:|\n")
+	b.WriteString(rows.String())
+	return ephemeralResponse(b.String())
+}
+
+func (p *Plugin) tournamentURL(t *TrackedTournament) string {
+	if t.Kind == tournamentKindSwiss {
+		return p.getConfiguration().getBaseURL() + "swiss/" + t.ID
+	}
+	return p.getConfiguration().getBaseURL() + "tournament/" + t.ID
+}
+
+// executeTournamentTrackCommand follows a tournament that was created on
+// Lichess, so it is posted and archived like the ones created here.
+func (p *Plugin) executeTournamentTrackCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return ephemeralResponse("Use `/lichess tournaments track <tournament link>`.")
+	}
+	kind, id := parseTournamentRef(params[0])
+	if !tournamentIDRegexp.MatchString(id) {
+		return ephemeralResponsef("%q is not a link to a Lichess tournament.", params[0])
+	}
+
+	existing, err := p.getTournament(id)
+	if err != nil {
+		p.API.LogWarn("failed to get tournament", "error", err.Error())
+		return ephemeralResponse("Failed to load the tournament.")
+	}
+	if existing != nil {
+		return ephemeralResponsef("[%s](%s) is already followed.", existing.Name, p.tournamentURL(existing))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	client := p.newLichessClient()
+	t := &TrackedTournament{
+		ID:        id,
+		ChannelID: args.ChannelId,
+		CreatorID: args.UserId,
+		CreatedAt: model.GetMillis(),
+	}
+	var message string
+	// finishedArena is set for an arena that is already over.
+	var finishedArena *lichess.Arena
+	if kind != tournamentKindSwiss {
+		arena, err := client.Arena(ctx, id)
+		if err == nil {
+			t.Kind, t.Name = tournamentKindArena, arena.FullName
+			t.TimeControl = formatClock(arena.Clock.Limit, arena.Clock.Increment)
+			t.StartsAt = lichessTime(arena.StartsAt, 0)
+			t.EndsAt = arenaEndTime(arena)
+			message = p.arenaMessage(t, arena, nil, p.lichessMentions())
+			if arena.IsFinished {
+				finishedArena = arena
+			}
+		} else if kind == tournamentKindArena || !lichess.IsNotFound(err) {
+			return ephemeralResponse(lichessErrorText(err))
+		}
+	}
+	if t.Kind == "" {
+		swiss, err := client.Swiss(ctx, id)
+		if lichess.IsNotFound(err) {
+			return ephemeralResponsef("No tournament %q found on Lichess.", id)
+		} else if err != nil {
+			return ephemeralResponse(lichessErrorText(err))
+		}
+		t.Kind, t.Name = tournamentKindSwiss, swiss.Name
+		t.TimeControl = formatClock(swiss.Clock.Limit, swiss.Clock.Increment)
+		t.StartsAt = lichessTime(swiss.StartsAt, 0)
+		message = p.swissMessage(t, swiss)
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		Message:   message,
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post tournament", "error", err.Error())
+		return ephemeralResponse("Failed to post the tournament.")
+	}
+	t.PostID = post.Id
+
+	if finishedArena != nil {
+		err = p.trackFinishedArena(ctx, t, finishedArena)
+	} else {
+		err = p.trackTournament(t)
+	}
+	if err != nil {
+		p.API.LogWarn("failed to track tournament", "error", err.Error())
+		return ephemeralResponse("Failed to follow the tournament.")
+	}
+	return &model.CommandResponse{}
+}
+
+// parseTournamentRef returns the kind and the id of a tournament from its
+// link. A bare id has no kind.
+func parseTournamentRef(ref string) (kind, id string) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", ""
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		switch segments[i] {
+		case "swiss":
+			return tournamentKindSwiss, segments[i+1]
+		case "tournament":
+			return tournamentKindArena, segments[i+1]
+		}
+	}
+	if len(segments) == 1 && u.Host == "" {
+		return "", segments[0]
+	}
+	return "", ""
+}
+
+// lichessTime parses a Lichess timestamp into milliseconds, falling back to
+// def.
+func lichessTime(s string, def int64) int64 {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return def
+	}
+	return t.UnixMilli()
+}
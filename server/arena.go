@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	arenaRunnerName = "arena"
+	// arenaRefreshInterval is how often the results stream of a running
+	// arena is read.
+	arenaRefreshInterval = 10 * time.Second
+	// arenaPostLifetime bounds how long after its start an arena of unknown
+	// length is followed, past the 12 hours the longest Lichess arenas last.
+	arenaPostLifetime = 13 * time.Hour
+	// arenaPostMargin is how long an arena is followed past its scheduled
+	// end, while its last games finish.
+	arenaPostMargin = time.Hour
+
+	arenaEndedNote = "\n\n*Stopped following the standings of this tournament.*"
+
+	arenaCreateUsage = "Use `/lichess arena create \"Name\" 3+2 60m`, optionally followed by a variant and `casual`."
+
+	// podiumSize is the number of players announced when a tournament ends.
+	podiumSize = 3
+)
+
+func (p *Plugin) executeArenaCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 || params[0] != "create" {
+		return ephemeralResponse("Use `/lichess arena create \"Name\" 3+2 60m`.")
+	}
+	return p.executeArenaCreateCommand(args, params[1:])
+}
+
+// executeArenaCreateCommand creates an arena restricted to the configured
+// Lichess team, as the Lichess account of the user running the command.
+func (p *Plugin) executeArenaCreateCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	client, teamID, resp := p.tournamentCreatorClient(args)
+	if resp != nil {
+		return resp
+	}
+
+	if len(params) == 0 {
+		return ephemeralResponse(arenaCreateUsage)
+	}
+	// The name comes first, so a name that looks like an option stays a
+	// name.
+	opts := lichess.ArenaOptions{Name: params[0], Rated: true, TeamID: teamID, WaitMinutes: 5}
+	timeControl := ""
+	for _, param := range params[1:] {
+		if timeControl == "" && strings.Contains(param, "+") {
+			clockTime, increment, err := parseTimeControl(param)
+			if err != nil {
+				return ephemeralResponse(err.Error())
+			}
+			opts.ClockTime, opts.ClockIncrement, timeControl = clockTime, increment, param
+			continue
+		}
+		if minutes, ok := parseMinutes(param); ok && opts.Minutes == 0 {
+			opts.Minutes = minutes
+			continue
+		}
+		if param == "casual" && opts.Rated {
+			opts.Rated = false
+			continue
+		}
+		if v, err := chess.ParseVariant(param); err == nil && opts.Variant == "" {
+			opts.Variant = string(v)
+			continue
+		}
+		return ephemeralResponsef("Unknown option %q. Quote the tournament name if it has spaces.", param)
+	}
+	if opts.Name == "" || timeControl == "" || opts.Minutes == 0 {
+		return ephemeralResponse(arenaCreateUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	arena, err := client.CreateArena(ctx, opts)
+	if err != nil {
+		p.API.LogWarn("failed to create arena", "error", err.Error())
+		return ephemeralResponse(lichessErrorText(err))
+	}
+
+	t := &TrackedTournament{
+		ID:          arena.Id,
+		Kind:        tournamentKindArena,
+		Name:        arena.FullName,
+		TimeControl: timeControl,
+		ChannelID:   args.ChannelId,
+		CreatorID:   args.UserId,
+		CreatedAt:   model.GetMillis(),
+		StartsAt:    lichessTime(arena.StartsAt, 0),
+		EndsAt:      arenaEndTime(arena),
+	}
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		Message:   p.arenaMessage(t, arena, nil, p.lichessMentions()),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post arena", "error", err.Error())
+		return ephemeralResponsef("Created the tournament at %stournament/%s, but failed to post it.", p.getConfiguration().getBaseURL(), arena.Id)
+	}
+	t.PostID = post.Id
+
+	if err := p.trackTournament(t); err != nil {
+		p.API.LogWarn("failed to track arena", "error", err.Error())
+		return ephemeralResponse("Created the tournament, but failed to follow its standings.")
+	}
+	return &model.CommandResponse{}
+}
+
+// parseMinutes parses a tournament duration like 60m, 1h30m or a plain
+// number of minutes.
+func parseMinutes(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return n, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Minute {
+		return 0, false
+	}
+	return int(d / time.Minute), true
+}
+
+// streamArena follows the standings of an arena from its results stream.
+// The stream ends with the current standings, so it is read again every
+// arenaRefreshInterval until the arena is over and its podium posted.
+func (p *Plugin) streamArena(ctx context.Context, id string, publish func(message string)) bool {
+	client := p.newStreamingLichessClient()
+	for {
+		finished, err := p.refreshArena(ctx, client, id, publish)
+		if ctx.Err() != nil {
+			return false
+		}
+		if lichess.IsNotFound(err) {
+			p.API.LogWarn("tournament no longer exists", "tournamentID", id)
+			return true
+		}
+		if finished {
+			return true
+		}
+		wait := arenaRefreshInterval
+		if err != nil {
+			p.API.LogWarn("failed to update arena", "tournamentID", id, "error", err.Error())
+			wait = liveRetryInterval
+		}
+		if !sleepOrDone(ctx, wait) {
+			return false
+		}
+	}
+}
+
+// refreshArena publishes the current standings of an arena, and archives it
+// once the tournament is over. It reports whether the arena is over for
+// good.
+func (p *Plugin) refreshArena(ctx context.Context, client *lichess.Client, id string, publish func(message string)) (bool, error) {
+	t, err := p.getTournament(id)
+	if err != nil {
+		return false, err
+	}
+	if t == nil || t.Finished {
+		return true, nil
+	}
+
+	arena, err := client.Arena(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	mentions := p.lichessMentions()
+	if arena.IsFinished {
+		results, err := p.finishArena(ctx, client, t, arena, mentions)
+		if err != nil {
+			return false, err
+		}
+		publish(p.arenaMessage(t, arena, results, mentions))
+		return true, nil
+	}
+
+	var results []*lichess.ArenaResult
+	err = client.ArenaResults(ctx, id, standingsShown, func(result *lichess.ArenaResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get arena results")
+	}
+	publish(p.arenaMessage(t, arena, results, mentions))
+	return false, nil
+}
+
+// finishArena archives the standings of an arena, announcing the podium if
+// the arena is over on Lichess, and returns its results.
+func (p *Plugin) finishArena(ctx context.Context, client *lichess.Client, t *TrackedTournament, arena *lichess.Arena, mentions map[string]string) ([]*lichess.ArenaResult, error) {
+	var results []*lichess.ArenaResult
+	err := client.ArenaResults(ctx, t.ID, maxArchivedStandings, func(result *lichess.ArenaResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get arena results")
+	}
+
+	if arena.IsFinished {
+		podium := results
+		if len(podium) > podiumSize {
+			podium = podium[:podiumSize]
+		}
+		if err := p.postTournamentResults(t, arenaPodiumMessage(t, podium, mentions)); err != nil {
+			return nil, err
+		}
+	}
+
+	standings := make([]TournamentStanding, len(results))
+	for i, result := range results {
+		standings[i] = TournamentStanding{
+			Rank:        result.Rank,
+			Username:    result.Username,
+			Score:       float64(result.Score),
+			Performance: result.Performance,
+		}
+	}
+	if err := p.finishTournament(t, standings, arena.NbPlayers); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// trackFinishedArena stores an arena that was over by the time it was
+// followed, and archives it right away.
+func (p *Plugin) trackFinishedArena(ctx context.Context, t *TrackedTournament, arena *lichess.Arena) error {
+	if err := p.saveTournament(t); err != nil {
+		return err
+	}
+	if err := p.addTournamentID(t.ID); err != nil {
+		return err
+	}
+	mentions := p.lichessMentions()
+	results, err := p.finishArena(ctx, p.newStreamingLichessClient(), t, arena, mentions)
+	if err != nil {
+		return err
+	}
+	return p.updateTournamentPost(t, p.arenaMessage(t, arena, results, mentions))
+}
+
+// archiveExpiredArena archives an arena whose post stopped being followed
+// before the arena was seen to finish, so it doesn't stay in progress in
+// the history. The standings are the last ones Lichess has, if any.
+func (p *Plugin) archiveExpiredArena(post LivePost) {
+	t, err := p.getTournament(post.Key)
+	if err != nil {
+		p.API.LogWarn("failed to get tournament", "tournamentID", post.Key, "error", err.Error())
+		return
+	}
+	if t == nil || t.Finished {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client := p.newStreamingLichessClient()
+	arena, err := client.Arena(ctx, t.ID)
+	if err == nil {
+		_, err = p.finishArena(ctx, client, t, arena, p.lichessMentions())
+	}
+	if err == nil {
+		return
+	}
+	p.API.LogWarn("failed to get the standings of an expired arena", "tournamentID", t.ID, "error", err.Error())
+	if err := p.finishTournament(t, nil, t.NbPlayers); err != nil {
+		p.API.LogWarn("failed to archive expired arena", "tournamentID", t.ID, "error", err.Error())
+	}
+}
+
+// arenaEndTime is when an arena is due to end, or 0 when unknown.
+func arenaEndTime(arena *lichess.Arena) int64 {
+	startsAt := lichessTime(arena.StartsAt, 0)
+	if startsAt == 0 {
+		return 0
+	}
+	return startsAt + (time.Duration(arena.Minutes) * time.Minute).Milliseconds()
+}
+
+// arenaMessage shows an arena with the first standingsShown of its results.
+func (p *Plugin) arenaMessage(t *TrackedTournament, arena *lichess.Arena, results []*lichess.ArenaResult, mentions map[string]string) string {
+	url := fmt.Sprintf("%stournament/%s", p.getConfiguration().getBaseURL(), arena.Id)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### [%s](%s)\n", arena.FullName, url)
+	fmt.Fprintf(&b, "%s arena, %d minutes", t.TimeControl, arena.Minutes)
+	if arena.Variant != "" && arena.Variant != string(chess.Standard) {
+		if v, err := chess.ParseVariant(arena.Variant); err == nil {
+			fmt.Fprintf(&b, ", %s", v.Name())
+		}
+	}
+	if !arena.Rated {
+		b.WriteString(", casual")
+	}
+	fmt.Fprintf(&b, ". %d players.\n", arena.NbPlayers)
+
+	switch {
+	case arena.IsFinished:
+		b.WriteString("**Finished.**\n")
+	case arena.IsStarted:
+		fmt.Fprintf(&b, "**In progress**, %s left. [Join the tournament](%s)\n", formatWait(arena.SecondsToFinish), url)
+	default:
+		fmt.Fprintf(&b, "**Starts in %s.** [Join the tournament](%s)\n", formatWait(arena.SecondsToStart), url)
+	}
+
+	if len(results) > standingsShown {
+		results = results[:standingsShown]
+	}
+	if len(results) > 0 {
+		b.WriteString("\n| # | Player | Rating | Score |\n")
+		b.WriteString("|--:|:-------|-------:|------:|\n")
+		for _, result := range results {
+			fmt.Fprintf(&b, "| %d | %s | %d | %d |\n", result.Rank, playerName(result.Username, result.Title, mentions), result.Rating, result.Score)
+		}
+	}
+	return b.String()
+}
+
+func arenaPodiumMessage(t *TrackedTournament, podium []*lichess.ArenaResult, mentions map[string]string) string {
+	if len(podium) == 0 {
+		return fmt.Sprintf("%s has ended without players.", t.Name)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### :trophy: %s has ended\n", t.Name)
+	for _, result := range podium {
+		fmt.Fprintf(&b, "%s %s with %d points, performance %d\n", placeEmoji(result.Rank), playerName(result.Username, result.Title, mentions), result.Score, result.Performance)
+	}
+	return b.String()
+}
+
+// formatWait formats a number of seconds as minutes, or hours and minutes.
+func formatWait(seconds int) string {
+	d := (time.Duration(seconds)*time.Second + time.Minute - 1).Truncate(time.Minute)
+	if d < time.Hour {
+		minutes := int(d / time.Minute)
+		if minutes == 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/render"
+)
+
+const pluginURLPath = "/plugins/com.mattermost.lichess-plugin"
+
+// boardImageURL returns an absolute link to the rendered position, suitable
+// for embedding in post markdown.
+func (p *Plugin) boardImageURL(fen, lastMove string, flip bool) string {
+	q := url.Values{}
+	q.Set("fen", fen)
+	if lastMove != "" {
+		q.Set("lastMove", lastMove)
+	}
+	if flip {
+		q.Set("flip", "true")
+	}
+	return *p.pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL + pluginURLPath + "/board.png?" + q.Encode()
+}
+
+func (p *Plugin) boardMarkdown(fen, lastMove string, flip bool) string {
+	return "![board](" + p.boardImageURL(fen, lastMove, flip) + ")"
+}
+
+// evalBoardMarkdown embeds the rendered position with an evaluation bar.
+func (p *Plugin) evalBoardMarkdown(fen, lastMove string, flip bool, score evalScore) string {
+	return "![board](" + p.boardImageURL(fen, lastMove, flip) + "&eval=" + url.QueryEscape(score.param()) + ")"
+}
+
+// streamFEN completes the piece placement sent by Lichess streams to a FEN.
+// The side to move is the one that did not play the last move, which is
+// needed to highlight checks.
+func streamFEN(placement, lastMove string) string {
+	if strings.Contains(placement, " ") {
+		return placement
+	}
+	turn := "w"
+	if len(lastMove) >= 4 {
+		pos, err := chess.ParseFEN(placement + " w - - 0 1")
+		to, sqErr := chess.ParseSquare(lastMove[2:4])
+		if err == nil && sqErr == nil && pos.Board[to] != chess.NoPiece && pos.Board[to].Color() == chess.White {
+			turn = "b"
+		}
+	}
+	return placement + " " + turn + " - - 0 1"
+}
+
+// handleBoardImage renders a FEN as a PNG. It is not authenticated, since
+// the image proxy fetches embedded images without the user's cookies.
+func (p *Plugin) handleBoardImage(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	pos, err := chess.ParseFEN(qs.Get("fen"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := render.BoardOptions{Flip: qs.Get("flip") == "true"}
+	if lm := qs.Get("lastMove"); len(lm) >= 4 {
+		from, fromErr := chess.ParseSquare(lm[0:2])
+		to, toErr := chess.ParseSquare(lm[2:4])
+		if fromErr == nil && toErr == nil {
+			opts.LastMove = &chess.Move{From: from, To: to}
+		}
+	}
+
+	img := render.Board(pos, opts)
+	if score, ok := parseEvalScore(qs.Get("eval")); ok {
+		img = render.BoardWithEvalBar(pos, opts, score.whiteShare())
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	if err := png.Encode(w, img); err != nil {
+		p.API.LogWarn("failed to encode board image", "error", err.Error())
+	}
+}
@@ -0,0 +1,235 @@
+package chess
+
+import (
+	"github.com/pkg/errors"
+)
+
+type Result string
+
+const (
+	NoResult  Result = "*"
+	WhiteWins Result = "1-0"
+	BlackWins Result = "0-1"
+	Draw      Result = "1/2-1/2"
+)
+
+// Winner returns the winning color, and false for draws and unfinished games.
+func (r Result) Winner() (Color, bool) {
+	switch r {
+	case WhiteWins:
+		return White, true
+	case BlackWins:
+		return Black, true
+	default:
+		return White, false
+	}
+}
+
+func winFor(c Color) Result {
+	if c == White {
+		return WhiteWins
+	}
+	return BlackWins
+}
+
+type Termination string
+
+const (
+	Checkmate            Termination = "checkmate"
+	Stalemate            Termination = "stalemate"
+	InsufficientMaterial Termination = "insufficient material"
+	ThreefoldRepetition  Termination = "threefold repetition"
+	FiftyMoveRule        Termination = "fifty-move rule"
+	Resignation          Termination = "resignation"
+	DrawAgreement        Termination = "draw agreement"
+
+	KingInTheCenter  Termination = "king in the centre"
+	ThirdCheck       Termination = "third check"
+	KingExploded     Termination = "king explosion"
+	HordeDestroyed   Termination = "destroying the horde"
+	AllPiecesLost    Termination = "losing all pieces"
+	KingReachedGoal  Termination = "king reaching the eighth rank"
+	KingsReachedGoal Termination = "both kings reaching the eighth rank"
+)
+
+// Game is a sequence of moves from a starting position together with its
+// outcome.
+type Game struct {
+	positions   []*Position
+	moves       []Move
+	result      Result
+	termination Termination
+}
+
+func NewGame(start *Position) *Game {
+	g := &Game{
+		positions: []*Position{start},
+		result:    NoResult,
+	}
+	g.updateOutcome()
+	return g
+}
+
+// Position returns the current position.
+func (g *Game) Position() *Position {
+	return g.positions[len(g.positions)-1]
+}
+
+// StartingPosition returns the position before the first move.
+func (g *Game) StartingPosition() *Position {
+	return g.positions[0]
+}
+
+// Positions returns every position of the game, starting position first.
+func (g *Game) Positions() []*Position {
+	return g.positions
+}
+
+func (g *Game) Moves() []Move {
+	return g.moves
+}
+
+func (g *Game) Result() Result {
+	return g.result
+}
+
+func (g *Game) Termination() Termination {
+	return g.termination
+}
+
+func (g *Game) IsOver() bool {
+	return g.result != NoResult
+}
+
+// Play validates and plays m.
+func (g *Game) Play(m Move) error {
+	if g.IsOver() {
+		return errors.New("the game is over")
+	}
+	pos := g.Position()
+	if !pos.IsLegal(m) {
+		return errors.Errorf("illegal move %s", pos.UCI(m))
+	}
+	g.positions = append(g.positions, pos.Play(m))
+	g.moves = append(g.moves, m)
+	g.updateOutcome()
+	return nil
+}
+
+// PlayMove parses s as SAN or UCI and plays it, returning the move in SAN.
+func (g *Game) PlayMove(s string) (string, error) {
+	if g.IsOver() {
+		return "", errors.New("the game is over")
+	}
+	pos := g.Position()
+	m, err := pos.ParseMove(s)
+	if err != nil {
+		return "", err
+	}
+	san := pos.SAN(m)
+	if err := g.Play(m); err != nil {
+		return "", err
+	}
+	return san, nil
+}
+
+// SANMoves returns the moves of the game in SAN.
+func (g *Game) SANMoves() []string {
+	sans := make([]string, len(g.moves))
+	for i, m := range g.moves {
+		sans[i] = g.positions[i].SAN(m)
+	}
+	return sans
+}
+
+// UCIMoves returns the moves of the game in UCI notation.
+func (g *Game) UCIMoves() []string {
+	ucis := make([]string, len(g.moves))
+	for i, m := range g.moves {
+		ucis[i] = g.positions[i].UCI(m)
+	}
+	return ucis
+}
+
+func (g *Game) Resign(c Color) {
+	if g.IsOver() {
+		return
+	}
+	g.result = winFor(c.Other())
+	g.termination = Resignation
+}
+
+func (g *Game) AgreeDraw() {
+	if g.IsOver() {
+		return
+	}
+	g.result = Draw
+	g.termination = DrawAgreement
+}
+
+func (g *Game) updateOutcome() {
+	pos := g.Position()
+
+	if result, termination, ok := pos.variantOutcome(); ok {
+		g.result, g.termination = result, termination
+		return
+	}
+
+	if !pos.HasLegalMoves() {
+		if pos.InCheck() {
+			g.result, g.termination = winFor(pos.Turn.Other()), Checkmate
+		} else {
+			g.result, g.termination = Draw, Stalemate
+		}
+		return
+	}
+
+	switch {
+	case pos.hasInsufficientMaterial():
+		g.result, g.termination = Draw, InsufficientMaterial
+	case pos.HalfmoveClock >= 100:
+		g.result, g.termination = Draw, FiftyMoveRule
+	case g.repetitions() >= 3:
+		g.result, g.termination = Draw, ThreefoldRepetition
+	}
+}
+
+func (g *Game) repetitions() int {
+	key := g.Position().Key()
+	n := 0
+	for _, pos := range g.positions {
+		if pos.Key() == key {
+			n++
+		}
+	}
+	return n
+}
+
+// IsInsufficientMaterial reports whether neither side can possibly mate.
+func (p *Position) IsInsufficientMaterial() bool {
+	var minors [2]int
+	var bishopColors [2]bool
+	for sq := Square(0); sq < 64; sq++ {
+		pc := p.Board[sq]
+		switch pc.Type() {
+		case NoPieceType, King:
+		case Knight:
+			minors[pc.Color()]++
+		case Bishop:
+			minors[pc.Color()]++
+			bishopColors[(sq.File()+sq.Rank())%2] = true
+		default:
+			return false
+		}
+	}
+	if minors[White]+minors[Black] <= 1 {
+		return true
+	}
+	// Bishops only, all on squares of the same color.
+	for sq := Square(0); sq < 64; sq++ {
+		if p.Board[sq].Type() == Knight {
+			return false
+		}
+	}
+	return !(bishopColors[0] && bishopColors[1])
+}
@@ -0,0 +1,523 @@
+package chess
+
+// Move is a move in internal form. Castling is encoded as the king capturing
+// its own rook, which is unambiguous in both standard chess and Chess960.
+// Crazyhouse drops set Drop, with From equal to To.
+type Move struct {
+	From      Square
+	To        Square
+	Promotion PieceType
+	Drop      PieceType
+}
+
+func (m Move) IsDrop() bool {
+	return m.Drop != NoPieceType
+}
+
+var (
+	knightOffsets = [8][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingOffsets   = [8][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	bishopDirs    = [4][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	rookDirs      = [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	promotions    = []PieceType{Queen, Rook, Bishop, Knight}
+)
+
+func forward(c Color) int {
+	if c == White {
+		return 1
+	}
+	return -1
+}
+
+// IsCastle reports whether m is a castling move in p.
+func (p *Position) IsCastle(m Move) bool {
+	if m.IsDrop() {
+		return false
+	}
+	pc := p.Board[m.From]
+	return pc.Type() == King && p.Board[m.To] == NewPiece(pc.Color(), Rook) &&
+		(p.Castling[pc.Color()][KingSide] == m.To || p.Castling[pc.Color()][QueenSide] == m.To)
+}
+
+// IsCapture reports whether m captures a piece, including en passant.
+func (p *Position) IsCapture(m Move) bool {
+	if m.IsDrop() || p.IsCastle(m) {
+		return false
+	}
+	if p.Board[m.To] != NoPiece {
+		return true
+	}
+	return p.Board[m.From].Type() == Pawn && m.To == p.EnPassant
+}
+
+// IsAttacked reports whether any piece of color by attacks sq.
+func (p *Position) IsAttacked(sq Square, by Color) bool {
+	for _, d := range [2]int{-1, 1} {
+		if from, ok := sq.Offset(d, -forward(by)); ok && p.Board[from] == NewPiece(by, Pawn) {
+			return true
+		}
+	}
+	for _, o := range knightOffsets {
+		if from, ok := sq.Offset(o[0], o[1]); ok && p.Board[from] == NewPiece(by, Knight) {
+			return true
+		}
+	}
+	// Kings cannot capture in Atomic, since they would explode themselves.
+	if p.Variant != Atomic {
+		for _, o := range kingOffsets {
+			if from, ok := sq.Offset(o[0], o[1]); ok && p.Board[from] == NewPiece(by, King) {
+				return true
+			}
+		}
+	}
+	if p.slidingAttack(sq, by, bishopDirs[:], Bishop) || p.slidingAttack(sq, by, rookDirs[:], Rook) {
+		return true
+	}
+	return false
+}
+
+func (p *Position) slidingAttack(sq Square, by Color, dirs [][2]int, t PieceType) bool {
+	for _, d := range dirs {
+		cur := sq
+		for {
+			next, ok := cur.Offset(d[0], d[1])
+			if !ok {
+				break
+			}
+			pc := p.Board[next]
+			if pc != NoPiece {
+				if pc.Color() == by && (pc.Type() == t || pc.Type() == Queen) {
+					return true
+				}
+				break
+			}
+			cur = next
+		}
+	}
+	return false
+}
+
+// InCheck reports whether the side to move is in check.
+func (p *Position) InCheck() bool {
+	return p.kingAttacked(p.Turn)
+}
+
+func (p *Position) kingAttacked(c Color) bool {
+	if p.Variant == Antichess {
+		return false
+	}
+	king := p.KingSquare(c)
+	if king == NoSquare {
+		return false
+	}
+	if p.Variant == Atomic {
+		// Touching kings cannot attack each other.
+		if other := p.KingSquare(c.Other()); other != NoSquare &&
+			abs(king.File()-other.File()) <= 1 && abs(king.Rank()-other.Rank()) <= 1 {
+			return false
+		}
+	}
+	return p.IsAttacked(king, c.Other())
+}
+
+// LegalMoves returns every legal move for the side to move.
+func (p *Position) LegalMoves() []Move {
+	var legal []Move
+	for _, m := range p.pseudoLegalMoves() {
+		if p.isLegal(m) {
+			legal = append(legal, m)
+		}
+	}
+
+	// Captures are compulsory in Antichess.
+	if p.Variant == Antichess {
+		var captures []Move
+		for _, m := range legal {
+			if p.IsCapture(m) {
+				captures = append(captures, m)
+			}
+		}
+		if len(captures) > 0 {
+			return captures
+		}
+	}
+	return legal
+}
+
+// HasLegalMoves is a cheaper LegalMoves for end of game detection.
+func (p *Position) HasLegalMoves() bool {
+	for _, m := range p.pseudoLegalMoves() {
+		if p.isLegal(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLegal reports whether m is legal in p.
+func (p *Position) IsLegal(m Move) bool {
+	for _, legal := range p.LegalMoves() {
+		if legal == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Position) isLegal(m Move) bool {
+	next := p.Play(m)
+	us := p.Turn
+
+	switch p.Variant {
+	case Antichess:
+		return true
+	case Atomic:
+		if next.KingSquare(us) == NoSquare {
+			return false
+		}
+		if next.KingSquare(us.Other()) == NoSquare {
+			return true
+		}
+	case RacingKings:
+		// Giving check is not allowed either.
+		if next.kingAttacked(us.Other()) {
+			return false
+		}
+	}
+	return !next.kingAttacked(us)
+}
+
+func (p *Position) pseudoLegalMoves() []Move {
+	moves := make([]Move, 0, 48)
+	us := p.Turn
+	for from := Square(0); from < 64; from++ {
+		pc := p.Board[from]
+		if pc == NoPiece || pc.Color() != us {
+			continue
+		}
+		switch pc.Type() {
+		case Pawn:
+			moves = p.pawnMoves(moves, from)
+		case Knight:
+			moves = p.stepMoves(moves, from, knightOffsets[:])
+		case Bishop:
+			moves = p.slideMoves(moves, from, bishopDirs[:])
+		case Rook:
+			moves = p.slideMoves(moves, from, rookDirs[:])
+		case Queen:
+			moves = p.slideMoves(moves, from, bishopDirs[:])
+			moves = p.slideMoves(moves, from, rookDirs[:])
+		case King:
+			moves = p.stepMoves(moves, from, kingOffsets[:])
+			moves = p.castlingMoves(moves, from)
+		}
+	}
+
+	if p.Variant == Crazyhouse {
+		moves = p.dropMoves(moves)
+	}
+	return moves
+}
+
+func (p *Position) dropMoves(moves []Move) []Move {
+	for t := Pawn; t < King; t++ {
+		if p.Pockets[p.Turn][t] == 0 {
+			continue
+		}
+		for to := Square(0); to < 64; to++ {
+			if p.Board[to] != NoPiece || (t == Pawn && (to.Rank() == 0 || to.Rank() == 7)) {
+				continue
+			}
+			moves = append(moves, Move{From: to, To: to, Drop: t})
+		}
+	}
+	return moves
+}
+
+func (p *Position) pawnMoves(moves []Move, from Square) []Move {
+	us := p.Turn
+	dir := forward(us)
+	lastRank := backRank(us.Other())
+
+	add := func(to Square) {
+		if to.Rank() == lastRank {
+			for _, t := range promotions {
+				moves = append(moves, Move{From: from, To: to, Promotion: t})
+			}
+			if p.Variant == Antichess {
+				moves = append(moves, Move{From: from, To: to, Promotion: King})
+			}
+			return
+		}
+		moves = append(moves, Move{From: from, To: to})
+	}
+
+	// Horde pawns on the first rank may advance two squares as well.
+	doubleStep := from.Rank() == backRank(us)+dir || (p.Variant == Horde && from.Rank() == backRank(us))
+
+	if to, ok := from.Offset(0, dir); ok && p.Board[to] == NoPiece {
+		add(to)
+		if doubleStep {
+			if to2, ok := to.Offset(0, dir); ok && p.Board[to2] == NoPiece {
+				add(to2)
+			}
+		}
+	}
+
+	for _, df := range [2]int{-1, 1} {
+		to, ok := from.Offset(df, dir)
+		if !ok {
+			continue
+		}
+		target := p.Board[to]
+		if (target != NoPiece && target.Color() != us) || to == p.EnPassant {
+			add(to)
+		}
+	}
+	return moves
+}
+
+func (p *Position) stepMoves(moves []Move, from Square, offsets [][2]int) []Move {
+	for _, o := range offsets {
+		to, ok := from.Offset(o[0], o[1])
+		if !ok {
+			continue
+		}
+		if target := p.Board[to]; target == NoPiece || target.Color() != p.Turn {
+			moves = append(moves, Move{From: from, To: to})
+		}
+	}
+	return moves
+}
+
+func (p *Position) slideMoves(moves []Move, from Square, dirs [][2]int) []Move {
+	for _, d := range dirs {
+		cur := from
+		for {
+			to, ok := cur.Offset(d[0], d[1])
+			if !ok {
+				break
+			}
+			target := p.Board[to]
+			if target == NoPiece {
+				moves = append(moves, Move{From: from, To: to})
+				cur = to
+				continue
+			}
+			if target.Color() != p.Turn {
+				moves = append(moves, Move{From: from, To: to})
+			}
+			break
+		}
+	}
+	return moves
+}
+
+// castlingDestinations returns where the king and rook end up when castling
+// towards side.
+func castlingDestinations(c Color, side int) (king, rook Square) {
+	rank := backRank(c)
+	if side == KingSide {
+		return NewSquare(6, rank), NewSquare(5, rank)
+	}
+	return NewSquare(2, rank), NewSquare(3, rank)
+}
+
+func (p *Position) castlingMoves(moves []Move, king Square) []Move {
+	us := p.Turn
+	if king.Rank() != backRank(us) || p.InCheck() {
+		return moves
+	}
+	for _, side := range []int{KingSide, QueenSide} {
+		rook := p.Castling[us][side]
+		if rook == NoSquare || p.Board[rook] != NewPiece(us, Rook) {
+			continue
+		}
+		kingTo, rookTo := castlingDestinations(us, side)
+
+		lo, hi := minSquare(king, rook, kingTo, rookTo), maxSquare(king, rook, kingTo, rookTo)
+		blocked := false
+		for sq := lo; sq <= hi; sq++ {
+			if sq != king && sq != rook && p.Board[sq] != NoPiece {
+				blocked = true
+				break
+			}
+		}
+		if blocked {
+			continue
+		}
+
+		// The king may not pass through an attacked square. The rook is lifted
+		// first so it cannot shield the king's path.
+		without := p.Clone()
+		without.Board[rook] = NoPiece
+		step := Square(1)
+		if kingTo < king {
+			step = -1
+		}
+		attacked := false
+		for sq := king; ; sq += step {
+			if without.IsAttacked(sq, us.Other()) {
+				attacked = true
+				break
+			}
+			if sq == kingTo {
+				break
+			}
+		}
+		if !attacked {
+			moves = append(moves, Move{From: king, To: rook})
+		}
+	}
+	return moves
+}
+
+func minSquare(squares ...Square) Square {
+	m := squares[0]
+	for _, s := range squares[1:] {
+		if s < m {
+			m = s
+		}
+	}
+	return m
+}
+
+func maxSquare(squares ...Square) Square {
+	m := squares[0]
+	for _, s := range squares[1:] {
+		if s > m {
+			m = s
+		}
+	}
+	return m
+}
+
+// Play returns the position after m. The move is assumed to be legal.
+func (p *Position) Play(m Move) *Position {
+	next := p.Clone()
+	us := p.Turn
+	pc := p.Board[m.From]
+	captured := p.Board[m.To]
+	capturedAt := m.To
+
+	next.EnPassant = NoSquare
+	next.HalfmoveClock++
+
+	switch {
+	case m.IsDrop():
+		next.Pockets[us][m.Drop]--
+		next.Board[m.To] = NewPiece(us, m.Drop)
+		next.Promoted[m.To] = false
+		pc, captured = NewPiece(us, m.Drop), NoPiece
+	case p.IsCastle(m):
+		side := KingSide
+		if p.Castling[us][QueenSide] == m.To {
+			side = QueenSide
+		}
+		kingTo, rookTo := castlingDestinations(us, side)
+		next.Board[m.From] = NoPiece
+		next.Board[m.To] = NoPiece
+		next.Board[kingTo] = NewPiece(us, King)
+		next.Board[rookTo] = NewPiece(us, Rook)
+		captured = NoPiece
+	case pc.Type() == Pawn:
+		next.HalfmoveClock = 0
+		next.Board[m.From] = NoPiece
+		next.Promoted[m.From] = false
+		if m.To == p.EnPassant && captured == NoPiece {
+			capturedAt, _ = m.To.Offset(0, -forward(us))
+			captured = p.Board[capturedAt]
+			next.Board[capturedAt] = NoPiece
+		}
+		if m.Promotion != NoPieceType {
+			next.Board[m.To] = NewPiece(us, m.Promotion)
+			// Only Crazyhouse demotes promoted pieces when captured.
+			next.Promoted[m.To] = p.Variant == Crazyhouse
+		} else {
+			next.Board[m.To] = pc
+			next.Promoted[m.To] = false
+		}
+		if abs(m.To.Rank()-m.From.Rank()) == 2 && m.From.Rank() == backRank(us)+forward(us) {
+			ep, _ := m.From.Offset(0, forward(us))
+			if p.enPassantCapturable(ep, m.To) {
+				next.EnPassant = ep
+			}
+		}
+	default:
+		next.Board[m.From] = NoPiece
+		next.Board[m.To] = pc
+		next.Promoted[m.To] = p.Promoted[m.From]
+		next.Promoted[m.From] = false
+	}
+
+	if captured != NoPiece {
+		next.HalfmoveClock = 0
+
+		if p.Variant == Crazyhouse {
+			t := captured.Type()
+			if p.Promoted[capturedAt] {
+				t = Pawn
+			}
+			next.Pockets[us][t]++
+		}
+		if p.Variant == Atomic {
+			next.explode(m.To)
+		}
+	}
+
+	if pc.Type() == King {
+		next.Castling[us] = [2]Square{NoSquare, NoSquare}
+	}
+	for _, c := range []Color{White, Black} {
+		for side, rook := range next.Castling[c] {
+			if rook == NoSquare {
+				continue
+			}
+			// Covers rooks that moved, were captured or exploded.
+			if next.Board[rook] != NewPiece(c, Rook) || next.KingSquare(c) == NoSquare {
+				next.Castling[c][side] = NoSquare
+			}
+		}
+	}
+
+	if us == Black {
+		next.FullmoveNumber++
+	}
+	next.Turn = us.Other()
+
+	if p.Variant == ThreeCheck && next.InCheck() {
+		next.ChecksGiven[us]++
+	}
+	return next
+}
+
+// explode removes the capturing piece and every piece but pawns around the
+// square of an Atomic capture.
+func (p *Position) explode(at Square) {
+	p.Board[at] = NoPiece
+	for _, o := range kingOffsets {
+		if sq, ok := at.Offset(o[0], o[1]); ok && p.Board[sq].Type() != Pawn {
+			p.Board[sq] = NoPiece
+		}
+	}
+}
+
+// enPassantCapturable reports whether an enemy pawn stands next to the pawn
+// that just advanced two squares, so the en passant square only appears in
+// FENs when it matters.
+func (p *Position) enPassantCapturable(ep, pawn Square) bool {
+	them := p.Turn.Other()
+	for _, df := range [2]int{-1, 1} {
+		if sq, ok := pawn.Offset(df, 0); ok && p.Board[sq] == NewPiece(them, Pawn) {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
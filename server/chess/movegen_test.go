@@ -0,0 +1,50 @@
+package chess
+
+import (
+	"testing"
+)
+
+func perft(p *Position, depth int) int {
+	moves := p.LegalMoves()
+	if depth == 1 {
+		return len(moves)
+	}
+	n := 0
+	for _, m := range moves {
+		n += perft(p.Play(m), depth-1)
+	}
+	return n
+}
+
+// The positions and counts are the usual ones from the Chess Programming
+// Wiki's perft results page.
+var perftTests = []struct {
+	name   string
+	fen    string
+	counts []int
+}{
+	{"start", StartingFEN, []int{20, 400, 8902, 197281, 4865609}},
+	{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862, 4085603}},
+	{"position 3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+	{"position 4", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+	{"position 4 mirrored", "r2q1rk1/pP1p2pp/Q4n2/bbp1p3/Np6/1B3NBn/pPPP1PPP/R3K2R b KQ - 0 1", []int{6, 264, 9467}},
+	{"position 5", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", []int{44, 1486, 62379}},
+}
+
+func TestPerft(t *testing.T) {
+	for _, test := range perftTests {
+		pos, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, want := range test.counts {
+			depth := i + 1
+			if testing.Short() && want > 10000 {
+				break
+			}
+			if got := perft(pos, depth); got != want {
+				t.Errorf("%s: perft(%d) = %d, want %d", test.name, depth, got, want)
+			}
+		}
+	}
+}
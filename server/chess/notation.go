@@ -0,0 +1,199 @@
+package chess
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UCI returns the move in UCI notation.
+func (p *Position) UCI(m Move) string {
+	if m.IsDrop() {
+		return m.Drop.Letter() + "@" + m.To.String()
+	}
+	to := m.To
+	if p.IsCastle(m) && p.Variant != Chess960 {
+		side := KingSide
+		if p.Castling[p.Turn][QueenSide] == m.To {
+			side = QueenSide
+		}
+		to, _ = castlingDestinations(p.Turn, side)
+	}
+	s := m.From.String() + to.String()
+	if m.Promotion != NoPieceType {
+		s += strings.ToLower(m.Promotion.Letter())
+	}
+	return s
+}
+
+// SAN returns the move in standard algebraic notation, including the check
+// or mate suffix.
+func (p *Position) SAN(m Move) string {
+	s := p.sanWithoutSuffix(m)
+
+	next := p.Play(m)
+	if next.InCheck() {
+		if !next.HasLegalMoves() {
+			return s + "#"
+		}
+		return s + "+"
+	}
+	return s
+}
+
+func (p *Position) sanWithoutSuffix(m Move) string {
+	if m.IsDrop() {
+		return m.Drop.Letter() + "@" + m.To.String()
+	}
+	if p.IsCastle(m) {
+		if p.Castling[p.Turn][QueenSide] == m.To {
+			return "O-O-O"
+		}
+		return "O-O"
+	}
+
+	pc := p.Board[m.From]
+	var b strings.Builder
+	capture := p.IsCapture(m)
+
+	if pc.Type() == Pawn {
+		if capture {
+			b.WriteByte(byte('a' + m.From.File()))
+			b.WriteByte('x')
+		}
+		b.WriteString(m.To.String())
+		if m.Promotion != NoPieceType {
+			b.WriteString("=" + m.Promotion.Letter())
+		}
+		return b.String()
+	}
+
+	b.WriteString(pc.Type().Letter())
+	b.WriteString(p.disambiguation(m))
+	if capture {
+		b.WriteByte('x')
+	}
+	b.WriteString(m.To.String())
+	return b.String()
+}
+
+func (p *Position) disambiguation(m Move) string {
+	pc := p.Board[m.From]
+	ambiguous, sameFile, sameRank := false, false, false
+	for _, other := range p.LegalMoves() {
+		if other.To != m.To || other.From == m.From || other.IsDrop() || p.Board[other.From] != pc || p.IsCastle(other) {
+			continue
+		}
+		ambiguous = true
+		if other.From.File() == m.From.File() {
+			sameFile = true
+		}
+		if other.From.Rank() == m.From.Rank() {
+			sameRank = true
+		}
+	}
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return m.From.String()[:1]
+	case !sameRank:
+		return m.From.String()[1:]
+	default:
+		return m.From.String()
+	}
+}
+
+// ParseMove accepts a move in SAN or UCI notation and returns the matching
+// legal move.
+func (p *Position) ParseMove(s string) (Move, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "+#!?")
+	s = strings.ReplaceAll(s, "0", "O")
+	if s == "" {
+		return Move{}, errors.New("empty move")
+	}
+	if strings.HasPrefix(s, "@") {
+		s = "P" + s
+	}
+
+	legal := p.LegalMoves()
+
+	if m, ok := p.parseUCI(s, legal); ok {
+		return m, nil
+	}
+
+	want := normalizeSAN(s)
+	for _, m := range legal {
+		if normalizeSAN(p.sanWithoutSuffix(m)) == want {
+			return m, nil
+		}
+	}
+	return Move{}, errors.Errorf("illegal or unknown move %q", s)
+}
+
+func normalizeSAN(s string) string {
+	s = strings.ReplaceAll(s, "x", "")
+	s = strings.ReplaceAll(s, "=", "")
+	s = strings.ReplaceAll(s, "-", "")
+	return s
+}
+
+func (p *Position) parseUCI(s string, legal []Move) (Move, bool) {
+	if len(s) == 4 && s[1] == '@' {
+		return p.parseDrop(s, legal)
+	}
+
+	s = strings.ToLower(s)
+	if len(s) != 4 && len(s) != 5 {
+		return Move{}, false
+	}
+	from, err := ParseSquare(s[0:2])
+	if err != nil {
+		return Move{}, false
+	}
+	to, err := ParseSquare(s[2:4])
+	if err != nil {
+		return Move{}, false
+	}
+	promotion := NoPieceType
+	if len(s) == 5 {
+		promotion = PieceTypeFromLetter(s[4])
+		if promotion == NoPieceType {
+			return Move{}, false
+		}
+	}
+
+	for _, m := range legal {
+		if m.IsDrop() || m.From != from || m.Promotion != promotion {
+			continue
+		}
+		if m.To == to {
+			return m, true
+		}
+		if p.IsCastle(m) {
+			side := KingSide
+			if p.Castling[p.Turn][QueenSide] == m.To {
+				side = QueenSide
+			}
+			if kingTo, _ := castlingDestinations(p.Turn, side); kingTo == to {
+				return m, true
+			}
+		}
+	}
+	return Move{}, false
+}
+
+func (p *Position) parseDrop(s string, legal []Move) (Move, bool) {
+	t := PieceTypeFromLetter(s[0])
+	to, err := ParseSquare(s[2:4])
+	if t == NoPieceType || err != nil {
+		return Move{}, false
+	}
+	for _, m := range legal {
+		if m.Drop == t && m.To == to {
+			return m, true
+		}
+	}
+	return Move{}, false
+}
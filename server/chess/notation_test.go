@@ -0,0 +1,86 @@
+package chess
+
+import (
+	"testing"
+)
+
+func TestSAN(t *testing.T) {
+	tests := []struct {
+		fen string
+		uci string
+		san string
+	}{
+		{StartingFEN, "g1f3", "Nf3"},
+		{StartingFEN, "e2e4", "e4"},
+		{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", "e1g1", "O-O"},
+		{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", "e1c1", "O-O-O"},
+		{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", "e5f7", "Nxf7"},
+		{"4k3/8/8/8/8/8/8/R4RK1 w - - 0 1", "a1c1", "Rac1"},
+		{"4k3/8/8/8/R7/8/8/R3K3 w - - 0 1", "a1a2", "R1a2"},
+		{"4k3/8/8/8/8/Q7/8/Q1Q1K3 w - - 0 1", "a1b2", "Qa1b2"},
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 2", "e5d6", "exd6"},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8q", "b8=Q+"},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8n", "b8=N"},
+		{"rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq - 0 2", "d8h4", "Qh4#"},
+	}
+	for _, test := range tests {
+		pos, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := pos.ParseMove(test.uci)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.fen, test.uci, err)
+			continue
+		}
+		if san := pos.SAN(m); san != test.san {
+			t.Errorf("%s %s: got SAN %s, want %s", test.fen, test.uci, san, test.san)
+		}
+		if uci := pos.UCI(m); uci != test.uci {
+			t.Errorf("%s %s: got UCI %s", test.fen, test.uci, uci)
+		}
+	}
+}
+
+// TestNotationRoundTrip checks that every legal move of the perft positions,
+// and of the positions one move later, reads back from its SAN and UCI.
+func TestNotationRoundTrip(t *testing.T) {
+	for _, test := range perftTests {
+		pos, err := ParseFEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions := []*Position{pos}
+		for _, m := range pos.LegalMoves() {
+			positions = append(positions, pos.Play(m))
+		}
+		for _, p := range positions {
+			seen := map[string]bool{}
+			for _, m := range p.LegalMoves() {
+				san := p.SAN(m)
+				if seen[san] {
+					t.Errorf("%s: SAN %s names two moves", p.FEN(), san)
+				}
+				seen[san] = true
+
+				for _, s := range []string{san, p.UCI(m)} {
+					parsed, err := p.ParseMove(s)
+					if err != nil {
+						t.Errorf("%s: %s: %v", p.FEN(), s, err)
+					} else if parsed != m {
+						t.Errorf("%s: %s read back as %s", p.FEN(), s, p.UCI(parsed))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParseMoveRejects(t *testing.T) {
+	pos := NewPosition()
+	for _, s := range []string{"", "e5", "Ke2", "O-O", "e2e5", "e7e5", "z9"} {
+		if _, err := pos.ParseMove(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
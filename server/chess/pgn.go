@@ -0,0 +1,283 @@
+package chess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PGNGame is a game read from PGN. Only the mainline is kept; comments,
+// annotations and variations are skipped.
+type PGNGame struct {
+	Tags   map[string]string
+	Moves  []string
+	Result Result
+}
+
+// Tag returns the value of a PGN tag, or "" when missing.
+func (g *PGNGame) Tag(name string) string {
+	return g.Tags[name]
+}
+
+// Variant returns the variant named by the Variant tag.
+func (g *PGNGame) Variant() Variant {
+	v, err := ParseVariant(g.Tag("Variant"))
+	if err != nil {
+		return Standard
+	}
+	return v
+}
+
+// Replay plays the mainline from the starting position of the game.
+func (g *PGNGame) Replay() (*Game, error) {
+	variant := g.Variant()
+	start := variant.StartingPosition()
+	if fen := g.Tag("FEN"); fen != "" {
+		var err error
+		start, err = ParseVariantFEN(variant, fen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	game := NewGame(start)
+	for _, san := range g.Moves {
+		if _, err := game.PlayMove(san); err != nil {
+			return nil, errors.Wrapf(err, "failed to replay move %s", san)
+		}
+	}
+	return game, nil
+}
+
+// ParsePGN reads every game of a PGN database.
+func ParsePGN(r io.Reader) ([]*PGNGame, error) {
+	var games []*PGNGame
+	var current *PGNGame
+	var movetext strings.Builder
+
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if err := current.parseMovetext(movetext.String()); err != nil {
+			return err
+		}
+		games = append(games, current)
+		current = nil
+		movetext.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	inMovetext := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "%"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			if inMovetext {
+				if err := finish(); err != nil {
+					return nil, err
+				}
+				inMovetext = false
+			}
+			if current == nil {
+				current = &PGNGame{Tags: map[string]string{}, Result: NoResult}
+			}
+			name, value, err := parseTag(line)
+			if err != nil {
+				return nil, err
+			}
+			current.Tags[name] = value
+		default:
+			if current == nil {
+				current = &PGNGame{Tags: map[string]string{}, Result: NoResult}
+			}
+			inMovetext = true
+			movetext.WriteString(line)
+			movetext.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read PGN")
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+func parseTag(line string) (string, string, error) {
+	inner := strings.TrimSpace(line[1 : len(line)-1])
+	i := strings.IndexAny(inner, " \t")
+	if i < 0 {
+		return "", "", errors.Errorf("invalid PGN tag %s", line)
+	}
+	name := inner[:i]
+	value := strings.TrimSpace(inner[i:])
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", "", errors.Errorf("invalid PGN tag %s", line)
+	}
+	value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	value = strings.ReplaceAll(value, `\\`, `\`)
+	return name, value, nil
+}
+
+func (g *PGNGame) parseMovetext(text string) error {
+	depth := 0
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return errors.New("unterminated PGN comment")
+			}
+			i += end + 1
+		case c == ';':
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			i += end
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case c == ' ' || c == '\n' || c == '\t' || c == '\r':
+			i++
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \n\t\r{}();", rune(text[j])) {
+				j++
+			}
+			token := text[i:j]
+			i = j
+			if depth > 0 {
+				continue
+			}
+			g.addToken(token)
+		}
+	}
+	if depth != 0 {
+		return errors.New("unbalanced PGN variation")
+	}
+	if g.Result == NoResult {
+		if r := Result(g.Tag("Result")); r == WhiteWins || r == BlackWins || r == Draw {
+			g.Result = r
+		}
+	}
+	return nil
+}
+
+func (g *PGNGame) addToken(token string) {
+	switch Result(token) {
+	case WhiteWins, BlackWins, Draw, NoResult:
+		g.Result = Result(token)
+		return
+	}
+	if strings.HasPrefix(token, "$") {
+		return
+	}
+	// Strip move numbers, which may be glued to the move as in "1.e4".
+	if i := strings.IndexByte(token, '.'); i >= 0 && strings.Trim(token[:i], "0123456789") == "" {
+		token = strings.TrimLeft(token[i:], ".")
+	}
+	token = strings.TrimRight(token, "!?")
+	if token == "" {
+		return
+	}
+	g.Moves = append(g.Moves, token)
+}
+
+const pgnLineLength = 80
+
+var sevenTagRoster = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+
+// WritePGN writes the mainline of a game as PGN. The Seven Tag Roster comes
+// first, with "?" for missing tags, followed by the other tags sorted by
+// name. The Result, Variant, FEN and SetUp tags are taken from the game.
+func WritePGN(w io.Writer, game *Game, tags map[string]string) error {
+	all := make(map[string]string, len(tags)+4)
+	for name, value := range tags {
+		all[name] = value
+	}
+	all["Result"] = string(game.Result())
+
+	start := game.StartingPosition()
+	if start.Variant != Standard {
+		all["Variant"] = start.Variant.Name()
+	}
+	if fen := start.FEN(); start.Variant == Chess960 || fen != start.Variant.StartingPosition().FEN() {
+		all["FEN"] = fen
+		all["SetUp"] = "1"
+	}
+
+	var b strings.Builder
+	for _, name := range sevenTagRoster {
+		value := all[name]
+		if value == "" {
+			value = "?"
+		}
+		writeTag(&b, name, value)
+		delete(all, name)
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeTag(&b, name, all[name])
+	}
+	b.WriteString("\n")
+
+	number, turn := start.FullmoveNumber, start.Turn
+	tokens := make([]string, 0, len(game.moves)*3/2+1)
+	for i, san := range game.SANMoves() {
+		if turn == White {
+			tokens = append(tokens, strconv.Itoa(number)+".")
+		} else if i == 0 {
+			tokens = append(tokens, strconv.Itoa(number)+"...")
+		}
+		tokens = append(tokens, san)
+		if turn == Black {
+			number++
+		}
+		turn = turn.Other()
+	}
+	tokens = append(tokens, string(game.Result()))
+
+	lineLength := 0
+	for i, token := range tokens {
+		if i > 0 {
+			if lineLength+1+len(token) > pgnLineLength {
+				b.WriteString("\n")
+				lineLength = 0
+			} else {
+				b.WriteString(" ")
+				lineLength++
+			}
+		}
+		b.WriteString(token)
+		lineLength += len(token)
+	}
+	b.WriteString("\n\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeTag(b *strings.Builder, name, value string) {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	fmt.Fprintf(b, "[%s \"%s\"]\n", name, value)
+}
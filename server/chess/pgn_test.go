@@ -0,0 +1,79 @@
+package chess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const operaGame = `[Event "Paris"]
+[Site "Paris FRA"]
+[Date "1858.??.??"]
+[White "Paul Morphy"]
+[Black "Duke Karl / Count Isouard"]
+[Result "1-0"]
+
+1. e4 e5 2. Nf3 d6 3. d4 Bg4 {This is a weak move already.} 4. dxe5 Bxf3
+5. Qxf3 dxe5 6. Bc4 Nf6 7. Qb3 Qe7 8. Nc3 c6 9. Bg5 b5?! (9... Qb4 10. Qxb4)
+10. Nxb5 cxb5 11. Bxb5+ Nbd7 12. O-O-O Rd8 13. Rxd7 Rxd7 14. Rd1 Qe6
+15. Bxd7+ Nxd7 16. Qb8+ $1 Nxb8 17. Rd8# 1-0
+`
+
+func TestParsePGN(t *testing.T) {
+	games, err := ParsePGN(strings.NewReader(operaGame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 {
+		t.Fatalf("got %d games, want 1", len(games))
+	}
+	g := games[0]
+	if g.Tag("White") != "Paul Morphy" || g.Result != WhiteWins {
+		t.Errorf("got tags %v and result %s", g.Tags, g.Result)
+	}
+	if len(g.Moves) != 33 {
+		t.Errorf("got %d moves, want 33", len(g.Moves))
+	}
+
+	game, err := g.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if game.Result() != WhiteWins || game.Termination() != Checkmate {
+		t.Errorf("got %s by %s, want 1-0 by checkmate", game.Result(), game.Termination())
+	}
+}
+
+func TestPGNRoundTrip(t *testing.T) {
+	games, err := ParsePGN(strings.NewReader(operaGame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	game, err := games[0].Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := WritePGN(&b, game, games[0].Tags); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ParsePGN(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 {
+		t.Fatalf("got %d games, want 1", len(written))
+	}
+	if !reflect.DeepEqual(written[0].Moves, game.SANMoves()) {
+		t.Errorf("got moves %v, want %v", written[0].Moves, game.SANMoves())
+	}
+	// The missing tag of the Seven Tag Roster is written as unknown.
+	want := map[string]string{"Round": "?"}
+	for name, value := range games[0].Tags {
+		want[name] = value
+	}
+	if !reflect.DeepEqual(written[0].Tags, want) {
+		t.Errorf("got tags %v, want %v", written[0].Tags, want)
+	}
+}
@@ -0,0 +1,91 @@
+package chess
+
+import "strings"
+
+type Color int8
+
+const (
+	White Color = iota
+	Black
+)
+
+func (c Color) Other() Color {
+	return c ^ 1
+}
+
+func (c Color) String() string {
+	if c == White {
+		return "white"
+	}
+	return "black"
+}
+
+type PieceType int8
+
+const (
+	NoPieceType PieceType = iota
+	Pawn
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+const pieceLetters = " PNBRQK"
+
+// Letter returns the upper case SAN letter of the piece type.
+func (t PieceType) Letter() string {
+	if t <= NoPieceType || t > King {
+		return ""
+	}
+	return pieceLetters[t : t+1]
+}
+
+func PieceTypeFromLetter(b byte) PieceType {
+	i := strings.IndexByte(pieceLetters, upper(b))
+	if i <= 0 {
+		return NoPieceType
+	}
+	return PieceType(i)
+}
+
+// Piece packs a color and a piece type; the zero value is an empty square.
+type Piece int8
+
+const NoPiece Piece = 0
+
+func NewPiece(c Color, t PieceType) Piece {
+	return Piece(int8(c)<<3 | int8(t))
+}
+
+func (p Piece) Type() PieceType {
+	return PieceType(p & 7)
+}
+
+func (p Piece) Color() Color {
+	return Color(p >> 3)
+}
+
+// FEN returns the FEN letter of the piece.
+func (p Piece) FEN() byte {
+	l := pieceLetters[p.Type()]
+	if p.Color() == Black {
+		return lower(l)
+	}
+	return l
+}
+
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
+
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b - 'A' + 'a'
+	}
+	return b
+}
@@ -0,0 +1,400 @@
+package chess
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const StartingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+const (
+	KingSide = iota
+	QueenSide
+)
+
+// Position is a full game state. Castling rights are stored as the square of
+// the castling rook so that Chess960 positions are handled like standard ones.
+type Position struct {
+	Board          [64]Piece
+	Turn           Color
+	Castling       [2][2]Square
+	EnPassant      Square
+	HalfmoveClock  int
+	FullmoveNumber int
+	Variant        Variant
+
+	// Pockets holds the pieces each side can drop in Crazyhouse, indexed
+	// by piece type, and Promoted marks promoted pieces which are demoted
+	// to pawns when captured.
+	Pockets  [2][7]int
+	Promoted [64]bool
+
+	// ChecksGiven counts the checks delivered by each side in Three-check.
+	ChecksGiven [2]int
+}
+
+func NewPosition() *Position {
+	pos, err := ParseFEN(StartingFEN)
+	if err != nil {
+		panic(err)
+	}
+	return pos
+}
+
+func (p *Position) Clone() *Position {
+	clone := *p
+	return &clone
+}
+
+func (p *Position) KingSquare(c Color) Square {
+	k := NewPiece(c, King)
+	for sq := Square(0); sq < 64; sq++ {
+		if p.Board[sq] == k {
+			return sq
+		}
+	}
+	return NoSquare
+}
+
+func backRank(c Color) int {
+	if c == White {
+		return 0
+	}
+	return 7
+}
+
+func ParseFEN(fen string) (*Position, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, errors.Errorf("invalid FEN %q: expected at least 4 fields", fen)
+	}
+
+	pos := &Position{
+		EnPassant:      NoSquare,
+		FullmoveNumber: 1,
+		Variant:        Standard,
+		Castling:       [2][2]Square{{NoSquare, NoSquare}, {NoSquare, NoSquare}},
+	}
+
+	board := fields[0]
+	if i := strings.IndexByte(board, '['); i >= 0 && strings.HasSuffix(board, "]") {
+		if err := pos.parsePockets(board[i+1 : len(board)-1]); err != nil {
+			return nil, errors.Wrapf(err, "invalid FEN %q", fen)
+		}
+		board = board[:i]
+	}
+	ranks := strings.Split(board, "/")
+	if len(ranks) == 9 {
+		if err := pos.parsePockets(ranks[8]); err != nil {
+			return nil, errors.Wrapf(err, "invalid FEN %q", fen)
+		}
+		ranks = ranks[:8]
+	}
+	if len(ranks) != 8 {
+		return nil, errors.Errorf("invalid FEN %q: expected 8 ranks", fen)
+	}
+	for i, row := range ranks {
+		rank, file := 7-i, 0
+		for j := 0; j < len(row); j++ {
+			c := row[j]
+			switch {
+			case c >= '1' && c <= '8':
+				file += int(c - '0')
+			case c == '~':
+				if file == 0 || file > 8 {
+					return nil, errors.Errorf("invalid FEN %q: bad rank %q", fen, row)
+				}
+				pos.Promoted[NewSquare(file-1, rank)] = true
+			default:
+				t := PieceTypeFromLetter(c)
+				if t == NoPieceType || file > 7 {
+					return nil, errors.Errorf("invalid FEN %q: bad rank %q", fen, row)
+				}
+				color := White
+				if c >= 'a' && c <= 'z' {
+					color = Black
+				}
+				pos.Board[NewSquare(file, rank)] = NewPiece(color, t)
+				file++
+			}
+		}
+		if file != 8 {
+			return nil, errors.Errorf("invalid FEN %q: bad rank %q", fen, row)
+		}
+	}
+
+	switch fields[1] {
+	case "w":
+		pos.Turn = White
+	case "b":
+		pos.Turn = Black
+	default:
+		return nil, errors.Errorf("invalid FEN %q: bad side to move", fen)
+	}
+
+	if err := pos.parseCastling(fields[2]); err != nil {
+		return nil, errors.Wrapf(err, "invalid FEN %q", fen)
+	}
+
+	if fields[3] != "-" {
+		sq, err := ParseSquare(fields[3])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid FEN %q", fen)
+		}
+		pos.EnPassant = sq
+	}
+
+	// Three-check positions carry an extra field with the remaining checks
+	// ("3+3") before the clocks or the checks given ("+0+0") after them.
+	for i := 4; i < len(fields); i++ {
+		if strings.Contains(fields[i], "+") {
+			if err := pos.parseChecks(fields[i]); err != nil {
+				return nil, errors.Wrapf(err, "invalid FEN %q", fen)
+			}
+			fields = append(fields[:i:i], fields[i+1:]...)
+			break
+		}
+	}
+
+	if len(fields) > 4 {
+		n, err := strconv.Atoi(fields[4])
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid FEN %q: bad halfmove clock", fen)
+		}
+		pos.HalfmoveClock = n
+	}
+	if len(fields) > 5 {
+		n, err := strconv.Atoi(fields[5])
+		if err != nil || n < 1 {
+			return nil, errors.Errorf("invalid FEN %q: bad fullmove number", fen)
+		}
+		pos.FullmoveNumber = n
+	}
+
+	return pos, nil
+}
+
+func (p *Position) parsePockets(pockets string) error {
+	for i := 0; i < len(pockets); i++ {
+		t := PieceTypeFromLetter(pockets[i])
+		if t == NoPieceType || t == King {
+			return errors.Errorf("bad pocket piece %q", string(pockets[i]))
+		}
+		color := White
+		if pockets[i] >= 'a' && pockets[i] <= 'z' {
+			color = Black
+		}
+		p.Pockets[color][t]++
+	}
+	return nil
+}
+
+func (p *Position) parseChecks(field string) error {
+	given := strings.HasPrefix(field, "+")
+	parts := strings.Split(strings.TrimPrefix(field, "+"), "+")
+	if len(parts) != 2 {
+		return errors.Errorf("bad check counter %q", field)
+	}
+	for c, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 3 {
+			return errors.Errorf("bad check counter %q", field)
+		}
+		if given {
+			p.ChecksGiven[c] = n
+		} else {
+			p.ChecksGiven[c] = 3 - n
+		}
+	}
+	return nil
+}
+
+func (p *Position) parseCastling(field string) error {
+	if field == "-" {
+		return nil
+	}
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		color := White
+		if c >= 'a' && c <= 'z' {
+			color = Black
+		}
+		king := p.KingSquare(color)
+		rank := backRank(color)
+		if king == NoSquare || king.Rank() != rank {
+			return errors.Errorf("castling right %q without a king on the back rank", string(c))
+		}
+		rook := NewPiece(color, Rook)
+
+		var side int
+		sq := NoSquare
+		switch upper(c) {
+		case 'K':
+			side = KingSide
+			for f := 7; f > king.File(); f-- {
+				if p.Board[NewSquare(f, rank)] == rook {
+					sq = NewSquare(f, rank)
+					break
+				}
+			}
+		case 'Q':
+			side = QueenSide
+			for f := 0; f < king.File(); f++ {
+				if p.Board[NewSquare(f, rank)] == rook {
+					sq = NewSquare(f, rank)
+					break
+				}
+			}
+		default:
+			f := int(upper(c) - 'A')
+			if f < 0 || f > 7 {
+				return errors.Errorf("bad castling right %q", string(c))
+			}
+			side = KingSide
+			if f < king.File() {
+				side = QueenSide
+			}
+			if p.Board[NewSquare(f, rank)] == rook {
+				sq = NewSquare(f, rank)
+			}
+		}
+		if sq == NoSquare {
+			return errors.Errorf("castling right %q without a rook", string(c))
+		}
+		p.Castling[color][side] = sq
+	}
+	return nil
+}
+
+func (p *Position) FEN() string {
+	return p.fen(true)
+}
+
+// Key identifies the position for repetition detection, ignoring the clocks.
+func (p *Position) Key() string {
+	return p.fen(false)
+}
+
+func (p *Position) fen(clocks bool) string {
+	var b strings.Builder
+	p.writeBoard(&b)
+
+	if p.Turn == White {
+		b.WriteString(" w ")
+	} else {
+		b.WriteString(" b ")
+	}
+
+	b.WriteString(p.castlingString())
+
+	b.WriteByte(' ')
+	if p.EnPassant == NoSquare {
+		b.WriteByte('-')
+	} else {
+		b.WriteString(p.EnPassant.String())
+	}
+
+	if p.Variant == ThreeCheck {
+		b.WriteString(" " + strconv.Itoa(3-p.ChecksGiven[White]) + "+" + strconv.Itoa(3-p.ChecksGiven[Black]))
+	}
+
+	if clocks {
+		b.WriteString(" " + strconv.Itoa(p.HalfmoveClock) + " " + strconv.Itoa(p.FullmoveNumber))
+	}
+	return b.String()
+}
+
+func (p *Position) writeBoard(b *strings.Builder) {
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			pc := p.Board[NewSquare(file, rank)]
+			if pc == NoPiece {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				b.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			b.WriteByte(pc.FEN())
+			if p.Variant == Crazyhouse && p.Promoted[NewSquare(file, rank)] {
+				b.WriteByte('~')
+			}
+		}
+		if empty > 0 {
+			b.WriteByte(byte('0' + empty))
+		}
+		if rank > 0 {
+			b.WriteByte('/')
+		}
+	}
+
+	if p.Variant == Crazyhouse {
+		b.WriteByte('[')
+		b.WriteString(p.PocketString())
+		b.WriteByte(']')
+	}
+}
+
+// PocketString lists the Crazyhouse pockets in FEN letters, white first.
+func (p *Position) PocketString() string {
+	var s []byte
+	for _, color := range []Color{White, Black} {
+		for t := Queen; t >= Pawn; t-- {
+			for i := 0; i < p.Pockets[color][t]; i++ {
+				s = append(s, NewPiece(color, t).FEN())
+			}
+		}
+	}
+	return string(s)
+}
+
+func (p *Position) castlingString() string {
+	var s []byte
+	for _, color := range []Color{White, Black} {
+		for _, side := range []int{KingSide, QueenSide} {
+			sq := p.Castling[color][side]
+			if sq == NoSquare {
+				continue
+			}
+			c := byte('A' + sq.File())
+			if p.outermostRook(color, side) == sq {
+				c = "KQ"[side]
+			}
+			if color == Black {
+				c = lower(c)
+			}
+			s = append(s, c)
+		}
+	}
+	if len(s) == 0 {
+		return "-"
+	}
+	return string(s)
+}
+
+func (p *Position) outermostRook(c Color, side int) Square {
+	king := p.KingSquare(c)
+	if king == NoSquare {
+		return NoSquare
+	}
+	rank := backRank(c)
+	rook := NewPiece(c, Rook)
+	if side == KingSide {
+		for f := 7; f > king.File(); f-- {
+			if p.Board[NewSquare(f, rank)] == rook {
+				return NewSquare(f, rank)
+			}
+		}
+	} else {
+		for f := 0; f < king.File(); f++ {
+			if p.Board[NewSquare(f, rank)] == rook {
+				return NewSquare(f, rank)
+			}
+		}
+	}
+	return NoSquare
+}
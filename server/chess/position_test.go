@@ -0,0 +1,89 @@
+package chess
+
+import (
+	"testing"
+)
+
+func TestFENRoundTrip(t *testing.T) {
+	fens := []string{
+		StartingFEN,
+		"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3",
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+		"4k3/8/8/8/8/8/8/4K3 b - - 49 120",
+	}
+	for _, fen := range fens {
+		pos, err := ParseFEN(fen)
+		if err != nil {
+			t.Errorf("%s: %v", fen, err)
+			continue
+		}
+		if got := pos.FEN(); got != fen {
+			t.Errorf("got %s, want %s", got, fen)
+		}
+	}
+}
+
+func TestFENAfterMoves(t *testing.T) {
+	tests := []struct {
+		moves []string
+		fen   string
+	}{
+		// The en passant square is only written when a capture is possible.
+		{[]string{"e4"}, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{[]string{"e4", "c5", "Nf3"}, "rnbqkbnr/pp1ppppp/8/2p5/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"},
+		{[]string{"e4", "d5", "e5", "f5"}, "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3"},
+		{[]string{"Nf3", "Nf6", "Rg1", "Rg8"}, "rnbqkbr1/pppppppp/5n2/8/8/5N2/PPPPPPPP/RNBQKBR1 w Qq - 4 3"},
+		{[]string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Bc5", "O-O"}, "r1bqk1nr/pppp1ppp/2n5/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b kq - 5 4"},
+	}
+	for _, test := range tests {
+		game := NewGame(NewPosition())
+		for _, move := range test.moves {
+			if _, err := game.PlayMove(move); err != nil {
+				t.Fatalf("%v: %v", test.moves, err)
+			}
+		}
+		if got := game.Position().FEN(); got != test.fen {
+			t.Errorf("%v: got %s, want %s", test.moves, got, test.fen)
+		}
+	}
+}
+
+func TestParseFENRejects(t *testing.T) {
+	fens := []string{
+		"",
+		"8/8/8/8 w - -",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNRR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/9/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnx/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"~nbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbn1~/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq z9 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - x 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 0",
+	}
+	for _, fen := range fens {
+		if _, err := ParseFEN(fen); err == nil {
+			t.Errorf("%q: got no error", fen)
+		}
+	}
+}
+
+func TestPromotionFEN(t *testing.T) {
+	// Only Crazyhouse marks promoted pieces.
+	game := playMoves(t, Standard, "1n2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "axb8=Q+")
+	if got, want := game.Position().FEN(), "1Q2k3/8/8/8/8/8/8/4K3 b - - 0 1"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// A marker read from a FEN is dropped outside Crazyhouse too.
+	pos, err := ParseVariantFEN(Standard, "Q~3k3/8/8/8/8/8/8/4K3 b - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pos.FEN(), "Q3k3/8/8/8/8/8/8/4K3 b - - 0 1"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,265 @@
+package chess
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Variant names follow the keys Lichess uses in its API.
+type Variant string
+
+const (
+	Standard      Variant = "standard"
+	Chess960      Variant = "chess960"
+	KingOfTheHill Variant = "kingOfTheHill"
+	ThreeCheck    Variant = "threeCheck"
+	Antichess     Variant = "antichess"
+	Atomic        Variant = "atomic"
+	Horde         Variant = "horde"
+	RacingKings   Variant = "racingKings"
+	Crazyhouse    Variant = "crazyhouse"
+)
+
+var variants = []Variant{Standard, Chess960, KingOfTheHill, ThreeCheck, Antichess, Atomic, Horde, RacingKings, Crazyhouse}
+
+var variantNames = map[Variant]string{
+	Standard:      "Standard",
+	Chess960:      "Chess960",
+	KingOfTheHill: "King of the Hill",
+	ThreeCheck:    "Three-check",
+	Antichess:     "Antichess",
+	Atomic:        "Atomic",
+	Horde:         "Horde",
+	RacingKings:   "Racing Kings",
+	Crazyhouse:    "Crazyhouse",
+}
+
+const (
+	antichessFEN   = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1"
+	hordeFEN       = "rnbqkbnr/pppppppp/8/1PP2PP1/PPPPPPPP/PPPPPPPP/PPPPPPPP/PPPPPPPP w kq - 0 1"
+	racingKingsFEN = "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1"
+)
+
+func Variants() []Variant {
+	return variants
+}
+
+// ParseVariant accepts the Lichess key or the display name of a variant,
+// as found in PGN Variant tags. Games from a custom position follow the
+// standard rules.
+func ParseVariant(s string) (Variant, error) {
+	key := variantKey(s)
+	if key == "" || key == "fromposition" {
+		return Standard, nil
+	}
+	for _, v := range variants {
+		if variantKey(string(v)) == key || variantKey(v.Name()) == key {
+			return v, nil
+		}
+	}
+	return "", errors.Errorf("unknown variant %q", s)
+}
+
+func variantKey(s string) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '-' {
+			continue
+		}
+		b = append(b, lower(s[i]))
+	}
+	return string(b)
+}
+
+func (v Variant) Name() string {
+	if name, ok := variantNames[v]; ok {
+		return name
+	}
+	return string(v)
+}
+
+// StartingPosition returns the initial position of a new game. Chess960
+// positions are picked at random.
+func (v Variant) StartingPosition() *Position {
+	var pos *Position
+	switch v {
+	case Chess960:
+		pos = Chess960Position(RandomIntn(960))
+	case Antichess:
+		pos, _ = ParseFEN(antichessFEN)
+	case Horde:
+		pos, _ = ParseFEN(hordeFEN)
+	case RacingKings:
+		pos, _ = ParseFEN(racingKingsFEN)
+	default:
+		pos = NewPosition()
+	}
+	pos.Variant = v
+	return pos
+}
+
+var (
+	randomLock sync.Mutex
+	// random is seeded, unlike the global source, so every start of the
+	// plugin draws different positions and sides.
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// RandomIntn returns a random number in [0, n). It is safe for concurrent use.
+func RandomIntn(n int) int {
+	randomLock.Lock()
+	defer randomLock.Unlock()
+	return random.Intn(n)
+}
+
+// ParseVariantFEN parses a FEN and applies the rules of v to it.
+func ParseVariantFEN(v Variant, fen string) (*Position, error) {
+	pos, err := ParseFEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	pos.Variant = v
+	if v != Crazyhouse {
+		pos.Promoted = [64]bool{}
+	}
+	return pos, nil
+}
+
+// Chess960Position returns the starting position with the given Scharnagl
+// number, 0 to 959. Number 518 is the standard starting position.
+func Chess960Position(n int) *Position {
+	var back [8]PieceType
+	place := func(t PieceType, nth int) {
+		for f := 0; f < 8; f++ {
+			if back[f] != NoPieceType {
+				continue
+			}
+			if nth == 0 {
+				back[f] = t
+				return
+			}
+			nth--
+		}
+	}
+
+	back[2*(n%4)+1] = Bishop
+	n /= 4
+	back[2*(n%4)] = Bishop
+	n /= 4
+	place(Queen, n%6)
+	n /= 6
+	knights := [10][2]int{{0, 1}, {0, 2}, {0, 3}, {0, 4}, {1, 2}, {1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4}}[n]
+	// Placing the second knight first keeps the first index valid.
+	place(Knight, knights[1])
+	place(Knight, knights[0])
+	place(Rook, 0)
+	place(King, 0)
+	place(Rook, 0)
+
+	pos := &Position{
+		EnPassant:      NoSquare,
+		FullmoveNumber: 1,
+		Variant:        Chess960,
+	}
+	for f, t := range back {
+		pos.Board[NewSquare(f, 0)] = NewPiece(White, t)
+		pos.Board[NewSquare(f, 1)] = NewPiece(White, Pawn)
+		pos.Board[NewSquare(f, 6)] = NewPiece(Black, Pawn)
+		pos.Board[NewSquare(f, 7)] = NewPiece(Black, t)
+	}
+	for _, c := range []Color{White, Black} {
+		pos.Castling[c][QueenSide] = pos.outermostRook(c, QueenSide)
+		pos.Castling[c][KingSide] = pos.outermostRook(c, KingSide)
+	}
+	return pos
+}
+
+var hill = [4]Square{27, 28, 35, 36} // d4, e4, d5, e5
+
+// variantOutcome reports a win or draw decided by variant specific rules,
+// before checkmate and stalemate are considered.
+func (p *Position) variantOutcome() (Result, Termination, bool) {
+	mover := p.Turn.Other()
+
+	switch p.Variant {
+	case KingOfTheHill:
+		king := p.KingSquare(mover)
+		for _, sq := range hill {
+			if king == sq {
+				return winFor(mover), KingInTheCenter, true
+			}
+		}
+	case ThreeCheck:
+		if p.ChecksGiven[mover] >= 3 {
+			return winFor(mover), ThirdCheck, true
+		}
+	case Atomic:
+		for _, c := range []Color{White, Black} {
+			if p.KingSquare(c) == NoSquare {
+				return winFor(c.Other()), KingExploded, true
+			}
+		}
+	case Horde:
+		if p.pieceCount(White) == 0 {
+			return BlackWins, HordeDestroyed, true
+		}
+	case Antichess:
+		if p.pieceCount(p.Turn) == 0 {
+			return winFor(p.Turn), AllPiecesLost, true
+		}
+		if !p.HasLegalMoves() {
+			return winFor(p.Turn), Stalemate, true
+		}
+	case RacingKings:
+		whiteHome := p.KingSquare(White).Rank() == 7
+		blackHome := p.KingSquare(Black).Rank() == 7
+		switch {
+		case whiteHome && blackHome:
+			return Draw, KingsReachedGoal, true
+		case blackHome:
+			return BlackWins, KingReachedGoal, true
+		case whiteHome && (p.Turn == White || !p.blackCanReachGoal()):
+			return WhiteWins, KingReachedGoal, true
+		}
+	}
+	return NoResult, "", false
+}
+
+// blackCanReachGoal reports whether black can answer white reaching the
+// eighth rank by reaching it too, which draws the game in Racing Kings.
+func (p *Position) blackCanReachGoal() bool {
+	for _, m := range p.LegalMoves() {
+		if p.Board[m.From].Type() == King && m.To.Rank() == 7 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Position) pieceCount(c Color) int {
+	n := 0
+	for sq := Square(0); sq < 64; sq++ {
+		if pc := p.Board[sq]; pc != NoPiece && pc.Color() == c {
+			n++
+		}
+	}
+	return n
+}
+
+// hasInsufficientMaterial applies the draw by insufficient material rule of
+// the variant.
+func (p *Position) hasInsufficientMaterial() bool {
+	switch p.Variant {
+	case Standard, Chess960:
+		return p.IsInsufficientMaterial()
+	case ThreeCheck, Atomic:
+		// Only bare kings, which can neither check nor capture each other.
+		return p.pieceCount(White) == 1 && p.pieceCount(Black) == 1 &&
+			p.KingSquare(White) != NoSquare && p.KingSquare(Black) != NoSquare
+	default:
+		return false
+	}
+}
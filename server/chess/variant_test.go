@@ -0,0 +1,176 @@
+package chess
+
+import (
+	"testing"
+)
+
+// playMoves plays moves in SAN or UCI from a FEN of a variant.
+func playMoves(t *testing.T, v Variant, fen string, moves ...string) *Game {
+	t.Helper()
+	pos, err := ParseVariantFEN(v, fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game := NewGame(pos)
+	for _, move := range moves {
+		if _, err := game.PlayMove(move); err != nil {
+			t.Fatalf("%s %s: %v", fen, move, err)
+		}
+	}
+	return game
+}
+
+func TestVariantOutcomes(t *testing.T) {
+	tests := []struct {
+		name        string
+		variant     Variant
+		fen         string
+		moves       []string
+		result      Result
+		termination Termination
+	}{
+		{"king of the hill", KingOfTheHill, "4k3/8/8/8/8/3K4/8/8 w - - 0 1", []string{"Kd4"}, WhiteWins, KingInTheCenter},
+		{"three-check", ThreeCheck, "4k3/8/8/8/8/8/8/R3K3 w - - 1+3 0 1", []string{"Ra8+"}, WhiteWins, ThirdCheck},
+		{"three-check counts checks", ThreeCheck, "4k3/8/8/8/8/8/8/R3K3 w - - 2+3 0 1", []string{"Ra8+"}, NoResult, ""},
+		{"atomic king explosion", Atomic, "3qk3/8/8/8/8/8/8/3RK3 w - - 0 1", []string{"Rxd8"}, WhiteWins, KingExploded},
+		{"antichess losing all pieces", Antichess, "8/8/8/8/8/8/1p6/2R5 b - - 0 1", []string{"bxc1=Q"}, WhiteWins, AllPiecesLost},
+		{"antichess stalemate", Antichess, "8/8/8/8/8/p7/P7/8 b - - 0 1", nil, BlackWins, Stalemate},
+		{"horde destroyed", Horde, "4k3/8/8/8/8/8/P7/q7 b - - 0 1", []string{"Qxa2"}, BlackWins, HordeDestroyed},
+		{"racing kings", RacingKings, "8/1K6/8/6k1/8/8/8/8 w - - 0 1", []string{"Kb8"}, WhiteWins, KingReachedGoal},
+		{"racing kings black can follow", RacingKings, "8/1K4k1/8/8/8/8/8/8 w - - 0 1", []string{"Kb8"}, NoResult, ""},
+		{"racing kings both kings", RacingKings, "8/1K4k1/8/8/8/8/8/8 w - - 0 1", []string{"Kb8", "Kg8"}, Draw, KingsReachedGoal},
+		{"racing kings black first", RacingKings, "8/6k1/8/8/8/1K6/8/8 b - - 0 1", []string{"Kg8"}, BlackWins, KingReachedGoal},
+	}
+	for _, test := range tests {
+		game := playMoves(t, test.variant, test.fen, test.moves...)
+		if game.Result() != test.result || game.Termination() != test.termination {
+			t.Errorf("%s: got %s by %q, want %s by %q", test.name, game.Result(), game.Termination(), test.result, test.termination)
+		}
+	}
+}
+
+func TestVariantMoves(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant Variant
+		fen     string
+		moves   []string
+		want    string
+	}{
+		{
+			// The capture destroys the pieces around d5 but not the pawn.
+			"atomic explosion", Atomic, "4k3/8/8/3n4/2n1p3/8/8/3QK3 w - - 0 1",
+			[]string{"Qxd5"}, "4k3/8/8/8/4p3/8/8/4K3 b - - 0 1",
+		},
+		{
+			"crazyhouse capture fills the pocket", Crazyhouse, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1",
+			[]string{"e4", "d5", "exd5"}, "rnbqkbnr/ppp1pppp/8/3P4/8/8/PPPP1PPP/RNBQKBNR[P] b KQkq - 0 2",
+		},
+		{
+			"crazyhouse drop", Crazyhouse, "rnbqkbnr/ppp1pppp/8/3P4/8/8/PPPP1PPP/RNBQKBNR[P] b KQkq - 0 2",
+			[]string{"Qxd5", "P@e4"}, "rnb1kbnr/ppp1pppp/8/3q4/4P3/8/PPPP1PPP/RNBQKBNR[p] b KQkq - 1 3",
+		},
+		{
+			"crazyhouse promotion is marked", Crazyhouse, "4k3/P7/8/8/8/8/8/4K3[] w - - 0 1",
+			[]string{"a8=Q+"}, "Q~3k3/8/8/8/8/8/8/4K3[] b - - 0 1",
+		},
+		{
+			"crazyhouse promoted piece is captured as a pawn", Crazyhouse, "r3k3/8/8/8/8/8/8/Q~3K3[] b - - 0 1",
+			[]string{"Rxa1+"}, "4k3/8/8/8/8/8/8/r3K3[p] w - - 0 2",
+		},
+		{
+			"chess960 castling onto the rook", Chess960, "4k3/8/8/8/8/8/8/1R2K1R1 w KQ - 0 1",
+			[]string{"O-O"}, "4k3/8/8/8/8/8/8/1R3RK1 b - - 1 1",
+		},
+		{
+			"chess960 queenside castling", Chess960, "4k3/8/8/8/8/8/8/1R2K1R1 w KQ - 0 1",
+			[]string{"O-O-O"}, "4k3/8/8/8/8/8/8/2KR2R1 b - - 1 1",
+		},
+	}
+	for _, test := range tests {
+		game := playMoves(t, test.variant, test.fen, test.moves...)
+		if got := game.Position().FEN(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVariantIllegalMoves(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant Variant
+		fen     string
+		move    string
+	}{
+		{"atomic king capture", Atomic, "4k3/8/8/8/8/8/3q4/4K3 w - - 0 1", "Kxd2"},
+		{"atomic exploding own king", Atomic, "8/8/8/8/8/8/3q4/3RK2k w - - 0 1", "Rxd2"},
+		{"antichess captures are compulsory", Antichess, "8/8/8/8/8/p7/8/R7 w - - 0 1", "Ra2"},
+		{"racing kings giving check", RacingKings, "8/8/8/8/8/8/k7/1R5K w - - 0 1", "Rb2"},
+		{"crazyhouse pawn drop on the back rank", Crazyhouse, "4k3/8/8/8/8/8/8/4K3[P] w - - 0 1", "P@a8"},
+		{"chess960 castling through check", Chess960, "4k3/8/8/8/8/8/5r2/1R2K1R1 w KQ - 0 1", "O-O"},
+	}
+	for _, test := range tests {
+		pos, err := ParseVariantFEN(test.variant, test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, err := pos.ParseMove(test.move); err == nil {
+			t.Errorf("%s: %s is legal as %s", test.name, test.move, pos.UCI(m))
+		}
+	}
+}
+
+func TestChess960Positions(t *testing.T) {
+	if fen := Chess960Position(518).FEN(); fen != StartingFEN {
+		t.Errorf("position 518 is %s, want the standard one", fen)
+	}
+
+	seen := map[string]bool{}
+	for n := 0; n < 960; n++ {
+		pos := Chess960Position(n)
+		key := pos.Key()
+		if seen[key] {
+			t.Fatalf("position %d repeats an earlier one", n)
+		}
+		seen[key] = true
+
+		var bishops [2]int
+		king := pos.KingSquare(White).File()
+		rooks := 0
+		for f := 0; f < 8; f++ {
+			switch pos.Board[NewSquare(f, 0)].Type() {
+			case Bishop:
+				bishops[f%2]++
+			case Rook:
+				if (rooks == 0) != (f < king) {
+					t.Errorf("position %d: the king is not between the rooks", n)
+				}
+				rooks++
+			}
+		}
+		if bishops != [2]int{1, 1} {
+			t.Errorf("position %d: the bishops are on the same colour", n)
+		}
+	}
+}
+
+func TestChess960Perft(t *testing.T) {
+	tests := []struct {
+		fen    string
+		counts []int
+	}{
+		{"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", []int{21, 528, 12189}},
+		{"2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9", []int{21, 807, 18002}},
+	}
+	for _, test := range tests {
+		pos, err := ParseVariantFEN(Chess960, test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range test.counts {
+			if got := perft(pos, i+1); got != want {
+				t.Errorf("%s: perft(%d) = %d, want %d", test.fen, i+1, got, want)
+			}
+		}
+	}
+}
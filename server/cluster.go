@@ -2,55 +2,184 @@ package main
 
 import (
 	"encoding/json"
+	"sync"
 
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+	"github.com/pkg/errors"
 )
 
-const (
-	oauthCompleteEventID = "oauth-complete"
+// clusterEventType names an event sent to the other nodes of the cluster
+// with data of type T. The version must be bumped whenever T changes in a
+// way older nodes cannot decode; events of another version are dropped, so
+// nodes running different plugin versions ignore each other's events.
+type clusterEventType[T any] struct {
+	id      string
+	version int
+}
+
+var (
+	oauthCompleteEvent    = clusterEventType[OAuthCompleteEvent]{id: "oauth-complete", version: 1}
+	localGameUpdatedEvent = clusterEventType[LocalGameUpdatedEvent]{id: "local-game-updated", version: 1}
+	livePostsUpdatedEvent = clusterEventType[LivePostsUpdatedEvent]{id: "live-posts-updated", version: 1}
 )
 
-func (p *Plugin) sendOAuthCompleteEvent(event OAuthCompleteEvent) {
-	p.sendMessageToCluster(oauthCompleteEventID, event)
+// clusterEventEnvelope is what is sent for an event. Events sent before
+// events had versions carry their data only, and count as version 1.
+type clusterEventEnvelope struct {
+	Version int
+	Data    json.RawMessage
+}
+
+type clusterEventHandlers struct {
+	version  int
+	handlers []func(data []byte) error
+}
+
+// clusterEventBus sends events to the other nodes of the cluster, and passes
+// the events it receives to every handler registered for them.
+type clusterEventBus struct {
+	api plugin.API
+
+	lock   sync.RWMutex
+	events map[string]*clusterEventHandlers
+}
+
+func newClusterEventBus(api plugin.API) *clusterEventBus {
+	return &clusterEventBus{
+		api:    api,
+		events: map[string]*clusterEventHandlers{},
+	}
+}
+
+// onClusterEvent registers a handler for the events of a type received from
+// other nodes.
+func onClusterEvent[T any](b *clusterEventBus, t clusterEventType[T], handle func(event T)) error {
+	return b.register(t.id, t.version, func(data []byte) error {
+		var event T
+		if err := json.Unmarshal(data, &event); err != nil {
+			return errors.Wrap(err, "failed to decode event")
+		}
+		handle(event)
+		return nil
+	})
+}
+
+// publishClusterEvent sends an event to the other nodes of the cluster. The
+// handlers of this node are not called.
+func publishClusterEvent[T any](b *clusterEventBus, t clusterEventType[T], event T) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		b.api.LogWarn("failed to encode cluster event", "id", t.id, "error", err.Error())
+		return
+	}
+	b.publish(t.id, clusterEventEnvelope{Version: t.version, Data: data})
+}
+
+// register adds a handler for an event. All the handlers of an event must
+// expect the same version.
+func (b *clusterEventBus) register(id string, version int, handler func(data []byte) error) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	entry, ok := b.events[id]
+	if !ok {
+		entry = &clusterEventHandlers{version: version}
+		b.events[id] = entry
+	}
+	if entry.version != version {
+		return errors.Errorf("cluster event %s registered with versions %d and %d", id, entry.version, version)
+	}
+	entry.handlers = append(entry.handlers, handler)
+	return nil
 }
 
-func (p *Plugin) sendMessageToCluster(id string, v interface{}) {
-	b, err := json.Marshal(v)
+func (b *clusterEventBus) publish(id string, envelope clusterEventEnvelope) {
+	data, err := json.Marshal(envelope)
 	if err != nil {
-		p.API.LogWarn(
-			"couldn't get JSON bytes from cluster message",
-			"id", id,
-			"error", err,
-		)
+		b.api.LogWarn("failed to encode cluster event", "id", id, "error", err.Error())
 		return
 	}
 
-	event := model.PluginClusterEvent{Id: id, Data: b}
+	event := model.PluginClusterEvent{Id: id, Data: data}
 	opts := model.PluginClusterEventSendOptions{
 		SendType: model.PluginClusterEventSendTypeReliable,
 	}
-
-	if err := p.API.PublishPluginClusterEvent(event, opts); err != nil {
-		p.API.LogWarn(
-			"error publishing cluster event",
-			"id", id,
-			"error", err,
-		)
+	if appErr := b.api.PublishPluginClusterEvent(event, opts); appErr != nil {
+		b.api.LogWarn("failed to publish cluster event", "id", id, "error", appErr.Error())
 	}
 }
 
-func (p *Plugin) HandleClusterEvent(ev model.PluginClusterEvent) {
-	p.API.LogError("received cluster event", "id", ev.Id)
+// handle passes an event received from another node to its handlers.
+func (b *clusterEventBus) handle(ev model.PluginClusterEvent) {
+	b.lock.RLock()
+	entry, ok := b.events[ev.Id]
+	var handlers []func(data []byte) error
+	if ok {
+		handlers = append(handlers, entry.handlers...)
+	}
+	b.lock.RUnlock()
+	if !ok {
+		b.api.LogWarn("unknown cluster event", "id", ev.Id)
+		return
+	}
+
+	var envelope clusterEventEnvelope
+	if err := json.Unmarshal(ev.Data, &envelope); err != nil || envelope.Version == 0 {
+		envelope = clusterEventEnvelope{Version: 1, Data: ev.Data}
+	}
+	if envelope.Version != entry.version {
+		b.api.LogDebug("dropped cluster event of another version", "id", ev.Id, "version", envelope.Version, "expected", entry.version)
+		return
+	}
+
+	for _, handler := range handlers {
+		if err := handler(envelope.Data); err != nil {
+			b.api.LogWarn("failed to handle cluster event", "id", ev.Id, "error", err.Error())
+		}
+	}
+}
 
-	switch ev.Id {
-	case oauthCompleteEventID:
-		var event OAuthCompleteEvent
-		if err := json.Unmarshal(ev.Data, &event); err != nil {
-			p.API.LogWarn("cannot marshal cluster event - OAuth complete event", "error", err)
-			return
+// registerClusterEventHandlers subscribes the subsystems of the plugin to
+// the events other nodes send them. It runs before the subsystems are set
+// up, so the handlers skip those that are not there yet.
+func (p *Plugin) registerClusterEventHandlers() error {
+	err := onClusterEvent(p.clusterEvents, oauthCompleteEvent, func(event OAuthCompleteEvent) {
+		if p.oauthBroker != nil {
+			p.oauthBroker.publishOAuthComplete(event.UserID, event.Err, true)
 		}
-		p.oauthBroker.publishOAuthComplete(event.UserID, event.Err, true)
-	default:
-		p.API.LogWarn("unknown cluster event", "id", ev.Id)
+	})
+	if err != nil {
+		return err
+	}
+	err = onClusterEvent(p.clusterEvents, localGameUpdatedEvent, func(event LocalGameUpdatedEvent) {
+		if p.localGames != nil {
+			p.localGames.invalidate(event.RootPostID)
+		}
+	})
+	if err != nil {
+		return err
 	}
+	return onClusterEvent(p.clusterEvents, livePostsUpdatedEvent, func(event LivePostsUpdatedEvent) {
+		switch {
+		case event.Runner == tvRunnerName && p.tv != nil:
+			p.tv.wake()
+		case event.Runner == watchRunnerName && p.watch != nil:
+			p.watch.wake()
+		case event.Runner == arenaRunnerName && p.arenas != nil:
+			p.arenas.wake()
+		}
+	})
+}
+
+func (p *Plugin) sendOAuthCompleteEvent(event OAuthCompleteEvent) {
+	publishClusterEvent(p.clusterEvents, oauthCompleteEvent, event)
+}
+
+func (p *Plugin) sendLocalGameUpdatedEvent(event LocalGameUpdatedEvent) {
+	publishClusterEvent(p.clusterEvents, localGameUpdatedEvent, event)
+}
+
+func (p *Plugin) sendLivePostsUpdatedEvent(event LivePostsUpdatedEvent) {
+	publishClusterEvent(p.clusterEvents, livePostsUpdatedEvent, event)
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testClusterEvent = clusterEventType[LivePostsUpdatedEvent]{id: "test-event", version: 2}
+
+func TestPublishClusterEvent(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	bus := newClusterEventBus(p.API)
+
+	api.On("PublishPluginClusterEvent",
+		model.PluginClusterEvent{Id: "test-event", Data: []byte(`{"Version":2,"Data":{"Runner":"tv"}}`)},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	).Return(nil).Once()
+
+	publishClusterEvent(bus, testClusterEvent, LivePostsUpdatedEvent{Runner: "tv"})
+}
+
+func TestHandleClusterEvent(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	bus := newClusterEventBus(p.API)
+
+	var first, second []LivePostsUpdatedEvent
+	require.NoError(t, onClusterEvent(bus, testClusterEvent, func(event LivePostsUpdatedEvent) {
+		first = append(first, event)
+	}))
+	require.NoError(t, onClusterEvent(bus, testClusterEvent, func(event LivePostsUpdatedEvent) {
+		second = append(second, event)
+	}))
+
+	// Every handler gets the event.
+	bus.handle(model.PluginClusterEvent{Id: "test-event", Data: []byte(`{"Version":2,"Data":{"Runner":"tv"}}`)})
+	want := []LivePostsUpdatedEvent{{Runner: "tv"}}
+	assert.Equal(t, want, first)
+	assert.Equal(t, want, second)
+
+	// Events of another version are dropped.
+	api.On("LogDebug", "dropped cluster event of another version", "id", "test-event", "version", 3, "expected", 2).Once()
+	bus.handle(model.PluginClusterEvent{Id: "test-event", Data: []byte(`{"Version":3,"Data":{"Runner":"watch"}}`)})
+	assert.Equal(t, want, first)
+
+	// So are events sent before events had versions, which count as
+	// version 1.
+	api.On("LogDebug", "dropped cluster event of another version", "id", "test-event", "version", 1, "expected", 2).Once()
+	bus.handle(model.PluginClusterEvent{Id: "test-event", Data: []byte(`{"Runner":"watch"}`)})
+	assert.Equal(t, want, first)
+}
+
+func TestHandleLegacyClusterEvent(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	bus := newClusterEventBus(p.API)
+
+	var got []LivePostsUpdatedEvent
+	require.NoError(t, onClusterEvent(bus, livePostsUpdatedEvent, func(event LivePostsUpdatedEvent) {
+		got = append(got, event)
+	}))
+
+	bus.handle(model.PluginClusterEvent{Id: livePostsUpdatedEvent.id, Data: []byte(`{"Runner":"watch"}`)})
+	assert.Equal(t, []LivePostsUpdatedEvent{{Runner: "watch"}}, got)
+}
+
+func TestHandleUnknownClusterEvent(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	bus := newClusterEventBus(p.API)
+
+	api.On("LogWarn", "unknown cluster event", "id", "unknown").Once()
+	bus.handle(model.PluginClusterEvent{Id: "unknown", Data: []byte(`{}`)})
+}
+
+func TestHandleUndecodableClusterEvent(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	bus := newClusterEventBus(p.API)
+
+	require.NoError(t, onClusterEvent(bus, testClusterEvent, func(event LivePostsUpdatedEvent) {
+		t.Error("handled an event that did not decode")
+	}))
+
+	api.On("LogWarn", "failed to handle cluster event", "id", "test-event", "error", "failed to decode event: json: cannot unmarshal number into Go value of type main.LivePostsUpdatedEvent").Once()
+	bus.handle(model.PluginClusterEvent{Id: "test-event", Data: []byte(`{"Version":2,"Data":42}`)})
+}
+
+func TestRegisterClusterEventVersionConflict(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	bus := newClusterEventBus(p.API)
+
+	require.NoError(t, onClusterEvent(bus, testClusterEvent, func(LivePostsUpdatedEvent) {}))
+	other := clusterEventType[LivePostsUpdatedEvent]{id: testClusterEvent.id, version: 3}
+	assert.Error(t, onClusterEvent(bus, other, func(LivePostsUpdatedEvent) {}))
+}
+
+func TestRegisterClusterEventHandlers(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	p.clusterEvents = newClusterEventBus(p.API)
+	require.NoError(t, p.registerClusterEventHandlers())
+
+	// Nothing is set up yet, which the handlers must survive.
+	p.clusterEvents.handle(model.PluginClusterEvent{Id: livePostsUpdatedEvent.id, Data: []byte(`{"Version":1,"Data":{"Runner":"tv"}}`)})
+	p.clusterEvents.handle(model.PluginClusterEvent{Id: localGameUpdatedEvent.id, Data: []byte(`{"Version":1,"Data":{"RootPostID":"post"}}`)})
+	p.clusterEvents.handle(model.PluginClusterEvent{Id: oauthCompleteEvent.id, Data: []byte(`{"Version":1,"Data":{"UserID":"user"}}`)})
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	commandTrigger = "lichess"
+
+	commandHelp = `* |/lichess local @user [white|black|random] [variant]| - Start a game against @user that is played in a thread
+* |/lichess local record [@user]| - Show the local game record of a user
+* |/lichess leaderboard [perf] [--provisional]| - Rank the connected users of this team by their rating, blitz by default
+* |/lichess progress [perf] [period] [@users...]| - Chart the rating of you or the given users over a period such as |30d|, |6m| or |all|, 90 days by default
+* |/lichess team link <lichess team> [--team] [--autoadd]| - Compare a Lichess team with the members of this channel, or of this team with |--team|, and report differences here. Team admins only
+* |/lichess team unlink <lichess team> [--team]| - Stop comparing a Lichess team
+* |/lichess team list| - List the Lichess teams linked in this team
+* |/lichess team sync <lichess team> [--team]| - Compare a linked Lichess team now
+* |/lichess arena create "Name" 3+2 60m [variant] [casual]| - Create an arena for the configured Lichess team and follow its standings in this channel. Team admins only
+* |/lichess swiss create "Name" 10+5 <rounds> [variant] [casual]| - Create a Swiss for the configured Lichess team and post its pairings and standings in this channel. Team admins only
+* |/lichess tournaments history [year]| - List the tournaments of this team and their winners, this year by default
+* |/lichess tournaments player [@user] [year]| - Show the tournament results of a user
+* |/lichess tournaments track <tournament link>| - Follow an arena or Swiss created on Lichess in this channel
+* |/lichess tv [channel]| - Show the featured Lichess TV game of a channel such as |blitz| or |rapid| in a post that follows it live
+* |/lichess tv stop| - Stop the Lichess TV posts in this channel
+* |/lichess games [@user] [--since 7d] [--perf blitz] [--vs lichessuser]| - List recent Lichess games of a user, yours by default
+* |/lichess pgn <game ID or link>| - Attach the PGN of a Lichess game with its clocks, evaluations and opening
+* |/lichess pgn tournament <tournament link>| - Attach the games of a followed tournament as one PGN
+* |/lichess pgn local| - Attach the local games of this channel as one PGN
+* |/lichess opening [moves or FEN] [--masters|--lichess|--player user]| - Show the most played continuations of a position in the opening explorer
+* |/lichess analyse <FEN, game ID or link, or PGN>| - Evaluate a position, or every move of a game, with the best lines
+* |/lichess report on| - Get a direct message with your accuracy, inaccuracies, mistakes and blunders after each Lichess game
+* |/lichess report off| - Stop the game reports
+* |/lichess status on [--dnd]| - Show in your custom status when you play on Lichess, and optionally set Do Not Disturb
+* |/lichess status off| - Stop showing your Lichess games in your status
+* |/lichess who| - See who in this team is online, playing or streaming on Lichess
+* |/lichess watch <game ID or link>| - Post a game that follows its moves live until it ends
+* |/lichess watch @user| - Follow the game a connected user is playing
+* |/lichess watch stop| - Stop following the games posted in this channel
+* |/lichess help| - Show this help text`
+)
+
+func (p *Plugin) registerCommands() error {
+	if err := p.API.RegisterCommand(p.getCommand()); err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+	return nil
+}
+
+func (p *Plugin) getCommand() *model.Command {
+	return &model.Command{
+		Trigger:          commandTrigger,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: local, leaderboard, progress, team, arena, swiss, tournaments, tv, watch, games, pgn, opening, analyse, report, status, who, help",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: getAutocompleteData(),
+	}
+}
+
+func getAutocompleteData() *model.AutocompleteData {
+	lichess := model.NewAutocompleteData(commandTrigger, "[command]", "Available commands: local, leaderboard, progress, team, arena, swiss, tournaments, tv, watch, games, pgn, opening, analyse, report, status, who, help")
+
+	local := model.NewAutocompleteData("local", "@user [white|black|random] [variant]", "Start a game played in a thread")
+	local.AddTextArgument("User to play against", "@user", "")
+	local.AddTextArgument("Side and variant, e.g. white chess960", "[white|black|random] [variant]", "")
+	localRecord := model.NewAutocompleteData("record", "[@user]", "Show the local game record of a user")
+	local.AddCommand(localRecord)
+	lichess.AddCommand(local)
+
+	leaderboard := model.NewAutocompleteData("leaderboard", "[perf] [--provisional]", "Rank the connected users of this team by rating")
+	leaderboard.AddTextArgument("Perf such as blitz, rapid or puzzle", "[perf] [--provisional]", "")
+	lichess.AddCommand(leaderboard)
+
+	progress := model.NewAutocompleteData("progress", "[perf] [period] [@users...]", "Chart rating progress over time")
+	progress.AddTextArgument("Perf, period such as 30d or 6m, and users to compare", "[perf] [period] [@users...]", "")
+	lichess.AddCommand(progress)
+
+	team := model.NewAutocompleteData("team", "[link|unlink|list|sync]", "Link a Lichess team to this channel or team")
+	teamLink := model.NewAutocompleteData("link", "<lichess team> [--team] [--autoadd]", "Link a Lichess team and report membership differences")
+	teamLink.AddTextArgument("Lichess team ID or link, then options", "<lichess team> [--team] [--autoadd]", "")
+	team.AddCommand(teamLink)
+	teamUnlink := model.NewAutocompleteData("unlink", "<lichess team> [--team]", "Unlink a Lichess team")
+	teamUnlink.AddTextArgument("Lichess team ID or link", "<lichess team> [--team]", "")
+	team.AddCommand(teamUnlink)
+	team.AddCommand(model.NewAutocompleteData("list", "", "List the linked Lichess teams"))
+	teamSync := model.NewAutocompleteData("sync", "<lichess team> [--team]", "Compare a linked Lichess team now")
+	teamSync.AddTextArgument("Lichess team ID or link", "<lichess team> [--team]", "")
+	team.AddCommand(teamSync)
+	lichess.AddCommand(team)
+
+	arena := model.NewAutocompleteData("arena", "create", "Create an arena tournament for the Lichess team")
+	arenaCreate := model.NewAutocompleteData("create", "\"Name\" 3+2 60m [variant] [casual]", "Create an arena and follow its standings here")
+	arenaCreate.AddTextArgument("Quoted name, time control and duration", "\"Name\" 3+2 60m [variant] [casual]", "")
+	arena.AddCommand(arenaCreate)
+	lichess.AddCommand(arena)
+
+	swiss := model.NewAutocompleteData("swiss", "create", "Create a Swiss tournament for the Lichess team")
+	swissCreate := model.NewAutocompleteData("create", "\"Name\" 10+5 <rounds> [variant] [casual]", "Create a Swiss and post its pairings here")
+	swissCreate.AddTextArgument("Quoted name, time control and number of rounds", "\"Name\" 10+5 <rounds> [variant] [casual]", "")
+	swiss.AddCommand(swissCreate)
+	lichess.AddCommand(swiss)
+
+	tournaments := model.NewAutocompleteData("tournaments", "[history|player|track]", "Browse the tournament archive")
+	tournamentsHistory := model.NewAutocompleteData("history", "[year]", "List the tournaments of this team")
+	tournamentsHistory.AddTextArgument("Year, this year by default", "[year]", "")
+	tournaments.AddCommand(tournamentsHistory)
+	tournamentsPlayer := model.NewAutocompleteData("player", "[@user] [year]", "Show the tournament results of a user")
+	tournamentsPlayer.AddTextArgument("User and year", "[@user] [year]", "")
+	tournaments.AddCommand(tournamentsPlayer)
+	tournamentsTrack := model.NewAutocompleteData("track", "<tournament link>", "Follow a tournament created on Lichess")
+	tournamentsTrack.AddTextArgument("Link to an arena or Swiss", "<tournament link>", "")
+	tournaments.AddCommand(tournamentsTrack)
+	lichess.AddCommand(tournaments)
+
+	tv := model.NewAutocompleteData("tv", "[channel|stop]", "Follow Lichess TV in a post")
+	tv.AddStaticListArgument("TV channel", false, tvAutocompleteItems())
+	lichess.AddCommand(tv)
+
+	watch := model.NewAutocompleteData("watch", "<game|@user|stop>", "Follow a game live in a post")
+	watch.AddTextArgument("Game ID, game link, @user or stop", "<game|@user|stop>", "")
+	lichess.AddCommand(watch)
+
+	games := model.NewAutocompleteData("games", "[@user] [--since 7d] [--perf blitz] [--vs lichessuser]", "List recent Lichess games")
+	games.AddTextArgument("User and filters", "[@user] [--since 7d] [--perf blitz] [--vs lichessuser]", "")
+	lichess.AddCommand(games)
+
+	pgn := model.NewAutocompleteData("pgn", "<game|tournament|local>", "Export games as PGN")
+	pgnTournament := model.NewAutocompleteData("tournament", "<tournament link>", "Export the games of a followed tournament")
+	pgnTournament.AddTextArgument("Link to an arena or Swiss", "<tournament link>", "")
+	pgn.AddCommand(pgnTournament)
+	pgnLocal := model.NewAutocompleteData("local", "", "Export the local games of this channel")
+	pgn.AddCommand(pgnLocal)
+	lichess.AddCommand(pgn)
+
+	opening := model.NewAutocompleteData("opening", "[moves or FEN] [--masters|--lichess|--player user]", "Look up a position in the opening explorer")
+	opening.AddTextArgument("Moves such as e4 e5 Nf3, or a FEN, then the database", "[moves or FEN] [--masters|--lichess|--player user]", "")
+	lichess.AddCommand(opening)
+
+	analyse := model.NewAutocompleteData("analyse", "<FEN, game ID or link, or PGN>", "Evaluate a position or every move of a game")
+	analyse.AddTextArgument("A FEN, a Lichess game ID or link, or a PGN", "<FEN, game ID or link, or PGN>", "")
+	lichess.AddCommand(analyse)
+
+	report := model.NewAutocompleteData("report", "[on|off]", "Get a report of your mistakes after each Lichess game")
+	report.AddCommand(model.NewAutocompleteData("on", "", "Send me a report after each game"))
+	report.AddCommand(model.NewAutocompleteData("off", "", "Stop the game reports"))
+	lichess.AddCommand(report)
+
+	status := model.NewAutocompleteData("status", "[on|off]", "Show your Lichess games in your custom status")
+	statusOn := model.NewAutocompleteData("on", "[--dnd]", "Set my custom status while I play")
+	statusOn.AddTextArgument("Also set Do Not Disturb while playing", "[--dnd]", "")
+	status.AddCommand(statusOn)
+	status.AddCommand(model.NewAutocompleteData("off", "", "Stop setting my custom status"))
+	lichess.AddCommand(status)
+
+	who := model.NewAutocompleteData("who", "", "See who in this team is online on Lichess")
+	lichess.AddCommand(who)
+
+	help := model.NewAutocompleteData("help", "", "Show help")
+	lichess.AddCommand(help)
+
+	return lichess
+}
+
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := splitArgs(args.Command)
+	if len(fields) == 0 || fields[0] != "/"+commandTrigger {
+		return nil, nil
+	}
+
+	action := ""
+	if len(fields) > 1 {
+		action = fields[1]
+	}
+	params := []string{}
+	if len(fields) > 2 {
+		params = fields[2:]
+	}
+
+	switch action {
+	case "local":
+		return p.executeLocalCommand(args, params), nil
+	case "leaderboard":
+		return p.executeLeaderboardCommand(args, params), nil
+	case "progress":
+		return p.executeProgressCommand(args, params), nil
+	case "team":
+		return p.executeTeamCommand(args, params), nil
+	case "arena":
+		return p.executeArenaCommand(args, params), nil
+	case "swiss":
+		return p.executeSwissCommand(args, params), nil
+	case "tournaments":
+		return p.executeTournamentsCommand(args, params), nil
+	case "tv":
+		return p.executeTVCommand(args, params), nil
+	case "watch":
+		return p.executeWatchCommand(args, params), nil
+	case "games":
+		return p.executeGamesCommand(args, params), nil
+	case "pgn":
+		return p.executePGNCommand(args, params), nil
+	case "opening":
+		return p.executeOpeningCommand(args, params), nil
+	case "analyse", "analyze":
+		return p.executeAnalyseCommand(args, params), nil
+	case "report":
+		return p.executeReportCommand(args, params), nil
+	case "status":
+		return p.executeStatusCommand(args, params), nil
+	case "who":
+		return p.executeWhoCommand(args, params), nil
+	default:
+		return p.helpResponse(), nil
+	}
+}
+
+func (p *Plugin) helpResponse() *model.CommandResponse {
+	text := "###### Lichess - Slash Command Help\n" + strings.ReplaceAll(commandHelp, "|", "`")
+	return ephemeralResponse(text)
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+func ephemeralResponsef(format string, args ...interface{}) *model.CommandResponse {
+	return ephemeralResponse(fmt.Sprintf(format, args...))
+}
+
+// notConnectedResponse asks the user to connect their Lichess account.
+func (p *Plugin) notConnectedResponse() *model.CommandResponse {
+	connectURL := *p.pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL + pluginURLPath + "/oauth/connect"
+	return ephemeralResponsef("[Connect your Lichess account](%s) first.", connectURL)
+}
+
+// splitArgs splits a command into fields like strings.Fields, except that
+// text in double quotes stays together, e.g. a tournament name.
+func splitArgs(s string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+			inField = true
+		case !quoted && unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// userFromMention resolves "@username" or "username" to a Mattermost user.
+func (p *Plugin) userFromMention(mention string) (*model.User, error) {
+	username := strings.TrimPrefix(mention, "@")
+	if username == "" {
+		return nil, errors.New("missing username")
+	}
+	user, err := p.pluginAPI.User.GetByUsername(username)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown user @%s", username)
+	}
+	return user, nil
+}
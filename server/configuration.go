@@ -3,16 +3,32 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"net/url"
 	"reflect"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	defaultBaseURL     = "https://lichess.org/"
+	defaultExplorerURL = "https://explorer.lichess.ovh/"
+
+	defaultEngineProcesses = 1
+	maxEngineProcesses     = 16
+)
+
 type Configuration struct {
+	LichessBaseURL           string `json:"lichessbaseurl"`
 	LichessOAuthClientID     string `json:"lichessoauthclientid"`
 	LichessOAuthClientSecret string `json:"lichessoauthclientsecret"`
 	EncryptionKey            string `json:"encryptionkey"`
+	DailyPuzzleChannels      string `json:"dailypuzzlechannels"`
+	WeeklyDigestChannels     string `json:"weeklydigestchannels"`
+	LichessTeamID            string `json:"lichessteamid"`
+	OpeningExplorerURL       string `json:"openingexplorerurl"`
+	EnginePath               string `json:"enginepath"`
+	EngineProcesses          int    `json:"engineprocesses"`
 }
 
 func (c *Configuration) setDefaults() (bool, error) {
@@ -49,13 +65,62 @@ func (c *Configuration) setDefaults() (bool, error) {
 	return changed, nil
 }
 
+// getBaseURL returns the URL of the Lichess server, which may be a self-hosted
+// lila instance, always ending in a slash.
 func (c *Configuration) getBaseURL() string {
-	return "https://lichess.org/"
+	if c.LichessBaseURL == "" {
+		return defaultBaseURL
+	}
+	return strings.TrimSuffix(c.LichessBaseURL, "/") + "/"
+}
+
+// getExplorerURL returns the URL of the opening explorer, always ending in a
+// slash.
+func (c *Configuration) getExplorerURL() string {
+	if c.OpeningExplorerURL == "" {
+		return defaultExplorerURL
+	}
+	return strings.TrimSuffix(c.OpeningExplorerURL, "/") + "/"
+}
+
+// getEngineProcesses returns how many engine processes may run at once.
+func (c *Configuration) getEngineProcesses() int {
+	if c.EngineProcesses <= 0 {
+		return defaultEngineProcesses
+	}
+	if c.EngineProcesses > maxEngineProcesses {
+		return maxEngineProcesses
+	}
+	return c.EngineProcesses
+}
+
+// getDailyPuzzleChannelIDs splits the comma separated list of channels the
+// daily puzzle is posted to.
+func (c *Configuration) getDailyPuzzleChannelIDs() []string {
+	return splitList(c.DailyPuzzleChannels)
+}
+
+// getWeeklyDigestChannelIDs splits the comma separated list of channels the
+// weekly digest is posted to.
+func (c *Configuration) getWeeklyDigestChannelIDs() []string {
+	return splitList(c.WeeklyDigestChannels)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		items = append(items, item)
+	}
+	return items
 }
 
 func (c *Configuration) sanitize() {
+	c.LichessBaseURL = strings.TrimSpace(c.LichessBaseURL)
 	c.LichessOAuthClientID = strings.TrimSpace(c.LichessOAuthClientID)
 	c.LichessOAuthClientSecret = strings.TrimSpace(c.LichessOAuthClientSecret)
+	c.LichessTeamID = parseLichessTeamID(strings.TrimSpace(c.LichessTeamID))
+	c.OpeningExplorerURL = strings.TrimSpace(c.OpeningExplorerURL)
+	c.EnginePath = strings.TrimSpace(c.EnginePath)
 }
 
 func (c *Configuration) IsOAuthConfigured() bool {
@@ -82,6 +147,9 @@ func (c *Configuration) Clone() *Configuration {
 }
 
 func (c *Configuration) IsValid() error {
+	if err := c.validateURLs(); err != nil {
+		return err
+	}
 	if c.LichessOAuthClientID == "" {
 		return errors.New("must have an oauth client id")
 	}
@@ -94,6 +162,22 @@ func (c *Configuration) IsValid() error {
 	return nil
 }
 
+// validateURLs checks the URLs an admin may set. Unlike the rest of IsValid,
+// it holds for a configuration that is not finished yet.
+func (c *Configuration) validateURLs() error {
+	if c.LichessBaseURL != "" {
+		if _, err := url.ParseRequestURI(c.LichessBaseURL); err != nil {
+			return errors.Wrap(err, "invalid Lichess base URL")
+		}
+	}
+	if c.OpeningExplorerURL != "" {
+		if _, err := url.ParseRequestURI(c.OpeningExplorerURL); err != nil {
+			return errors.Wrap(err, "invalid opening explorer URL")
+		}
+	}
+	return nil
+}
+
 func (p *Plugin) getConfiguration() *Configuration {
 	p.configurationLock.RLock()
 	defer p.configurationLock.RUnlock()
@@ -127,6 +211,9 @@ func (p *Plugin) OnConfigurationChange() error {
 	}
 
 	configuration.sanitize()
+	if err := configuration.validateURLs(); err != nil {
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
 
 	p.setConfiguration(configuration)
 
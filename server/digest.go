@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	weeklyDigestJobKey        = "weekly_digest"
+	weeklyDigestLastKey       = "weeklydigest_last"
+	weeklyDigestCheckInterval = time.Hour
+	weeklyDigestDay           = time.Monday
+	weeklyDigestPeriod        = 7 * 24 * time.Hour
+	// maxDigestGames bounds the games fetched per member and week.
+	maxDigestGames = 500
+)
+
+// digestMember is what one connected user did during the week.
+type digestMember struct {
+	userID   string
+	username string
+	lichess  string
+
+	games, wins, losses, draws int
+	bestStreak                 int
+	titledWins                 []*lichess.Game
+
+	// start is the last snapshot before the week, if any.
+	start   *RatingSnapshot
+	current RatingSnapshot
+}
+
+// ratingGain returns the rating change in a perf over the week and the
+// rating the member started it with.
+func (m *digestMember) ratingGain(perfKey string) (gain, from int, ok bool) {
+	if m.start == nil {
+		return 0, 0, false
+	}
+	from, ok = m.start.Ratings[perfKey]
+	to, played := m.current.Ratings[perfKey]
+	if !ok || !played || m.current.Games[perfKey] == m.start.Games[perfKey] {
+		return 0, 0, false
+	}
+	return to - from, from, true
+}
+
+func (m *digestMember) puzzlesPlayed() int {
+	if m.start == nil {
+		return 0
+	}
+	return m.current.Games["puzzle"] - m.start.Games["puzzle"]
+}
+
+// stormRunsPlayed returns the Puzzle Storm runs played over the week.
+// Snapshots taken before runs were recorded have a high score but no runs.
+func (m *digestMember) stormRunsPlayed() int {
+	if m.start == nil || (m.start.StormRuns == 0 && m.start.Storm > 0) {
+		return 0
+	}
+	return m.current.StormRuns - m.start.StormRuns
+}
+
+// postWeeklyDigest is run by the weekly digest job. It checks every hour and
+// posts the digest once per week on the digest day.
+func (p *Plugin) postWeeklyDigest(ctx context.Context) {
+	channelIDs := p.getConfiguration().getWeeklyDigestChannelIDs()
+	if len(channelIDs) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	if now.Weekday() != weeklyDigestDay {
+		return
+	}
+	year, week := now.ISOWeek()
+	weekID := fmt.Sprintf("%d-W%02d", year, week)
+
+	last, appErr := p.API.KVGet(weeklyDigestLastKey)
+	if appErr != nil {
+		p.API.LogWarn("failed to get last weekly digest", "error", appErr.Error())
+		return
+	}
+	if string(last) == weekID {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	since := now.Add(-weeklyDigestPeriod)
+	members, err := p.collectDigest(ctx, since)
+	if err != nil {
+		p.API.LogWarn("failed to collect weekly digest", "error", err.Error())
+		return
+	}
+
+	for _, channelID := range channelIDs {
+		channel, appErr := p.API.GetChannel(channelID)
+		if appErr != nil {
+			p.API.LogWarn("failed to get weekly digest channel", "channelID", channelID, "error", appErr.Error())
+			continue
+		}
+
+		var teamMembers []*digestMember
+		for _, member := range members {
+			if channel.TeamId != "" {
+				if tm, appErr := p.API.GetTeamMember(channel.TeamId, member.userID); appErr != nil || tm.DeleteAt != 0 {
+					continue
+				}
+			}
+			teamMembers = append(teamMembers, member)
+		}
+
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			Message:   p.weeklyDigestMessage(teamMembers, since, now),
+		}
+		if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+			p.API.LogWarn("failed to post weekly digest", "channelID", channelID, "error", err.Error())
+		}
+	}
+
+	if appErr := p.API.KVSet(weeklyDigestLastKey, []byte(weekID)); appErr != nil {
+		p.API.LogWarn("failed to store last weekly digest", "error", appErr.Error())
+	}
+}
+
+// collectDigest gathers the games and rating changes of every connected user
+// since the given time.
+func (p *Plugin) collectDigest(ctx context.Context, since time.Time) ([]*digestMember, error) {
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*digestMember
+	var usernames []string
+	for userID, lichessUsername := range connected {
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil || user.DeleteAt != 0 {
+			continue
+		}
+		members = append(members, &digestMember{userID: userID, username: user.Username, lichess: lichessUsername})
+		usernames = append(usernames, lichessUsername)
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	accounts, err := p.getLichessAccounts(ctx, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	client := p.newStreamingLichessClient()
+	sinceMillis := since.UnixMilli()
+	for _, member := range members {
+		if account, ok := accounts[strings.ToLower(member.lichess)]; ok {
+			member.current = newRatingSnapshot(account, model.GetMillis())
+		}
+
+		history, err := p.getRatingHistory(member.userID)
+		if err != nil {
+			p.API.LogWarn("failed to get rating history", "userID", member.userID, "error", err.Error())
+		}
+		for i := range history {
+			if history[i].Time > sinceMillis {
+				break
+			}
+			member.start = &history[i]
+		}
+
+		var games []*lichess.Game
+		err = client.UserGames(ctx, member.lichess, lichess.GamesFilter{Since: since, Max: maxDigestGames}, func(game *lichess.Game) error {
+			games = append(games, game)
+			return nil
+		})
+		if err != nil {
+			p.API.LogWarn("failed to fetch weekly games", "lichessUsername", member.lichess, "error", err.Error())
+			continue
+		}
+		member.addGames(games)
+	}
+	return members, nil
+}
+
+// addGames counts the results of games, which Lichess returns most recent
+// first.
+func (m *digestMember) addGames(games []*lichess.Game) {
+	id := strings.ToLower(m.lichess)
+	streak := 0
+	for i := len(games) - 1; i >= 0; i-- {
+		game := games[i]
+		if !isGameFinished(game) || game.Status == "aborted" || game.Status == "noStart" {
+			continue
+		}
+
+		color, opponent := "white", game.Players.Black
+		if game.Players.Black.User.Id == id {
+			color, opponent = "black", game.Players.White
+		}
+
+		m.games++
+		switch game.Winner {
+		case color:
+			m.wins++
+			streak++
+			if streak > m.bestStreak {
+				m.bestStreak = streak
+			}
+			if title := opponent.User.Title; title != "" && title != "BOT" {
+				m.titledWins = append(m.titledWins, game)
+			}
+		case "":
+			m.draws++
+			streak = 0
+		default:
+			m.losses++
+			streak = 0
+		}
+	}
+}
+
+func (p *Plugin) weeklyDigestMessage(members []*digestMember, since, until time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Weekly chess digest, %s to %s\n", since.Format("Jan 2"), until.Format("Jan 2"))
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].games != members[j].games {
+			return members[i].games > members[j].games
+		}
+		return members[i].username < members[j].username
+	})
+
+	if len(members) == 0 || members[0].games == 0 {
+		b.WriteString("\nNo games were played this week.\n")
+	} else {
+		b.WriteString("\n##### Games played\n")
+		b.WriteString("| Member | Games | Wins | Losses | Draws | Longest win streak |\n")
+		b.WriteString("|:-------|------:|-----:|-------:|------:|-------------------:|\n")
+		for _, m := range members {
+			if m.games == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "| @%s | %d | %d | %d | %d | %d |\n", m.username, m.games, m.wins, m.losses, m.draws, m.bestStreak)
+		}
+	}
+
+	var gains []string
+	for _, perfKey := range lichess.PerfKeys {
+		var best *digestMember
+		bestGain, bestFrom := 0, 0
+		for _, m := range members {
+			if gain, from, ok := m.ratingGain(perfKey); ok && gain > bestGain {
+				best, bestGain, bestFrom = m, gain, from
+			}
+		}
+		if best != nil {
+			gains = append(gains, fmt.Sprintf("* %s: @%s %s (%d → %d)", lichess.PerfName(perfKey), best.username, formatProgress(bestGain), bestFrom, bestFrom+bestGain))
+		}
+	}
+	if len(gains) > 0 {
+		b.WriteString("\n##### Biggest rating gains\n")
+		b.WriteString(strings.Join(gains, "\n"))
+		b.WriteString("\n")
+	}
+
+	baseURL := p.getConfiguration().getBaseURL()
+	var titled []string
+	for _, m := range members {
+		for _, game := range m.titledWins {
+			opponent := game.Players.White
+			if opponent.User.Id == strings.ToLower(m.lichess) {
+				opponent = game.Players.Black
+			}
+			titled = append(titled, fmt.Sprintf("* @%s beat %s %s (%d) in %s. [View game](%s%s)",
+				m.username, opponent.User.Title, opponent.User.Name, opponent.Rating, lichess.PerfName(game.Perf), baseURL, game.Id))
+		}
+	}
+	if len(titled) > 0 {
+		b.WriteString("\n##### Wins against titled players\n")
+		b.WriteString(strings.Join(titled, "\n"))
+		b.WriteString("\n")
+	}
+
+	puzzlers := make([]*digestMember, 0, len(members))
+	for _, m := range members {
+		if m.puzzlesPlayed() > 0 || m.stormRunsPlayed() > 0 {
+			puzzlers = append(puzzlers, m)
+		}
+	}
+	if len(puzzlers) > 0 {
+		sort.SliceStable(puzzlers, func(i, j int) bool {
+			return puzzlers[i].puzzlesPlayed()+puzzlers[i].stormRunsPlayed() > puzzlers[j].puzzlesPlayed()+puzzlers[j].stormRunsPlayed()
+		})
+		b.WriteString("\n##### Puzzles\n")
+		b.WriteString("| Member | Puzzles this week | Storm runs this week | Puzzle rating | Storm high |\n")
+		b.WriteString("|:-------|------------------:|---------------------:|--------------:|-----------:|\n")
+		for _, m := range puzzlers {
+			rating := "-"
+			if r, ok := m.current.Ratings["puzzle"]; ok {
+				rating = fmt.Sprint(r)
+			}
+			storm := "-"
+			if m.current.Storm > 0 {
+				storm = fmt.Sprint(m.current.Storm)
+				if m.start != nil && m.start.Storm > 0 && m.current.Storm > m.start.Storm {
+					storm += " (new high)"
+				}
+			}
+			fmt.Fprintf(&b, "| @%s | %d | %d | %s | %s |\n", m.username, m.puzzlesPlayed(), m.stormRunsPlayed(), rating, storm)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,146 @@
+// Package eco names chess openings by their ECO code without a network
+// call. The tables are kept in the format of the a.tsv to e.tsv files of the
+// Lichess chess-openings dataset, and go generate replaces them with the
+// full files of the dataset. The tables checked in are a hand-picked subset
+// of the common openings, so rarer lines are named after the last opening
+// of the tables they pass through until they are regenerated.
+package eco
+
+import (
+	"embed"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/pkg/errors"
+)
+
+//go:generate sh -c "for f in a b c d e; do curl -fsSL -o $f.tsv https://raw.githubusercontent.com/lichess-org/chess-openings/master/$f.tsv || exit 1; done"
+
+//go:embed *.tsv
+var tables embed.FS
+
+type Opening struct {
+	ECO  string
+	Name string
+	// Ply is the number of moves of the table line, so a longer line is a
+	// more specific opening.
+	Ply int
+}
+
+func (o Opening) String() string {
+	return o.ECO + " " + o.Name
+}
+
+var (
+	loadOnce   sync.Once
+	byPosition map[string]Opening
+)
+
+// load indexes the embedded tables. A line that doesn't replay is skipped,
+// so a bad line can't take the plugin down; the tests fail on one instead.
+func load() {
+	byPosition, _ = parseTables(tables)
+}
+
+// parseTables replays every line of the tables and indexes the opening by
+// the position it reaches, so transpositions are recognized. It returns an
+// error for each line it skipped.
+func parseTables(fsys fs.FS) (map[string]Opening, []error) {
+	openings := map[string]Opening{}
+	var errs []error
+
+	names, err := fs.Glob(fsys, "*.tsv")
+	if err != nil {
+		return openings, []error{err}
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		for i, line := range lines[1:] {
+			opening, key, err := parseLine(line)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "%s:%d", name, i+2))
+				continue
+			}
+			if existing, ok := openings[key]; ok && existing.Ply <= opening.Ply {
+				continue
+			}
+			openings[key] = opening
+		}
+	}
+	return openings, errs
+}
+
+// parseLine replays a line of a table, and returns its opening and the key
+// of the position it reaches.
+func parseLine(line string) (Opening, string, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 3 {
+		return Opening{}, "", errors.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	game := chess.NewGame(chess.Standard.StartingPosition())
+	ply := 0
+	for _, token := range strings.Fields(fields[2]) {
+		if strings.HasSuffix(token, ".") {
+			continue
+		}
+		if _, err := game.PlayMove(token); err != nil {
+			return Opening{}, "", errors.Wrap(err, fields[1])
+		}
+		ply++
+	}
+	return Opening{ECO: fields[0], Name: fields[1], Ply: ply}, positionKey(game.Position()), nil
+}
+
+// positionKey identifies a position regardless of the move counters.
+func positionKey(pos *chess.Position) string {
+	fields := strings.Fields(pos.FEN())
+	if len(fields) > 4 {
+		fields = fields[:4]
+	}
+	return strings.Join(fields, " ")
+}
+
+// Classify names the opening of a standard game after the last of its
+// positions found in the table.
+func Classify(game *chess.Game) (Opening, bool) {
+	loadOnce.Do(load)
+
+	if game.StartingPosition().Variant != chess.Standard {
+		return Opening{}, false
+	}
+	positions := game.Positions()
+	for i := len(positions) - 1; i >= 0; i-- {
+		if opening, ok := byPosition[positionKey(positions[i])]; ok {
+			return opening, true
+		}
+	}
+	return Opening{}, false
+}
+
+// ClassifyMoves names the opening of moves in SAN or UCI played from the
+// standard starting position. Moves after an illegal one are ignored.
+func ClassifyMoves(moves []string) (Opening, bool) {
+	game := chess.NewGame(chess.Standard.StartingPosition())
+	for _, move := range moves {
+		if _, err := game.PlayMove(move); err != nil {
+			break
+		}
+	}
+	return Classify(game)
+}
+
+// ClassifyPGN names the opening of the first game of a PGN.
+func ClassifyPGN(pgn string) (Opening, bool) {
+	games, err := chess.ParsePGN(strings.NewReader(pgn))
+	if err != nil || len(games) == 0 || games[0].Variant() != chess.Standard || games[0].Tag("FEN") != "" {
+		return Opening{}, false
+	}
+	return ClassifyMoves(games[0].Moves)
+}
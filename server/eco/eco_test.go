@@ -0,0 +1,39 @@
+package eco
+
+import (
+	"testing"
+)
+
+func TestTablesLoad(t *testing.T) {
+	openings, errs := parseTables(tables)
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if len(openings) == 0 {
+		t.Fatal("no openings loaded")
+	}
+}
+
+func TestClassifyMoves(t *testing.T) {
+	tests := []struct {
+		moves []string
+		eco   string
+		name  string
+	}{
+		{[]string{"e4", "c5", "Nf3", "d6", "d4", "cxd4", "Nxd4", "Nf6", "Nc3", "a6"}, "B90", "Sicilian Defense: Najdorf Variation"},
+		// Transposes to the Queen's Gambit Declined.
+		{[]string{"c4", "e6", "d4", "d5"}, "D30", "Queen's Gambit Declined"},
+		// Moves past the end of the line keep its name.
+		{[]string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5", "a7a6", "b5a4", "g8f6", "e1g1"}, "C78", "Ruy Lopez: Morphy Defense"},
+	}
+	for _, test := range tests {
+		opening, ok := ClassifyMoves(test.moves)
+		if !ok {
+			t.Errorf("%v: not classified", test.moves)
+			continue
+		}
+		if opening.ECO != test.eco || opening.Name != test.name {
+			t.Errorf("%v: got %s, want %s %s", test.moves, opening, test.eco, test.name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/uci"
+)
+
+// enginePool returns the pool of the configured engine, or nil when no engine
+// is configured. The pool is replaced when the engine settings change.
+func (p *Plugin) enginePool() *uci.Pool {
+	config := p.getConfiguration()
+
+	p.engineLock.Lock()
+	defer p.engineLock.Unlock()
+
+	if p.engines != nil && p.enginePath == config.EnginePath && p.engineProcesses == config.getEngineProcesses() {
+		return p.engines
+	}
+	if p.engines != nil {
+		p.engines.Close()
+		p.engines = nil
+	}
+	p.enginePath, p.engineProcesses = config.EnginePath, config.getEngineProcesses()
+	if p.enginePath == "" {
+		return nil
+	}
+	p.engines = uci.NewPool(p.enginePath, p.engineProcesses, nil)
+	return p.engines
+}
+
+func (p *Plugin) closeEnginePool() {
+	p.engineLock.Lock()
+	defer p.engineLock.Unlock()
+
+	if p.engines != nil {
+		p.engines.Close()
+		p.engines = nil
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/uci"
+	"github.com/pkg/errors"
+)
+
+const (
+	gameEvalDepth    = 14
+	gameEvalMoveTime = 500 * time.Millisecond
+
+	// initialEvalCentipawns is the evaluation Lichess assumes for the
+	// standard starting position.
+	initialEvalCentipawns = 15
+
+	sourceLichessAnalysis = "Lichess server analysis"
+	sourceCloudEval       = "Lichess cloud eval"
+)
+
+// evalScore is an evaluation from white's point of view.
+type evalScore struct {
+	Centipawns int
+	// Mate is the number of moves to mate, negative when black mates, and
+	// zero when no mate was found.
+	Mate int
+}
+
+func (s evalScore) String() string {
+	if s.Mate != 0 {
+		return fmt.Sprintf("#%d", s.Mate)
+	}
+	return fmt.Sprintf("%+.2f", float64(s.Centipawns)/100)
+}
+
+// param encodes the score for the eval parameter of board images.
+func (s evalScore) param() string {
+	if s.Mate != 0 {
+		return fmt.Sprintf("#%d", s.Mate)
+	}
+	return strconv.Itoa(s.Centipawns)
+}
+
+func parseEvalScore(param string) (evalScore, bool) {
+	if param == "" {
+		return evalScore{}, false
+	}
+	if strings.HasPrefix(param, "#") {
+		mate, err := strconv.Atoi(param[1:])
+		return evalScore{Mate: mate}, err == nil && mate != 0
+	}
+	cp, err := strconv.Atoi(param)
+	return evalScore{Centipawns: cp}, err == nil
+}
+
+// winningChances maps the score to white's chances from -1 to 1 with the
+// formula Lichess uses for its evaluation bar and move judgments.
+func (s evalScore) winningChances() float64 {
+	switch {
+	case s.Mate > 0:
+		return 1
+	case s.Mate < 0:
+		return -1
+	}
+	cp := math.Max(-1000, math.Min(1000, float64(s.Centipawns)))
+	return 2/(1+math.Exp(-0.00368208*cp)) - 1
+}
+
+// whiteShare is the part of an evaluation bar filled for white.
+func (s evalScore) whiteShare() float64 {
+	return (1 + s.winningChances()) / 2
+}
+
+// evalLine is one of the best lines of a position.
+type evalLine struct {
+	Score evalScore
+	// Moves are in UCI notation.
+	Moves []string
+}
+
+// positionEval is what is known about a position.
+type positionEval struct {
+	Score evalScore
+	Depth int
+	// BestMove is in UCI notation, or empty when unknown.
+	BestMove string
+	Lines    []evalLine
+}
+
+// errNoEval is returned for positions that have no evaluation, because the
+// game is over or Lichess has not analysed them.
+var errNoEval = errors.New("no evaluation")
+
+// evaluatePosition evaluates a position with the local engine when one is
+// configured, and with Lichess cloud eval otherwise. It also returns who
+// evaluated it.
+func (p *Plugin) evaluatePosition(ctx context.Context, pos *chess.Position, multiPV int, limits uci.Request) (*positionEval, string, error) {
+	if !pos.HasLegalMoves() {
+		return nil, "", errNoEval
+	}
+	if pool := p.enginePool(); pool != nil && pos.Variant == chess.Standard {
+		limits.MultiPV = multiPV
+		return engineEval(ctx, pool, pos, limits)
+	}
+	eval, err := p.cloudEval(ctx, pos, multiPV)
+	return eval, sourceCloudEval, err
+}
+
+func engineEval(ctx context.Context, pool *uci.Pool, pos *chess.Position, req uci.Request) (*positionEval, string, error) {
+	req.FEN = pos.FEN()
+	analysis, err := pool.Analyse(ctx, req)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "engine analysis failed")
+	}
+	if len(analysis.Lines) == 0 {
+		return nil, "", errNoEval
+	}
+
+	eval := &positionEval{Depth: analysis.Depth, BestMove: analysis.BestMove}
+	for _, line := range analysis.Lines {
+		score := evalScore{Centipawns: line.Score.Centipawns, Mate: line.Score.Mate}
+		if pos.Turn == chess.Black {
+			score = evalScore{Centipawns: -score.Centipawns, Mate: -score.Mate}
+		}
+		eval.Lines = append(eval.Lines, evalLine{Score: score, Moves: line.Moves})
+	}
+	eval.Score = eval.Lines[0].Score
+	return eval, analysis.Engine, nil
+}
+
+func (p *Plugin) cloudEval(ctx context.Context, pos *chess.Position, multiPV int) (*positionEval, error) {
+	variant := ""
+	if pos.Variant != chess.Standard {
+		variant = string(pos.Variant)
+	}
+	cloud, err := p.newLichessClient().CloudEval(ctx, pos.FEN(), variant, multiPV)
+	if lichess.IsNotFound(err) {
+		return nil, errNoEval
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	eval := &positionEval{Depth: cloud.Depth}
+	for _, pv := range cloud.Pvs {
+		moves := strings.Fields(pv.Moves)
+		if len(moves) == 0 {
+			continue
+		}
+		eval.Lines = append(eval.Lines, evalLine{Score: evalScore{Centipawns: pv.Cp, Mate: pv.Mate}, Moves: moves})
+	}
+	if len(eval.Lines) == 0 {
+		return nil, errNoEval
+	}
+	eval.Score = eval.Lines[0].Score
+	eval.BestMove = eval.Lines[0].Moves[0]
+	return eval, nil
+}
+
+// evaluateGame evaluates every position of a game, using the local engine
+// when one is configured, the analysis Lichess made of the game when there
+// is one, and Lichess cloud eval otherwise. Positions that could not be
+// evaluated are nil.
+func (p *Plugin) evaluateGame(ctx context.Context, game *chess.Game, analysis []lichess.GameAnalysis) ([]*positionEval, string, error) {
+	positions := game.Positions()
+	evals := make([]*positionEval, len(positions))
+
+	if pool := p.enginePool(); pool != nil && game.StartingPosition().Variant == chess.Standard {
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		var source string
+		var firstErr error
+		for i, pos := range positions {
+			if !pos.HasLegalMoves() {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, pos *chess.Position) {
+				defer wg.Done()
+				eval, engine, err := engineEval(ctx, pool, pos, uci.Request{Depth: gameEvalDepth, MoveTime: gameEvalMoveTime})
+
+				lock.Lock()
+				defer lock.Unlock()
+				switch {
+				case err == nil:
+					evals[i], source = eval, engine
+				case errors.Cause(err) != errNoEval && firstErr == nil:
+					firstErr = err
+				}
+			}(i, pos)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return nil, "", firstErr
+		}
+		return evals, source, nil
+	}
+
+	if len(analysis) > 0 {
+		if game.StartingPosition().FEN() == chess.Standard.StartingPosition().FEN() {
+			evals[0] = &positionEval{Score: evalScore{Centipawns: initialEvalCentipawns}}
+		}
+		for i, a := range analysis {
+			if i+1 >= len(positions) {
+				break
+			}
+			if a.Best != "" && evals[i] != nil {
+				evals[i].BestMove = a.Best
+			}
+			if positions[i+1].HasLegalMoves() {
+				evals[i+1] = &positionEval{Score: evalScore{Centipawns: a.Eval, Mate: a.Mate}}
+			}
+		}
+		return evals, sourceLichessAnalysis, nil
+	}
+
+	// Cloud eval only knows popular positions, so the first miss ends the
+	// evaluation instead of sending a request for every later move.
+	for i, pos := range positions {
+		if !pos.HasLegalMoves() {
+			break
+		}
+		eval, err := p.cloudEval(ctx, pos, 1)
+		if err == errNoEval {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		evals[i] = eval
+	}
+	return evals, sourceCloudEval, nil
+}
+
+// lineText formats a line of UCI moves played from pos in SAN, up to max
+// moves.
+func lineText(pos *chess.Position, moves []string, max int) string {
+	game := chess.NewGame(pos)
+	for i, move := range moves {
+		if i == max {
+			break
+		}
+		if _, err := game.PlayMove(move); err != nil {
+			break
+		}
+	}
+	return movesText(game)
+}
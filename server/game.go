@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/eco"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+var gameStatusText = map[string]string{
+	"mate":       "checkmate",
+	"resign":     "resignation",
+	"stalemate":  "stalemate",
+	"timeout":    "the opponent leaving",
+	"draw":       "agreement",
+	"outoftime":  "time forfeit",
+	"cheat":      "cheat detection",
+	"noStart":    "a player not moving",
+	"aborted":    "abort",
+	"variantEnd": "variant rules",
+}
+
+// replayLichessGame rebuilds a game exported with SAN moves so it can be
+// rendered with the local chess package.
+func replayLichessGame(game *lichess.Game) (*chess.Game, error) {
+	variant, err := chess.ParseVariant(game.Variant)
+	if err != nil {
+		// "fromPosition" games follow the standard rules.
+		variant = chess.Standard
+	}
+
+	start := variant.StartingPosition()
+	if game.InitialFen != "" {
+		start, err = chess.ParseVariantFEN(variant, game.InitialFen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	replayed := chess.NewGame(start)
+	for _, san := range strings.Fields(game.Moves) {
+		if _, err := replayed.PlayMove(san); err != nil {
+			return nil, errors.Wrapf(err, "failed to replay game %s", game.Id)
+		}
+	}
+	return replayed, nil
+}
+
+func gameResult(game *lichess.Game) string {
+	switch {
+	case game.Winner == "white":
+		return "1-0"
+	case game.Winner == "black":
+		return "0-1"
+	case isGameFinished(game):
+		return "½-½"
+	default:
+		return "*"
+	}
+}
+
+func isGameFinished(game *lichess.Game) bool {
+	return game.Status != "" && game.Status != "created" && game.Status != "started"
+}
+
+func gameResultText(game *lichess.Game) string {
+	if !isGameFinished(game) {
+		return "Playing"
+	}
+	reason := gameStatusText[game.Status]
+	if reason == "" {
+		reason = game.Status
+	}
+	switch game.Winner {
+	case "white":
+		return "1-0, white won by " + reason
+	case "black":
+		return "0-1, black won by " + reason
+	}
+	if game.Status == "aborted" {
+		return "Aborted"
+	}
+	return "½-½, draw by " + reason
+}
+
+func playerText(player lichess.GamePlayer) string {
+	var name string
+	switch {
+	case player.User.Name != "":
+		name = player.User.Name
+		if player.User.Title != "" {
+			name = player.User.Title + " " + name
+		}
+	case player.AiLevel > 0:
+		return fmt.Sprintf("Stockfish level %d", player.AiLevel)
+	case player.Name != "":
+		name = player.Name
+	default:
+		return "Anonymous"
+	}
+
+	if player.Rating == 0 {
+		return name
+	}
+	rating := fmt.Sprint(player.Rating)
+	if player.Provisional {
+		rating += "?"
+	}
+	if player.RatingDiff > 0 {
+		rating += fmt.Sprintf(", +%d", player.RatingDiff)
+	} else if player.RatingDiff < 0 {
+		rating += fmt.Sprintf(", %d", player.RatingDiff)
+	}
+	return fmt.Sprintf("%s (%s)", name, rating)
+}
+
+// formatClock formats a time control the way Lichess does, e.g. "3+2" or
+// "½+0".
+func formatClock(initialSeconds, increment int) string {
+	var initial string
+	switch initialSeconds {
+	case 15:
+		initial = "¼"
+	case 30:
+		initial = "½"
+	case 45:
+		initial = "¾"
+	case 90:
+		initial = "1.5"
+	default:
+		initial = fmt.Sprint(initialSeconds / 60)
+	}
+	return fmt.Sprintf("%s+%d", initial, increment)
+}
+
+func timeControlText(game *lichess.Game) string {
+	switch {
+	case game.Clock.Initial > 0 || game.Clock.Increment > 0:
+		return formatClock(game.Clock.Initial, game.Clock.Increment)
+	case game.DaysPerTurn > 0:
+		return fmt.Sprintf("%d days per move", game.DaysPerTurn)
+	default:
+		return "Unlimited"
+	}
+}
+
+func gameDescription(game *lichess.Game) string {
+	mode := "Casual"
+	if game.Rated {
+		mode = "Rated"
+	}
+
+	kind := capitalize(game.Speed)
+	if variant, err := chess.ParseVariant(game.Variant); err == nil && variant != chess.Standard {
+		kind = variant.Name()
+	}
+	return fmt.Sprintf("%s %s • %s", mode, kind, timeControlText(game))
+}
+
+func openingText(opening lichess.Opening) string {
+	if opening.Name == "" {
+		return ""
+	}
+	if opening.Eco == "" {
+		return opening.Name
+	}
+	return opening.Eco + " " + opening.Name
+}
+
+// gameAttachment builds the preview of a Lichess game. The board is shown
+// from black's side when color is "black".
+func (p *Plugin) gameAttachment(game *lichess.Game, color string) *model.SlackAttachment {
+	link := p.getConfiguration().getBaseURL() + game.Id
+	if color == "black" {
+		link += "/black"
+	}
+
+	attachment := &model.SlackAttachment{
+		Fallback:  fmt.Sprintf("%s vs %s: %s", playerText(game.Players.White), playerText(game.Players.Black), link),
+		Color:     "#629924",
+		Title:     fmt.Sprintf("%s vs %s", playerText(game.Players.White), playerText(game.Players.Black)),
+		TitleLink: link,
+		Text:      gameDescription(game),
+		Fields: []*model.SlackAttachmentField{
+			{Title: "Result", Value: gameResultText(game), Short: true},
+		},
+	}
+	attachment.Actions = []*model.PostAction{p.pgnExportAction(game.Id)}
+
+	// Lichess leaves out the opening of some games, e.g. imported ones.
+	replayed, err := replayLichessGame(game)
+	opening := openingText(game.Opening)
+	if opening == "" && err == nil {
+		if classified, ok := eco.Classify(replayed); ok {
+			opening = classified.String()
+		}
+	}
+	if opening != "" {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{Title: "Opening", Value: opening, Short: true})
+	}
+	if err != nil {
+		p.API.LogDebug("failed to replay game for preview", "gameID", game.Id, "error", err.Error())
+		return attachment
+	}
+	lastMove := ""
+	if ucis := replayed.UCIMoves(); len(ucis) > 0 {
+		lastMove = ucis[len(ucis)-1]
+	}
+	attachment.ImageURL = p.boardImageURL(replayed.Position().FEN(), lastMove, color == "black")
+	return attachment
+}
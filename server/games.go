@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/eco"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	gamesPageSize     = 10
+	maxGamesPages     = 20
+	maxOpeningNameLen = 40
+)
+
+// gamesQuery is a listing of recent games. It is kept in the context of the
+// page buttons so that each page repeats the same query.
+type gamesQuery struct {
+	Username string
+	Since    int64
+	PerfType string
+	Vs       string
+	Page     int
+}
+
+func (p *Plugin) executeGamesCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	query := gamesQuery{}
+	var user *model.User
+	for i := 0; i < len(params); i++ {
+		param := params[i]
+		switch {
+		case strings.HasPrefix(param, "@"):
+			mentioned, err := p.userFromMention(param)
+			if err != nil {
+				return ephemeralResponse(err.Error())
+			}
+			user = mentioned
+		case param == "--since" || param == "--perf" || param == "--vs":
+			if i+1 == len(params) {
+				return ephemeralResponsef("Missing value for %s.", param)
+			}
+			i++
+			value := params[i]
+			switch param {
+			case "--since":
+				period, ok := parsePeriod(value)
+				if !ok {
+					return ephemeralResponsef("Unknown period %q. Use a period such as `7d`, `2w` or `6m`.", value)
+				}
+				if period > 0 {
+					query.Since = time.Now().Add(-period).UnixMilli()
+				}
+			case "--perf":
+				perfType, ok := lichess.ParsePerfKey(value)
+				if !ok {
+					return ephemeralResponsef("Unknown perf %q. Use one of %s.", value, strings.Join(lichess.PerfKeys, ", "))
+				}
+				query.PerfType = perfType
+			case "--vs":
+				query.Vs = strings.TrimPrefix(value, "@")
+			}
+		default:
+			return ephemeralResponse("Use `/lichess games [@user] [--since 7d] [--perf blitz] [--vs lichessuser]`.")
+		}
+	}
+
+	if user == nil {
+		self, err := p.pluginAPI.User.Get(args.UserId)
+		if err != nil {
+			return ephemeralResponse("Failed to load your user.")
+		}
+		user = self
+	}
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return ephemeralResponse("Failed to load the connected users.")
+	}
+	lichessUsername, ok := connected[user.Id]
+	if !ok {
+		if user.Id == args.UserId {
+			return p.notConnectedResponse()
+		}
+		return ephemeralResponsef("@%s has not connected a Lichess account.", user.Username)
+	}
+	query.Username = lichessUsername
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+	}
+	if err := p.renderGamesPage(post, query); err != nil {
+		p.API.LogWarn("failed to fetch games", "lichessUsername", lichessUsername, "error", err.Error())
+		return ephemeralResponse(lichessErrorText(err))
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post games", "error", err.Error())
+		return ephemeralResponse("Failed to post the games.")
+	}
+	return &model.CommandResponse{}
+}
+
+// renderGamesPage fetches a page of games and sets the post's message and
+// page buttons to show it.
+func (p *Plugin) renderGamesPage(post *model.Post, query gamesQuery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	filter := lichess.GamesFilter{
+		Max:      (query.Page+1)*gamesPageSize + 1,
+		PerfType: query.PerfType,
+		Vs:       query.Vs,
+	}
+	if query.Since > 0 {
+		filter.Since = time.UnixMilli(query.Since)
+	}
+
+	var games []*lichess.Game
+	err := p.newStreamingLichessClient().UserGames(ctx, query.Username, filter, func(game *lichess.Game) error {
+		games = append(games, game)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hasNext := len(games) > (query.Page+1)*gamesPageSize && query.Page+1 < maxGamesPages
+	start := query.Page * gamesPageSize
+	if start > len(games) {
+		start = len(games)
+	}
+	games = games[start:]
+	if len(games) > gamesPageSize {
+		games = games[:gamesPageSize]
+	}
+
+	post.Message = p.gamesMessage(query, games)
+
+	var actions []*model.PostAction
+	if query.Page > 0 {
+		actions = append(actions, p.gamesPageAction("previous", "Previous", query, query.Page-1))
+	}
+	if hasNext {
+		actions = append(actions, p.gamesPageAction("next", "Next", query, query.Page+1))
+	}
+	post.DelProp("attachments")
+	if len(actions) > 0 {
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{{Actions: actions}})
+	}
+	return nil
+}
+
+func (p *Plugin) gamesPageAction(id, name string, query gamesQuery, page int) *model.PostAction {
+	query.Page = page
+	b, _ := json.Marshal(query)
+	return &model.PostAction{
+		Id:   id,
+		Name: name,
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			URL:     *p.pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL + pluginURLPath + "/games/page",
+			Context: map[string]interface{}{"query": string(b)},
+		},
+	}
+}
+
+func (p *Plugin) gamesMessage(query gamesQuery, games []*lichess.Game) string {
+	baseURL := p.getConfiguration().getBaseURL()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Recent games of [%s](%s@/%s)\n", query.Username, baseURL, query.Username)
+	var filters []string
+	if query.PerfType != "" {
+		filters = append(filters, lichess.PerfName(query.PerfType))
+	}
+	if query.Vs != "" {
+		filters = append(filters, "against "+query.Vs)
+	}
+	if query.Since > 0 {
+		filters = append(filters, "since "+time.UnixMilli(query.Since).UTC().Format("Jan 2, 2006"))
+	}
+	if len(filters) > 0 {
+		b.WriteString(strings.Join(filters, ", ") + "\n")
+	}
+	if len(games) == 0 {
+		b.WriteString("\nNo games found.")
+		return b.String()
+	}
+
+	b.WriteString("\n| Date | Opponent | Result | Rating | Opening | |\n")
+	b.WriteString("|:-----|:---------|:-------|-------:|:--------|:-|\n")
+	for _, game := range games {
+		color, player, opponent := "white", game.Players.White, game.Players.Black
+		if strings.EqualFold(game.Players.Black.User.Name, query.Username) {
+			color, player, opponent = "black", game.Players.Black, game.Players.White
+		}
+
+		// The rating change shown is the player's.
+		opponent.RatingDiff = 0
+
+		opening := game.Opening.Name
+		if opening == "" && game.InitialFen == "" {
+			if classified, ok := eco.ClassifyMoves(strings.Fields(game.Moves)); ok {
+				opening = classified.Name
+			}
+		}
+		if runes := []rune(opening); len(runes) > maxOpeningNameLen {
+			opening = strings.TrimSpace(string(runes[:maxOpeningNameLen])) + "…"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | [%s](%s%s/%s) |\n",
+			time.UnixMilli(game.CreatedAt).UTC().Format("Jan 2"),
+			playerText(opponent),
+			gameOutcome(game, color), ratingChangeText(player), opening,
+			timeControlText(game), baseURL, game.Id, color)
+	}
+	fmt.Fprintf(&b, "\nPage %d", query.Page+1)
+	return b.String()
+}
+
+// gameOutcome describes a game from the side of color.
+func gameOutcome(game *lichess.Game, color string) string {
+	switch {
+	case !isGameFinished(game):
+		return "Playing"
+	case game.Status == "aborted":
+		return "Aborted"
+	case game.Winner == color:
+		return ":white_check_mark: Won"
+	case game.Winner != "":
+		return ":x: Lost"
+	default:
+		return ":handshake: Draw"
+	}
+}
+
+func ratingChangeText(player lichess.GamePlayer) string {
+	if player.Rating == 0 {
+		return ""
+	}
+	switch {
+	case player.RatingDiff > 0:
+		return fmt.Sprintf("%d (+%d)", player.Rating, player.RatingDiff)
+	case player.RatingDiff < 0:
+		return fmt.Sprintf("%d (%d)", player.Rating, player.RatingDiff)
+	default:
+		return fmt.Sprint(player.Rating)
+	}
+}
+
+// handleGamesPage shows another page of a games listing when one of its
+// buttons is clicked.
+func (p *Plugin) handleGamesPage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	post, ok := p.actionPost(userID, request.PostId)
+	if !ok || post.UserId != p.botUserID {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	}
+
+	// Only the pages the listing links to can be shown.
+	encoded, _ := request.Context["query"].(string)
+	linked := false
+	for _, context := range actionContexts(post, "/games/page") {
+		if stored, _ := context["query"].(string); stored == encoded {
+			linked = true
+			break
+		}
+	}
+	if !linked {
+		http.Error(w, "invalid games query", http.StatusBadRequest)
+		return
+	}
+	var query gamesQuery
+	if err := json.Unmarshal([]byte(encoded), &query); err != nil || query.Username == "" || query.Page < 0 || query.Page >= maxGamesPages {
+		http.Error(w, "invalid games query", http.StatusBadRequest)
+		return
+	}
+
+	response := &model.PostActionIntegrationResponse{}
+	if err := p.renderGamesPage(post, query); err != nil {
+		p.API.LogWarn("failed to fetch games", "lichessUsername", query.Username, "error", err.Error())
+		response.EphemeralText = lichessErrorText(err)
+	} else {
+		response.Update = post
+	}
+	p.writeJSON(w, response)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+type leaderboardEntry struct {
+	userID   string
+	username string
+	lichess  string
+	perf     lichess.Perf
+}
+
+func (p *Plugin) executeLeaderboardCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	perfKey, showProvisional := "blitz", false
+	for _, param := range params {
+		if param == "--provisional" || param == "provisional" {
+			showProvisional = true
+			continue
+		}
+		key, ok := lichess.ParsePerfKey(param)
+		if !ok {
+			return ephemeralResponsef("Unknown perf %q. Use one of %s.", param, strings.Join(lichess.PerfKeys, ", "))
+		}
+		perfKey = key
+	}
+
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return ephemeralResponse("Failed to load the connected users.")
+	}
+
+	var entries []leaderboardEntry
+	var usernames []string
+	for userID, lichessUsername := range connected {
+		member, appErr := p.API.GetTeamMember(args.TeamId, userID)
+		if appErr != nil || member.DeleteAt != 0 {
+			continue
+		}
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil || user.DeleteAt != 0 {
+			continue
+		}
+		entries = append(entries, leaderboardEntry{userID: userID, username: user.Username, lichess: lichessUsername})
+		usernames = append(usernames, lichessUsername)
+	}
+	if len(entries) == 0 {
+		return ephemeralResponse("Nobody in this team has connected a Lichess account yet.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	accounts, err := p.getLichessAccounts(ctx, usernames)
+	if err != nil {
+		p.API.LogWarn("failed to fetch Lichess users", "error", err.Error())
+		return ephemeralResponse("Failed to fetch ratings from Lichess.")
+	}
+
+	ranked := entries[:0]
+	hidden := 0
+	for _, entry := range entries {
+		account, ok := accounts[strings.ToLower(entry.lichess)]
+		if !ok {
+			continue
+		}
+		perf, _ := account.Perfs.Get(perfKey)
+		if perf.Games == 0 {
+			continue
+		}
+		if perf.Prov && !showProvisional {
+			hidden++
+			continue
+		}
+		entry.perf = perf
+		ranked = append(ranked, entry)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].perf.Rating != ranked[j].perf.Rating {
+			return ranked[i].perf.Rating > ranked[j].perf.Rating
+		}
+		return ranked[i].username < ranked[j].username
+	})
+
+	return ephemeralResponse(p.leaderboardText(perfKey, ranked, hidden))
+}
+
+func (p *Plugin) leaderboardText(perfKey string, ranked []leaderboardEntry, hidden int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### %s leaderboard\n", lichess.PerfName(perfKey))
+	if len(ranked) == 0 {
+		b.WriteString("Nobody in this team has an established rating yet.")
+	} else {
+		b.WriteString("| # | User | Lichess | Rating | Games | Progress |\n")
+		b.WriteString("|--:|:-----|:--------|-------:|------:|---------:|\n")
+		baseURL := p.getConfiguration().getBaseURL()
+		for i, entry := range ranked {
+			rating := fmt.Sprint(entry.perf.Rating)
+			if entry.perf.Prov {
+				rating += "?"
+			}
+			fmt.Fprintf(&b, "| %d | @%s | [%s](%s@/%s) | %s | %d | %s |\n",
+				i+1, entry.username, entry.lichess, baseURL, entry.lichess, rating, entry.perf.Games, formatProgress(entry.perf.Prog))
+		}
+	}
+	if hidden > 0 {
+		fmt.Fprintf(&b, "\n%d players with provisional ratings are hidden, add `--provisional` to show them.", hidden)
+	}
+	return b.String()
+}
+
+func formatProgress(prog int) string {
+	switch {
+	case prog > 0:
+		return fmt.Sprintf("+%d", prog)
+	case prog < 0:
+		return fmt.Sprint(prog)
+	default:
+		return "0"
+	}
+}
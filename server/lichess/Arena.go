@@ -0,0 +1,19 @@
+package lichess
+
+type Arena struct {
+	Id              string        `json:"id"`
+	CreatedBy       string        `json:"createdBy"`
+	FullName        string        `json:"fullName"`
+	StartsAt        string        `json:"startsAt"`
+	Minutes         int           `json:"minutes"`
+	Clock           ArenaClock    `json:"clock"`
+	Variant         string        `json:"variant"`
+	Rated           bool          `json:"rated"`
+	NbPlayers       int           `json:"nbPlayers"`
+	IsStarted       bool          `json:"isStarted"`
+	IsFinished      bool          `json:"isFinished"`
+	SecondsToStart  int           `json:"secondsToStart"`
+	SecondsToFinish int           `json:"secondsToFinish"`
+	Standing        ArenaStanding `json:"standing"`
+	Podium          []ArenaPlayer `json:"podium"`
+}
@@ -0,0 +1,6 @@
+package lichess
+
+type ArenaClock struct {
+	Limit     int `json:"limit"`
+	Increment int `json:"increment"`
+}
@@ -0,0 +1,15 @@
+package lichess
+
+// ArenaOptions describes an arena tournament to create. ClockTime is in
+// minutes, ClockIncrement in seconds and Minutes is the duration of the
+// tournament. TeamID restricts entry to the members of a team.
+type ArenaOptions struct {
+	Name           string
+	ClockTime      float64
+	ClockIncrement int
+	Minutes        int
+	WaitMinutes    int
+	Variant        string
+	Rated          bool
+	TeamID         string
+}
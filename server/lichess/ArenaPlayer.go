@@ -0,0 +1,10 @@
+package lichess
+
+type ArenaPlayer struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Rank        int    `json:"rank"`
+	Rating      int    `json:"rating"`
+	Score       int    `json:"score"`
+	Performance int    `json:"performance"`
+}
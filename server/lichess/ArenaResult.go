@@ -0,0 +1,10 @@
+package lichess
+
+type ArenaResult struct {
+	Rank        int    `json:"rank"`
+	Score       int    `json:"score"`
+	Rating      int    `json:"rating"`
+	Username    string `json:"username"`
+	Title       string `json:"title"`
+	Performance int    `json:"performance"`
+}
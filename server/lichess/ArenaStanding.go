@@ -0,0 +1,6 @@
+package lichess
+
+type ArenaStanding struct {
+	Page    int           `json:"page"`
+	Players []ArenaPlayer `json:"players"`
+}
@@ -0,0 +1,564 @@
+package lichess
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Client talks to the Lichess API of a lichess.org compatible server.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for baseURL. The HTTP client decides how
+// requests are authenticated, e.g. one created by oauth2.NewClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// APIError is returned for responses with an unexpected status code.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("lichess API error %d: %s", e.StatusCode, e.Message)
+}
+
+func IsNotFound(err error) bool {
+	apiErr, ok := errors.Cause(err).(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) URL(path string) string {
+	return c.baseURL + path
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.URL(path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "request to %s failed", req.URL.Path)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return res, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode response from %s", path)
+	}
+	return nil
+}
+
+func (c *Client) getText(ctx context.Context, path string, query url.Values, accept string) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", accept)
+
+	res, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read response from %s", path)
+	}
+	return string(body), nil
+}
+
+// postForm posts a form and decodes the JSON response into v.
+func (c *Client) postForm(ctx context.Context, path string, form url.Values, v interface{}) error {
+	req, err := c.newRequest(ctx, http.MethodPost, path, nil, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode response from %s", path)
+	}
+	return nil
+}
+
+// Account returns the account of the user the client is authenticated as.
+func (c *Client) Account(ctx context.Context) (*LichessAccount, error) {
+	var account LichessAccount
+	if err := c.getJSON(ctx, "/api/account", nil, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// ExportGame returns a game including its opening, SAN moves and, when it
+// was analysed, evaluations.
+func (c *Client) ExportGame(ctx context.Context, id string) (*Game, error) {
+	query := url.Values{}
+	query.Set("opening", "true")
+	query.Set("moves", "true")
+	query.Set("evals", "true")
+
+	var game Game
+	if err := c.getJSON(ctx, "/game/export/"+url.PathEscape(id), query, &game); err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+// CurrentGame returns the ongoing game of a user, or their last game when
+// they are not playing.
+func (c *Client) CurrentGame(ctx context.Context, username string) (*Game, error) {
+	query := url.Values{}
+	query.Set("moves", "false")
+
+	var game Game
+	if err := c.getJSON(ctx, "/api/user/"+url.PathEscape(username)+"/current-game", query, &game); err != nil {
+		return nil, err
+	}
+	return &game, nil
+}
+
+// GamePGN returns a game as PGN with its clocks, evaluations and opening.
+func (c *Client) GamePGN(ctx context.Context, id string) (string, error) {
+	return c.getText(ctx, "/game/export/"+url.PathEscape(id), pgnExportQuery(), "application/x-chess-pgn")
+}
+
+// ArenaGamesPGN returns every game of an arena as PGN.
+func (c *Client) ArenaGamesPGN(ctx context.Context, id string) (string, error) {
+	return c.getText(ctx, "/api/tournament/"+url.PathEscape(id)+"/games", pgnExportQuery(), "application/x-chess-pgn")
+}
+
+// SwissGamesPGN returns every game of a Swiss tournament as PGN.
+func (c *Client) SwissGamesPGN(ctx context.Context, id string) (string, error) {
+	return c.getText(ctx, "/api/swiss/"+url.PathEscape(id)+"/games", pgnExportQuery(), "application/x-chess-pgn")
+}
+
+func pgnExportQuery() url.Values {
+	query := url.Values{}
+	query.Set("clocks", "true")
+	query.Set("evals", "true")
+	query.Set("opening", "true")
+	return query
+}
+
+// StudyPGN returns every chapter of a study as PGN.
+func (c *Client) StudyPGN(ctx context.Context, studyID string) (string, error) {
+	return c.getText(ctx, "/api/study/"+url.PathEscape(studyID)+".pgn", nil, "application/x-chess-pgn")
+}
+
+// BroadcastRoundPGN returns every game of a broadcast round as PGN.
+func (c *Client) BroadcastRoundPGN(ctx context.Context, roundID string) (string, error) {
+	return c.getText(ctx, "/api/broadcast/round/"+url.PathEscape(roundID)+".pgn", nil, "application/x-chess-pgn")
+}
+
+// DailyPuzzle returns the puzzle of the day.
+func (c *Client) DailyPuzzle(ctx context.Context) (*DailyPuzzle, error) {
+	var puzzle DailyPuzzle
+	if err := c.getJSON(ctx, "/api/puzzle/daily", nil, &puzzle); err != nil {
+		return nil, err
+	}
+	return &puzzle, nil
+}
+
+// maxUsersPerRequest is the limit of the bulk users endpoint.
+const maxUsersPerRequest = 300
+
+// Users returns the public data of many users, fetched in batches.
+func (c *Client) Users(ctx context.Context, usernames []string) ([]LichessAccount, error) {
+	var users []LichessAccount
+	for start := 0; start < len(usernames); start += maxUsersPerRequest {
+		end := start + maxUsersPerRequest
+		if end > len(usernames) {
+			end = len(usernames)
+		}
+
+		req, err := c.newRequest(ctx, http.MethodPost, "/api/users", nil, strings.NewReader(strings.Join(usernames[start:end], ",")))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Accept", "application/json")
+
+		res, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []LichessAccount
+		err = json.NewDecoder(res.Body).Decode(&batch)
+		res.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode users")
+		}
+		users = append(users, batch...)
+	}
+	return users, nil
+}
+
+// maxStatusesPerRequest is the limit of the users status endpoint.
+const maxStatusesPerRequest = 100
+
+// UsersStatus returns whether users are online, playing or streaming,
+// fetched in batches. With withGameIDs the ID of the game a user is playing
+// is included. Unknown users are left out.
+func (c *Client) UsersStatus(ctx context.Context, ids []string, withGameIDs bool) ([]UserStatus, error) {
+	var statuses []UserStatus
+	for start := 0; start < len(ids); start += maxStatusesPerRequest {
+		end := start + maxStatusesPerRequest
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query := url.Values{}
+		query.Set("ids", strings.Join(ids[start:end], ","))
+		if withGameIDs {
+			query.Set("withGameIds", "true")
+		}
+		var batch []UserStatus
+		if err := c.getJSON(ctx, "/api/users/status", query, &batch); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, batch...)
+	}
+	return statuses, nil
+}
+
+// UserGames streams the games of a user, most recent first, and calls fn for
+// each of them. Streaming stops at the first error fn returns.
+func (c *Client) UserGames(ctx context.Context, username string, filter GamesFilter, fn func(*Game) error) error {
+	query := url.Values{}
+	query.Set("opening", "true")
+	query.Set("moves", "true")
+	if !filter.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(filter.Since.UnixMilli(), 10))
+	}
+	if !filter.Until.IsZero() {
+		query.Set("until", strconv.FormatInt(filter.Until.UnixMilli(), 10))
+	}
+	if filter.Max > 0 {
+		query.Set("max", strconv.Itoa(filter.Max))
+	}
+	if filter.PerfType != "" {
+		query.Set("perfType", filter.PerfType)
+	}
+	if filter.Vs != "" {
+		query.Set("vs", filter.Vs)
+	}
+	if filter.Rated != nil {
+		query.Set("rated", strconv.FormatBool(*filter.Rated))
+	}
+	if filter.Evals {
+		query.Set("evals", "true")
+	}
+
+	path := "/api/games/user/" + url.PathEscape(username)
+	return c.streamNDJSON(ctx, path, query, func(decoder *json.Decoder) error {
+		var game Game
+		if err := decoder.Decode(&game); err != nil {
+			return errors.Wrapf(err, "failed to decode game from %s", path)
+		}
+		return fn(&game)
+	})
+}
+
+// streamNDJSON requests newline delimited JSON and calls next for every
+// value until the response ends. next decodes the value itself.
+func (c *Client) streamNDJSON(ctx context.Context, path string, query url.Values, next func(*json.Decoder) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	decoder := json.NewDecoder(res.Body)
+	for decoder.More() {
+		if err := next(decoder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TeamMembers streams the members of a team, most recent first.
+func (c *Client) TeamMembers(ctx context.Context, teamID string, fn func(*TeamMember) error) error {
+	path := "/api/team/" + url.PathEscape(teamID) + "/users"
+	return c.streamNDJSON(ctx, path, nil, func(decoder *json.Decoder) error {
+		var member TeamMember
+		if err := decoder.Decode(&member); err != nil {
+			return errors.Wrapf(err, "failed to decode team member from %s", path)
+		}
+		return fn(&member)
+	})
+}
+
+// CreateArena creates an arena tournament as the authenticated user.
+func (c *Client) CreateArena(ctx context.Context, opts ArenaOptions) (*Arena, error) {
+	form := url.Values{}
+	form.Set("name", opts.Name)
+	form.Set("clockTime", strconv.FormatFloat(opts.ClockTime, 'f', -1, 64))
+	form.Set("clockIncrement", strconv.Itoa(opts.ClockIncrement))
+	form.Set("minutes", strconv.Itoa(opts.Minutes))
+	if opts.WaitMinutes > 0 {
+		form.Set("waitMinutes", strconv.Itoa(opts.WaitMinutes))
+	}
+	if opts.Variant != "" {
+		form.Set("variant", opts.Variant)
+	}
+	form.Set("rated", strconv.FormatBool(opts.Rated))
+	if opts.TeamID != "" {
+		form.Set("conditions.teamMember.teamId", opts.TeamID)
+	}
+
+	var arena Arena
+	if err := c.postForm(ctx, "/api/tournament", form, &arena); err != nil {
+		return nil, err
+	}
+	return &arena, nil
+}
+
+// Arena returns an arena tournament with the first page of its standings.
+func (c *Client) Arena(ctx context.Context, id string) (*Arena, error) {
+	var arena Arena
+	if err := c.getJSON(ctx, "/api/tournament/"+url.PathEscape(id), nil, &arena); err != nil {
+		return nil, err
+	}
+	return &arena, nil
+}
+
+// ArenaResults streams the results of an arena tournament by rank. A max of
+// zero streams every player.
+func (c *Client) ArenaResults(ctx context.Context, id string, max int, fn func(*ArenaResult) error) error {
+	query := url.Values{}
+	if max > 0 {
+		query.Set("nb", strconv.Itoa(max))
+	}
+	path := "/api/tournament/" + url.PathEscape(id) + "/results"
+	return c.streamNDJSON(ctx, path, query, func(decoder *json.Decoder) error {
+		var result ArenaResult
+		if err := decoder.Decode(&result); err != nil {
+			return errors.Wrapf(err, "failed to decode result from %s", path)
+		}
+		return fn(&result)
+	})
+}
+
+// CreateSwiss creates a Swiss tournament for a team the authenticated user
+// leads.
+func (c *Client) CreateSwiss(ctx context.Context, teamID string, opts SwissOptions) (*Swiss, error) {
+	form := url.Values{}
+	form.Set("name", opts.Name)
+	form.Set("clock.limit", strconv.Itoa(opts.ClockLimit))
+	form.Set("clock.increment", strconv.Itoa(opts.ClockIncrement))
+	form.Set("nbRounds", strconv.Itoa(opts.NbRounds))
+	if opts.RoundInterval > 0 {
+		form.Set("roundInterval", strconv.Itoa(opts.RoundInterval))
+	}
+	if opts.Variant != "" {
+		form.Set("variant", opts.Variant)
+	}
+	form.Set("rated", strconv.FormatBool(opts.Rated))
+
+	var swiss Swiss
+	if err := c.postForm(ctx, "/api/swiss/new/"+url.PathEscape(teamID), form, &swiss); err != nil {
+		return nil, err
+	}
+	return &swiss, nil
+}
+
+// Swiss returns a Swiss tournament.
+func (c *Client) Swiss(ctx context.Context, id string) (*Swiss, error) {
+	var swiss Swiss
+	if err := c.getJSON(ctx, "/api/swiss/"+url.PathEscape(id), nil, &swiss); err != nil {
+		return nil, err
+	}
+	return &swiss, nil
+}
+
+// SwissResults streams the results of a Swiss tournament by rank. A max of
+// zero streams every player.
+func (c *Client) SwissResults(ctx context.Context, id string, max int, fn func(*SwissResult) error) error {
+	query := url.Values{}
+	if max > 0 {
+		query.Set("nb", strconv.Itoa(max))
+	}
+	path := "/api/swiss/" + url.PathEscape(id) + "/results"
+	return c.streamNDJSON(ctx, path, query, func(decoder *json.Decoder) error {
+		var result SwissResult
+		if err := decoder.Decode(&result); err != nil {
+			return errors.Wrapf(err, "failed to decode result from %s", path)
+		}
+		return fn(&result)
+	})
+}
+
+// SwissGames streams the games of a Swiss tournament, last round first.
+func (c *Client) SwissGames(ctx context.Context, id string, fn func(*Game) error) error {
+	query := url.Values{}
+	query.Set("moves", "false")
+	path := "/api/swiss/" + url.PathEscape(id) + "/games"
+	return c.streamNDJSON(ctx, path, query, func(decoder *json.Decoder) error {
+		var game Game
+		if err := decoder.Decode(&game); err != nil {
+			return errors.Wrapf(err, "failed to decode game from %s", path)
+		}
+		return fn(&game)
+	})
+}
+
+// TVFeed streams the featured game of a TV channel. The stream moves on to
+// the next featured game by itself, starting with a featured event.
+func (c *Client) TVFeed(ctx context.Context, channel string, fn func(*TVFeedEvent) error) error {
+	path := "/api/tv/feed"
+	if channel != "" && channel != "best" {
+		path = "/api/tv/" + url.PathEscape(channel) + "/feed"
+	}
+	return c.streamNDJSON(ctx, path, nil, func(decoder *json.Decoder) error {
+		var event TVFeedEvent
+		if err := decoder.Decode(&event); err != nil {
+			return errors.Wrapf(err, "failed to decode event from %s", path)
+		}
+		return fn(&event)
+	})
+}
+
+// StreamGame streams the moves of a game. The first event describes the
+// whole game, as does the last one once the game is over; the others carry
+// one move and the clocks.
+func (c *Client) StreamGame(ctx context.Context, id string, fn func(*GameStreamEvent) error) error {
+	path := "/api/stream/game/" + url.PathEscape(id)
+	return c.streamNDJSON(ctx, path, nil, func(decoder *json.Decoder) error {
+		var event GameStreamEvent
+		if err := decoder.Decode(&event); err != nil {
+			return errors.Wrapf(err, "failed to decode event from %s", path)
+		}
+		return fn(&event)
+	})
+}
+
+// Explorer database names accepted by Explore.
+const (
+	ExplorerDatabaseMasters = "masters"
+	ExplorerDatabaseLichess = "lichess"
+	ExplorerDatabasePlayer  = "player"
+)
+
+// Explore looks up a position in a database of the opening explorer. The
+// client must be created for the explorer's URL. The player database
+// streams its results as they are indexed; the last, most complete one is
+// returned.
+func (c *Client) Explore(ctx context.Context, database string, q ExplorerQuery) (*Explorer, error) {
+	query := url.Values{}
+	if q.Fen != "" {
+		query.Set("fen", q.Fen)
+	}
+	if len(q.Play) > 0 {
+		query.Set("play", strings.Join(q.Play, ","))
+	}
+	if database == ExplorerDatabasePlayer {
+		query.Set("player", q.Player)
+		query.Set("color", q.Color)
+	}
+
+	path := "/" + database
+	if database != ExplorerDatabasePlayer {
+		var explorer Explorer
+		if err := c.getJSON(ctx, path, query, &explorer); err != nil {
+			return nil, err
+		}
+		return &explorer, nil
+	}
+
+	var explorer *Explorer
+	err := c.streamNDJSON(ctx, path, query, func(decoder *json.Decoder) error {
+		var update Explorer
+		if err := decoder.Decode(&update); err != nil {
+			return errors.Wrapf(err, "failed to decode explorer results from %s", path)
+		}
+		explorer = &update
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if explorer == nil {
+		return &Explorer{}, nil
+	}
+	return explorer, nil
+}
+
+// CloudEval returns the cached evaluation of a position with up to multiPV
+// lines. Positions that were not analysed yet are not found.
+func (c *Client) CloudEval(ctx context.Context, fen, variant string, multiPV int) (*CloudEval, error) {
+	query := url.Values{}
+	query.Set("fen", fen)
+	query.Set("multiPv", strconv.Itoa(multiPV))
+	if variant != "" {
+		query.Set("variant", variant)
+	}
+
+	var eval CloudEval
+	if err := c.getJSON(ctx, "/api/cloud-eval", query, &eval); err != nil {
+		return nil, err
+	}
+	return &eval, nil
+}
@@ -0,0 +1,7 @@
+package lichess
+
+type Clock struct {
+	Initial   int `json:"initial"`
+	Increment int `json:"increment"`
+	TotalTime int `json:"totalTime"`
+}
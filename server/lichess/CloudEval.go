@@ -0,0 +1,8 @@
+package lichess
+
+type CloudEval struct {
+	Fen    string        `json:"fen"`
+	Knodes int           `json:"knodes"`
+	Depth  int           `json:"depth"`
+	Pvs    []CloudEvalPV `json:"pvs"`
+}
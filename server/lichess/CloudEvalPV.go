@@ -0,0 +1,7 @@
+package lichess
+
+type CloudEvalPV struct {
+	Moves string `json:"moves"`
+	Cp    int    `json:"cp"`
+	Mate  int    `json:"mate"`
+}
@@ -0,0 +1,6 @@
+package lichess
+
+type DailyPuzzle struct {
+	Game   PuzzleGame    `json:"game"`
+	Puzzle PuzzleDetails `json:"puzzle"`
+}
@@ -0,0 +1,11 @@
+package lichess
+
+type Explorer struct {
+	White       int            `json:"white"`
+	Draws       int            `json:"draws"`
+	Black       int            `json:"black"`
+	Moves       []ExplorerMove `json:"moves"`
+	TopGames    []ExplorerGame `json:"topGames"`
+	RecentGames []ExplorerGame `json:"recentGames"`
+	Opening     *Opening       `json:"opening"`
+}
@@ -0,0 +1,10 @@
+package lichess
+
+type ExplorerGame struct {
+	Id     string         `json:"id"`
+	Winner string         `json:"winner"`
+	White  ExplorerPlayer `json:"white"`
+	Black  ExplorerPlayer `json:"black"`
+	Year   int            `json:"year"`
+	Month  string         `json:"month"`
+}
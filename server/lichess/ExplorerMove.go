@@ -0,0 +1,11 @@
+package lichess
+
+type ExplorerMove struct {
+	Uci           string        `json:"uci"`
+	San           string        `json:"san"`
+	White         int           `json:"white"`
+	Draws         int           `json:"draws"`
+	Black         int           `json:"black"`
+	AverageRating int           `json:"averageRating"`
+	Game          *ExplorerGame `json:"game"`
+}
@@ -0,0 +1,6 @@
+package lichess
+
+type ExplorerPlayer struct {
+	Name   string `json:"name"`
+	Rating int    `json:"rating"`
+}
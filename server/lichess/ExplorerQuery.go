@@ -0,0 +1,12 @@
+package lichess
+
+// ExplorerQuery selects a position of the opening explorer. Play lists the
+// UCI moves leading from Fen, or from the starting position, to the
+// position, which lets the explorer name the opening. Player and Color are
+// used by the player database only.
+type ExplorerQuery struct {
+	Fen    string
+	Play   []string
+	Player string
+	Color  string
+}
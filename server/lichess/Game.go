@@ -0,0 +1,26 @@
+package lichess
+
+type Game struct {
+	Id          string      `json:"id"`
+	Rated       bool        `json:"rated"`
+	Variant     string      `json:"variant"`
+	Speed       string      `json:"speed"`
+	Perf        string      `json:"perf"`
+	CreatedAt   int64       `json:"createdAt"`
+	LastMoveAt  int64       `json:"lastMoveAt"`
+	Status      string      `json:"status"`
+	Players     GamePlayers `json:"players"`
+	Winner      string      `json:"winner"`
+	Opening     Opening     `json:"opening"`
+	Moves       string      `json:"moves"`
+	Pgn         string      `json:"pgn"`
+	InitialFen  string      `json:"initialFen"`
+	LastFen     string      `json:"lastFen"`
+	Clock       Clock       `json:"clock"`
+	DaysPerTurn int         `json:"daysPerTurn"`
+	Tournament  string      `json:"tournament"`
+	Swiss       string      `json:"swiss"`
+	// Analysis holds the evaluation after each move when the game was
+	// analysed on Lichess.
+	Analysis []GameAnalysis `json:"analysis"`
+}
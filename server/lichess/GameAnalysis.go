@@ -0,0 +1,9 @@
+package lichess
+
+type GameAnalysis struct {
+	Eval      int           `json:"eval"`
+	Mate      int           `json:"mate"`
+	Best      string        `json:"best"`
+	Variation string        `json:"variation"`
+	Judgment  *GameJudgment `json:"judgment"`
+}
@@ -0,0 +1,6 @@
+package lichess
+
+type GameJudgment struct {
+	Name    string `json:"name"`
+	Comment string `json:"comment"`
+}
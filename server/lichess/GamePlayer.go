@@ -0,0 +1,10 @@
+package lichess
+
+type GamePlayer struct {
+	User        LightUser `json:"user"`
+	Rating      int       `json:"rating"`
+	RatingDiff  int       `json:"ratingDiff"`
+	Provisional bool      `json:"provisional"`
+	AiLevel     int       `json:"aiLevel"`
+	Name        string    `json:"name"`
+}
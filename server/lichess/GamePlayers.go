@@ -0,0 +1,6 @@
+package lichess
+
+type GamePlayers struct {
+	White GamePlayer `json:"white"`
+	Black GamePlayer `json:"black"`
+}
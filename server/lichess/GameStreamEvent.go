@@ -0,0 +1,16 @@
+package lichess
+
+type GameStreamEvent struct {
+	Id       string            `json:"id"`
+	Variant  GameStreamVariant `json:"variant"`
+	Speed    string            `json:"speed"`
+	Rated    bool              `json:"rated"`
+	Status   GameStreamStatus  `json:"status"`
+	Players  GamePlayers       `json:"players"`
+	Winner   string            `json:"winner"`
+	Fen      string            `json:"fen"`
+	LastMove string            `json:"lastMove"`
+	Lm       string            `json:"lm"`
+	Wc       int               `json:"wc"`
+	Bc       int               `json:"bc"`
+}
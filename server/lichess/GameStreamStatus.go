@@ -0,0 +1,6 @@
+package lichess
+
+type GameStreamStatus struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
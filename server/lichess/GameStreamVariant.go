@@ -0,0 +1,6 @@
+package lichess
+
+type GameStreamVariant struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,16 @@
+package lichess
+
+import "time"
+
+// GamesFilter narrows down the games exported by UserGames. Zero values
+// don't filter.
+type GamesFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Max      int
+	PerfType string
+	Vs       string
+	Rated    *bool
+	// Evals includes the analysis of games that were analysed.
+	Evals bool
+}
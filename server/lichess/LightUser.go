@@ -0,0 +1,8 @@
+package lichess
+
+type LightUser struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Title  string `json:"title"`
+	Patron bool   `json:"patron"`
+}
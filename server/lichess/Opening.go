@@ -0,0 +1,7 @@
+package lichess
+
+type Opening struct {
+	Eco  string `json:"eco"`
+	Name string `json:"name"`
+	Ply  int    `json:"ply"`
+}
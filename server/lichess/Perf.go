@@ -0,0 +1,94 @@
+package lichess
+
+import "strings"
+
+// Perf is the rating of a user in one perf. Every rated perf type converts
+// to it.
+type Perf struct {
+	Games  int  `json:"games"`
+	Rating int  `json:"rating"`
+	Rd     int  `json:"rd"`
+	Prog   int  `json:"prog"`
+	Prov   bool `json:"prov"`
+}
+
+// PerfKeys lists the rated perfs in the order Lichess shows them.
+var PerfKeys = []string{
+	"ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence",
+	"chess960", "kingOfTheHill", "threeCheck", "antichess", "atomic", "horde",
+	"racingKings", "crazyhouse", "puzzle",
+}
+
+var perfNames = map[string]string{
+	"ultraBullet":    "UltraBullet",
+	"bullet":         "Bullet",
+	"blitz":          "Blitz",
+	"rapid":          "Rapid",
+	"classical":      "Classical",
+	"correspondence": "Correspondence",
+	"chess960":       "Chess960",
+	"kingOfTheHill":  "King of the Hill",
+	"threeCheck":     "Three-check",
+	"antichess":      "Antichess",
+	"atomic":         "Atomic",
+	"horde":          "Horde",
+	"racingKings":    "Racing Kings",
+	"crazyhouse":     "Crazyhouse",
+	"puzzle":         "Puzzles",
+}
+
+// PerfName returns the display name of a perf key.
+func PerfName(key string) string {
+	if name, ok := perfNames[key]; ok {
+		return name
+	}
+	return key
+}
+
+// ParsePerfKey finds the perf key matching s case insensitively.
+func ParsePerfKey(s string) (string, bool) {
+	for _, key := range PerfKeys {
+		if strings.EqualFold(key, s) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the perf with the given key.
+func (p Perfs) Get(key string) (Perf, bool) {
+	switch key {
+	case "ultraBullet":
+		return Perf(p.UltraBullet), true
+	case "bullet":
+		return Perf(p.Bullet), true
+	case "blitz":
+		return Perf(p.Blitz), true
+	case "rapid":
+		return Perf(p.Rapid), true
+	case "classical":
+		return Perf(p.Classical), true
+	case "correspondence":
+		return Perf(p.Correspondence), true
+	case "chess960":
+		return Perf(p.Chess960), true
+	case "kingOfTheHill":
+		return Perf(p.KingOfTheHill), true
+	case "threeCheck":
+		return Perf(p.ThreeCheck), true
+	case "antichess":
+		return Perf(p.Antichess), true
+	case "atomic":
+		return Perf(p.Atomic), true
+	case "horde":
+		return Perf(p.Horde), true
+	case "racingKings":
+		return Perf(p.RacingKings), true
+	case "crazyhouse":
+		return Perf(p.Crazyhouse), true
+	case "puzzle":
+		return Perf(p.Puzzle), true
+	default:
+		return Perf{}, false
+	}
+}
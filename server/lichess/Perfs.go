@@ -14,4 +14,7 @@ type Perfs struct {
 	Classical      Classical      `json:"classical"`
 	Rapid          Rapid          `json:"rapid"`
 	Storm          Storm          `json:"storm"`
+	ThreeCheck     ThreeCheck     `json:"threeCheck"`
+	Antichess      Antichess      `json:"antichess"`
+	Crazyhouse     Crazyhouse     `json:"crazyhouse"`
 }
@@ -0,0 +1,10 @@
+package lichess
+
+type PuzzleDetails struct {
+	Id         string   `json:"id"`
+	Rating     int      `json:"rating"`
+	Plays      int      `json:"plays"`
+	InitialPly int      `json:"initialPly"`
+	Solution   []string `json:"solution"`
+	Themes     []string `json:"themes"`
+}
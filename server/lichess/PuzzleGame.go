@@ -0,0 +1,10 @@
+package lichess
+
+type PuzzleGame struct {
+	Id      string         `json:"id"`
+	Perf    PuzzlePerf     `json:"perf"`
+	Rated   bool           `json:"rated"`
+	Players []PuzzlePlayer `json:"players"`
+	Pgn     string         `json:"pgn"`
+	Clock   string         `json:"clock"`
+}
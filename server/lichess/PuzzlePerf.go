@@ -0,0 +1,6 @@
+package lichess
+
+type PuzzlePerf struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,9 @@
+package lichess
+
+type PuzzlePlayer struct {
+	UserId string `json:"userId"`
+	Name   string `json:"name"`
+	Color  string `json:"color"`
+	Rating int    `json:"rating"`
+	Title  string `json:"title"`
+}
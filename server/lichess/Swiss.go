@@ -0,0 +1,17 @@
+package lichess
+
+type Swiss struct {
+	Id        string         `json:"id"`
+	CreatedBy string         `json:"createdBy"`
+	Name      string         `json:"name"`
+	StartsAt  string         `json:"startsAt"`
+	Clock     SwissClock     `json:"clock"`
+	Variant   string         `json:"variant"`
+	Rated     bool           `json:"rated"`
+	Round     int            `json:"round"`
+	NbRounds  int            `json:"nbRounds"`
+	NbPlayers int            `json:"nbPlayers"`
+	NbOngoing int            `json:"nbOngoing"`
+	Status    string         `json:"status"`
+	NextRound SwissNextRound `json:"nextRound"`
+}
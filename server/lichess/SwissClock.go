@@ -0,0 +1,6 @@
+package lichess
+
+type SwissClock struct {
+	Limit     int `json:"limit"`
+	Increment int `json:"increment"`
+}
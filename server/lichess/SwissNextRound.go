@@ -0,0 +1,6 @@
+package lichess
+
+type SwissNextRound struct {
+	At string `json:"at"`
+	In int    `json:"in"`
+}
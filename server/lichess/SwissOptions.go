@@ -0,0 +1,14 @@
+package lichess
+
+// SwissOptions describes a Swiss tournament to create for a team. ClockLimit
+// and ClockIncrement are in seconds. A zero RoundInterval lets Lichess start
+// rounds as soon as the previous one ended.
+type SwissOptions struct {
+	Name           string
+	ClockLimit     int
+	ClockIncrement int
+	NbRounds       int
+	RoundInterval  int
+	Variant        string
+	Rated          bool
+}
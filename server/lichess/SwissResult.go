@@ -0,0 +1,12 @@
+package lichess
+
+type SwissResult struct {
+	Rank        int     `json:"rank"`
+	Points      float64 `json:"points"`
+	TieBreak    float64 `json:"tieBreak"`
+	Rating      int     `json:"rating"`
+	Username    string  `json:"username"`
+	Title       string  `json:"title"`
+	Performance int     `json:"performance"`
+	Absent      bool    `json:"absent"`
+}
@@ -0,0 +1,21 @@
+package lichess
+
+import "strings"
+
+// TVChannels lists the Lichess TV channels. The best channel shows the
+// highest rated game being played and is the default feed.
+var TVChannels = []string{
+	"best", "bullet", "blitz", "rapid", "classical", "ultraBullet",
+	"chess960", "kingOfTheHill", "threeCheck", "antichess", "atomic",
+	"horde", "racingKings", "crazyhouse", "computer", "bot",
+}
+
+// ParseTVChannel finds the TV channel matching s case insensitively.
+func ParseTVChannel(s string) (string, bool) {
+	for _, channel := range TVChannels {
+		if strings.EqualFold(channel, s) {
+			return channel, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,11 @@
+package lichess
+
+type TVFeedData struct {
+	Id          string         `json:"id"`
+	Orientation string         `json:"orientation"`
+	Players     []TVFeedPlayer `json:"players"`
+	Fen         string         `json:"fen"`
+	Lm          string         `json:"lm"`
+	Wc          int            `json:"wc"`
+	Bc          int            `json:"bc"`
+}
@@ -0,0 +1,8 @@
+package lichess
+
+// TVFeedEvent is a message of the TV feed. T is "featured" when a new game
+// is shown and "fen" after every move.
+type TVFeedEvent struct {
+	T string     `json:"t"`
+	D TVFeedData `json:"d"`
+}
@@ -0,0 +1,8 @@
+package lichess
+
+type TVFeedPlayer struct {
+	Color   string    `json:"color"`
+	User    LightUser `json:"user"`
+	Rating  int       `json:"rating"`
+	Seconds int       `json:"seconds"`
+}
@@ -0,0 +1,9 @@
+package lichess
+
+type TeamMember struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	Username     string `json:"username"`
+	Title        string `json:"title"`
+	JoinedTeamAt int64  `json:"joinedTeamAt"`
+}
@@ -0,0 +1,9 @@
+package lichess
+
+type ThreeCheck struct {
+	Games  int  `json:"games"`
+	Rating int  `json:"rating"`
+	Rd     int  `json:"rd"`
+	Prog   int  `json:"prog"`
+	Prov   bool `json:"prov"`
+}
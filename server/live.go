@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	liveUpdateInterval = 3 * time.Second
+	liveReloadInterval = 30 * time.Second
+	liveRetryInterval  = 10 * time.Second
+)
+
+// LivePost is a post whose message follows a Lichess stream, such as a TV
+// channel or a game, until ExpiresAt. Key identifies the stream.
+type LivePost struct {
+	PostID    string
+	ChannelID string
+	Key       string
+	ExpiresAt int64
+}
+
+type LivePostsUpdatedEvent struct {
+	Runner string
+}
+
+// liveRunner keeps live posts up to date with one upstream stream per key,
+// shared by every post following it. Every node starts a runner, but only
+// the node holding its lock streams; the others wait for the lock in case
+// that node goes away.
+type liveRunner struct {
+	plugin *Plugin
+	name   string
+	// endedNote is appended to posts that expired or were stopped.
+	endedNote string
+	// stream follows a key and calls publish with the new message whenever
+	// it changes. It returns true once the stream has ended for good, and
+	// false when ctx is done.
+	stream func(ctx context.Context, key string, publish func(message string)) bool
+	// ended, if set, is called for every post that stops being followed
+	// before its stream ended, once it is marked as ended.
+	ended func(post LivePost)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	reload chan struct{}
+
+	lock  sync.Mutex
+	posts map[string][]LivePost
+}
+
+func newLiveRunner(p *Plugin, name, endedNote string, stream func(ctx context.Context, key string, publish func(message string)) bool) *liveRunner {
+	return &liveRunner{
+		plugin:    p,
+		name:      name,
+		endedNote: endedNote,
+		stream:    stream,
+		done:      make(chan struct{}),
+		reload:    make(chan struct{}, 1),
+		posts:     map[string][]LivePost{},
+	}
+}
+
+func (r *liveRunner) postsKey() string {
+	return r.name + "posts"
+}
+
+func (r *liveRunner) start() error {
+	mutex, err := cluster.NewMutex(r.plugin.API, r.name+"_leader")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s mutex", r.name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go func() {
+		defer close(r.done)
+		if err := mutex.LockWithContext(ctx); err != nil {
+			return
+		}
+		defer mutex.Unlock()
+		r.run(ctx)
+	}()
+	return nil
+}
+
+// stop ends the streams and waits for them to finish.
+func (r *liveRunner) stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// wake makes the runner reload its posts now.
+func (r *liveRunner) wake() {
+	select {
+	case r.reload <- struct{}{}:
+	default:
+	}
+}
+
+func (r *liveRunner) postsFor(key string) []LivePost {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.posts[key]
+}
+
+func (r *liveRunner) run(ctx context.Context) {
+	feeds := map[string]context.CancelFunc{}
+	var wg sync.WaitGroup
+	defer func() {
+		for _, cancel := range feeds {
+			cancel()
+		}
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(liveReloadInterval)
+	defer ticker.Stop()
+
+	finished := make(chan string)
+	for {
+		r.reloadPosts()
+
+		r.lock.Lock()
+		for key := range r.posts {
+			if _, ok := feeds[key]; ok {
+				continue
+			}
+			feedCtx, cancel := context.WithCancel(ctx)
+			feeds[key] = cancel
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				if r.runFeed(feedCtx, key) {
+					select {
+					case finished <- key:
+					case <-ctx.Done():
+					}
+				}
+			}(key)
+		}
+		for key, cancel := range feeds {
+			if _, ok := r.posts[key]; !ok {
+				cancel()
+				delete(feeds, key)
+			}
+		}
+		r.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.reload:
+		case key := <-finished:
+			// The posts may have stopped following the key meanwhile.
+			if cancel, ok := feeds[key]; ok {
+				cancel()
+				delete(feeds, key)
+			}
+			r.finish(key)
+		}
+	}
+}
+
+// reloadPosts reads the posts, removing expired ones, and marks the posts
+// that are no longer followed as ended.
+func (r *liveRunner) reloadPosts() {
+	p := r.plugin
+	var posts []LivePost
+	if err := p.pluginAPI.KV.Get(r.postsKey(), &posts); err != nil {
+		p.API.LogWarn("failed to load live posts", "runner", r.name, "error", err.Error())
+		return
+	}
+
+	var ended, current []LivePost
+	now := model.GetMillis()
+	for _, post := range posts {
+		if post.ExpiresAt < now {
+			ended = append(ended, post)
+		} else {
+			current = append(current, post)
+		}
+	}
+	if len(ended) > 0 {
+		err := r.updatePosts(func(posts []LivePost) []LivePost {
+			kept := []LivePost{}
+			for _, post := range posts {
+				if post.ExpiresAt >= now {
+					kept = append(kept, post)
+				}
+			}
+			return kept
+		})
+		if err != nil {
+			p.API.LogWarn("failed to remove expired live posts", "runner", r.name, "error", err.Error())
+		}
+	}
+
+	followed := map[string]bool{}
+	byKey := map[string][]LivePost{}
+	for _, post := range current {
+		followed[post.PostID] = true
+		byKey[post.Key] = append(byKey[post.Key], post)
+	}
+
+	r.lock.Lock()
+	for _, keyPosts := range r.posts {
+		for _, post := range keyPosts {
+			if !followed[post.PostID] {
+				ended = append(ended, post)
+			}
+		}
+	}
+	r.posts = byKey
+	r.lock.Unlock()
+
+	for _, post := range ended {
+		r.endPost(post)
+		if r.ended != nil {
+			r.ended(post)
+		}
+	}
+}
+
+func (r *liveRunner) endPost(livePost LivePost) {
+	p := r.plugin
+	post, err := p.pluginAPI.Post.GetPost(livePost.PostID)
+	if err != nil {
+		return
+	}
+	if strings.HasSuffix(post.Message, r.endedNote) {
+		return
+	}
+	post.Message += r.endedNote
+	if err := p.pluginAPI.Post.UpdatePost(post); err != nil {
+		p.API.LogWarn("failed to end live post", "postID", livePost.PostID, "error", err.Error())
+	}
+}
+
+// runFeed streams one key and updates its posts every few seconds while the
+// message changes. It reports whether the stream ended for good.
+func (r *liveRunner) runFeed(ctx context.Context, key string) bool {
+	var lock sync.Mutex
+	message, changed := "", false
+
+	streamDone := make(chan bool, 1)
+	go func() {
+		streamDone <- r.stream(ctx, key, func(m string) {
+			lock.Lock()
+			defer lock.Unlock()
+			if m != message {
+				message, changed = m, true
+			}
+		})
+	}()
+
+	ticker := time.NewTicker(liveUpdateInterval)
+	defer ticker.Stop()
+	for {
+		ended := false
+		select {
+		case <-ctx.Done():
+			<-streamDone
+			return false
+		case ended = <-streamDone:
+		case <-ticker.C:
+		}
+
+		lock.Lock()
+		m, update := message, changed
+		changed = false
+		lock.Unlock()
+
+		if update {
+			r.updateMessages(key, m)
+		}
+		if ended {
+			return true
+		}
+	}
+}
+
+func (r *liveRunner) updateMessages(key, message string) {
+	p := r.plugin
+	for _, livePost := range r.postsFor(key) {
+		post, err := p.pluginAPI.Post.GetPost(livePost.PostID)
+		if err != nil {
+			continue
+		}
+		post.Message = message
+		if err := p.pluginAPI.Post.UpdatePost(post); err != nil {
+			p.API.LogWarn("failed to update live post", "postID", livePost.PostID, "error", err.Error())
+		}
+	}
+}
+
+// finish stops following the posts of a stream that ended for good. Their
+// last message stays as it is.
+func (r *liveRunner) finish(key string) {
+	err := r.updatePosts(func(posts []LivePost) []LivePost {
+		kept := []LivePost{}
+		for _, post := range posts {
+			if post.Key != key {
+				kept = append(kept, post)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		r.plugin.API.LogWarn("failed to remove finished live posts", "runner", r.name, "error", err.Error())
+		return
+	}
+
+	r.lock.Lock()
+	delete(r.posts, key)
+	r.lock.Unlock()
+}
+
+// add follows a new post and tells the node running the streams.
+func (r *liveRunner) add(post LivePost) error {
+	err := r.updatePosts(func(posts []LivePost) []LivePost {
+		return append(posts, post)
+	})
+	if err != nil {
+		return err
+	}
+	r.notify()
+	return nil
+}
+
+// remove stops following the posts matching f and returns how many there
+// were.
+func (r *liveRunner) remove(f func(post LivePost) bool) (int, error) {
+	removed := 0
+	err := r.updatePosts(func(posts []LivePost) []LivePost {
+		removed = 0
+		kept := []LivePost{}
+		for _, post := range posts {
+			if f(post) {
+				removed++
+				continue
+			}
+			kept = append(kept, post)
+		}
+		return kept
+	})
+	if err != nil {
+		return 0, err
+	}
+	if removed > 0 {
+		r.notify()
+	}
+	return removed, nil
+}
+
+func (r *liveRunner) updatePosts(f func(posts []LivePost) []LivePost) error {
+	err := r.plugin.pluginAPI.KV.SetAtomicWithRetries(r.postsKey(), func(old []byte) (interface{}, error) {
+		var posts []LivePost
+		if old != nil {
+			if err := json.Unmarshal(old, &posts); err != nil {
+				return nil, err
+			}
+		}
+		return f(posts), nil
+	})
+	return errors.Wrapf(err, "failed to store %s posts", r.name)
+}
+
+// notify wakes the runner on this node and on the others, since any of them
+// may be the one streaming.
+func (r *liveRunner) notify() {
+	r.wake()
+	r.plugin.sendLivePostsUpdatedEvent(LivePostsUpdatedEvent{Runner: r.name})
+}
+
+// sleepOrDone waits for d and reports whether ctx is still active.
+func sleepOrDone(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
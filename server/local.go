@@ -0,0 +1,554 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/eco"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	localGameKey     = "localgame_"
+	localGamePostKey = "localgamepost_"
+	localRecordKey   = "localrecord_"
+)
+
+var moveLikeRegexp = regexp.MustCompile(`^([KQRBN]?[a-h]?[1-8]?x?[a-h][1-8](=?[QRBNKqrbnk])?|[O0]-[O0](-[O0])?|[a-h][1-8][a-h][1-8][qrbnk]?|[PNBRQ]?@[a-h][1-8])[+#]?[!?]*$`)
+
+// LocalGame is a game played entirely inside Mattermost, in the thread of
+// the post the bot created when the game started.
+type LocalGame struct {
+	ID            string
+	ChannelID     string
+	RootPostID    string
+	WhiteUserID   string
+	BlackUserID   string
+	Variant       string
+	StartFEN      string
+	Moves         []string
+	Result        string
+	Termination   string
+	DrawOfferedBy string
+	CreatedAt     int64
+	UpdatedAt     int64
+}
+
+type LocalRecord struct {
+	Wins   int
+	Losses int
+	Draws  int
+}
+
+type LocalGameUpdatedEvent struct {
+	GameID     string
+	RootPostID string
+}
+
+// localGameCache maps root post IDs to games on this node. Threads that are
+// not games are not cached, so the cache only grows with the games played.
+type localGameCache struct {
+	lock  sync.RWMutex
+	games map[string]*LocalGame
+}
+
+func newLocalGameCache() *localGameCache {
+	return &localGameCache{games: make(map[string]*LocalGame)}
+}
+
+func (c *localGameCache) get(rootPostID string) (*LocalGame, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	game, ok := c.games[rootPostID]
+	return game, ok
+}
+
+func (c *localGameCache) set(rootPostID string, game *LocalGame) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.games[rootPostID] = game
+}
+
+func (c *localGameCache) invalidate(rootPostID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.games, rootPostID)
+}
+
+// replay rebuilds the game from its starting position and move list.
+func (lg *LocalGame) replay() (*chess.Game, error) {
+	variant, err := chess.ParseVariant(lg.Variant)
+	if err != nil {
+		return nil, err
+	}
+	start, err := chess.ParseVariantFEN(variant, lg.StartFEN)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid starting position")
+	}
+	game := chess.NewGame(start)
+	for _, m := range lg.Moves {
+		if _, err := game.PlayMove(m); err != nil {
+			return nil, errors.Wrapf(err, "failed to replay move %s", m)
+		}
+	}
+	if lg.Result != "" && !game.IsOver() {
+		switch chess.Termination(lg.Termination) {
+		case chess.Resignation:
+			loser := chess.White
+			if chess.Result(lg.Result) == chess.WhiteWins {
+				loser = chess.Black
+			}
+			game.Resign(loser)
+		case chess.DrawAgreement:
+			game.AgreeDraw()
+		}
+	}
+	return game, nil
+}
+
+func (lg *LocalGame) colorOf(userID string) (chess.Color, bool) {
+	switch userID {
+	case lg.WhiteUserID:
+		return chess.White, true
+	case lg.BlackUserID:
+		return chess.Black, true
+	default:
+		return chess.White, false
+	}
+}
+
+func (lg *LocalGame) userOf(c chess.Color) string {
+	if c == chess.White {
+		return lg.WhiteUserID
+	}
+	return lg.BlackUserID
+}
+
+func (p *Plugin) executeLocalCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 {
+		return ephemeralResponse("Please specify who to play against: `/lichess local @user`.")
+	}
+	if params[0] == "record" {
+		return p.executeLocalRecordCommand(args, params[1:])
+	}
+
+	opponent, err := p.userFromMention(params[0])
+	if err != nil {
+		return ephemeralResponse(err.Error())
+	}
+	if opponent.Id == args.UserId {
+		return ephemeralResponse("You cannot play against yourself.")
+	}
+	if opponent.IsBot {
+		return ephemeralResponse("You cannot play against a bot.")
+	}
+
+	side, variant := "random", chess.Standard
+	for _, param := range params[1:] {
+		switch param {
+		case "white", "black", "random":
+			side = param
+		default:
+			v, err := chess.ParseVariant(param)
+			if err != nil {
+				return ephemeralResponsef("Unknown option %q, use white, black or random and one of the variants %s.", param, variantList())
+			}
+			variant = v
+		}
+	}
+
+	white, black := args.UserId, opponent.Id
+	if side == "black" || (side == "random" && chess.RandomIntn(2) == 1) {
+		white, black = black, white
+	}
+
+	if _, err := p.startLocalGame(args.ChannelId, white, black, variant); err != nil {
+		p.API.LogWarn("failed to start local game", "error", err.Error())
+		return ephemeralResponse("Failed to start the game.")
+	}
+	return &model.CommandResponse{}
+}
+
+func (p *Plugin) executeLocalRecordCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	userID := args.UserId
+	username := "You"
+	if len(params) > 0 {
+		user, err := p.userFromMention(params[0])
+		if err != nil {
+			return ephemeralResponse(err.Error())
+		}
+		userID = user.Id
+		username = "@" + user.Username
+	}
+
+	record, err := p.getLocalRecord(userID)
+	if err != nil {
+		p.API.LogWarn("failed to get local record", "error", err.Error())
+		return ephemeralResponse("Failed to load the record.")
+	}
+	return ephemeralResponsef("%s: %d wins, %d losses, %d draws in local games.", username, record.Wins, record.Losses, record.Draws)
+}
+
+func variantList() string {
+	names := make([]string, 0, len(chess.Variants()))
+	for _, v := range chess.Variants() {
+		names = append(names, "`"+string(v)+"`")
+	}
+	return strings.Join(names, ", ")
+}
+
+func (p *Plugin) startLocalGame(channelID, whiteUserID, blackUserID string, variant chess.Variant) (*LocalGame, error) {
+	now := model.GetMillis()
+	lg := &LocalGame{
+		ID:          model.NewId(),
+		ChannelID:   channelID,
+		WhiteUserID: whiteUserID,
+		BlackUserID: blackUserID,
+		Variant:     string(variant),
+		StartFEN:    variant.StartingPosition().FEN(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	game, err := lg.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   p.localGameMessage(lg, game),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		return nil, errors.Wrap(err, "failed to create game post")
+	}
+	lg.RootPostID = post.Id
+
+	if err := p.saveLocalGame(lg, nil); err != nil {
+		return nil, err
+	}
+	if appErr := p.API.KVSet(localGamePostKey+post.Id, []byte(lg.ID)); appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to store game post")
+	}
+
+	return lg, nil
+}
+
+func (p *Plugin) localGameMessage(lg *LocalGame, game *chess.Game) string {
+	var b strings.Builder
+	pos := game.Position()
+	title := "Local game"
+	if pos.Variant != chess.Standard {
+		title = "Local " + pos.Variant.Name() + " game"
+	}
+	fmt.Fprintf(&b, "#### %s: %s (white) vs %s (black)\n", title, p.mention(lg.WhiteUserID), p.mention(lg.BlackUserID))
+
+	if game.IsOver() {
+		fmt.Fprintf(&b, "**%s** by %s.\n", game.Result(), game.Termination())
+	} else {
+		fmt.Fprintf(&b, "%s to move. Reply in this thread with moves in SAN or UCI (`e4`, `Nf3`, `e7e5`). Type `resign` to resign or `draw` to offer or accept a draw.\n", p.mention(lg.userOf(game.Position().Turn)))
+	}
+
+	if pgn := movesText(game); pgn != "" {
+		b.WriteString("\n" + pgn + "\n")
+		if opening, ok := eco.Classify(game); ok {
+			b.WriteString("*" + opening.String() + "*\n")
+		}
+	}
+
+	if status := variantStatus(pos); status != "" {
+		b.WriteString("\n" + status + "\n")
+	}
+
+	b.WriteString("\n" + p.boardMarkdown(pos.FEN(), lastMoveUCI(lg), false))
+	return b.String()
+}
+
+// variantStatus describes the parts of a variant position the board image
+// does not show.
+func variantStatus(pos *chess.Position) string {
+	switch pos.Variant {
+	case chess.Crazyhouse:
+		pockets := pos.PocketString()
+		if pockets == "" {
+			pockets = "empty"
+		}
+		return "Pockets: `" + pockets + "`"
+	case chess.ThreeCheck:
+		return fmt.Sprintf("Checks given: white %d, black %d", pos.ChecksGiven[chess.White], pos.ChecksGiven[chess.Black])
+	default:
+		return ""
+	}
+}
+
+func (p *Plugin) mention(userID string) string {
+	user, err := p.pluginAPI.User.Get(userID)
+	if err != nil {
+		return "someone"
+	}
+	return "@" + user.Username
+}
+
+func lastMoveUCI(lg *LocalGame) string {
+	if len(lg.Moves) == 0 {
+		return ""
+	}
+	return lg.Moves[len(lg.Moves)-1]
+}
+
+// movesText formats the moves as numbered SAN, e.g. "1. e4 e5 2. Nf3".
+func movesText(game *chess.Game) string {
+	start := game.StartingPosition()
+	number, turn := start.FullmoveNumber, start.Turn
+
+	var parts []string
+	for i, san := range game.SANMoves() {
+		if turn == chess.White {
+			parts = append(parts, fmt.Sprintf("%d.", number))
+		} else if i == 0 {
+			parts = append(parts, fmt.Sprintf("%d...", number))
+		}
+		parts = append(parts, san)
+		if turn == chess.Black {
+			number++
+		}
+		turn = turn.Other()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *Plugin) getLocalGameByPost(rootPostID string) (*LocalGame, error) {
+	if lg, ok := p.localGames.get(rootPostID); ok {
+		return lg, nil
+	}
+
+	id, appErr := p.API.KVGet(localGamePostKey + rootPostID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get game post from kv store")
+	}
+	if id == nil {
+		return nil, nil
+	}
+
+	lg, _, err := p.getLocalGame(string(id))
+	if err != nil {
+		return nil, err
+	}
+	p.localGames.set(rootPostID, lg)
+	return lg, nil
+}
+
+// getLocalGame also returns the stored bytes, to be passed back to
+// saveLocalGame for a compare and set.
+func (p *Plugin) getLocalGame(id string) (*LocalGame, []byte, error) {
+	data, appErr := p.API.KVGet(localGameKey + id)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to get local game from kv store")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+
+	var lg LocalGame
+	if err := json.Unmarshal(data, &lg); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to unmarshal local game")
+	}
+	return &lg, data, nil
+}
+
+var errLocalGameChanged = errors.New("the game was changed concurrently")
+
+func (p *Plugin) saveLocalGame(lg *LocalGame, old []byte) error {
+	data, err := json.Marshal(lg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal local game")
+	}
+
+	ok, appErr := p.API.KVCompareAndSet(localGameKey+lg.ID, old, data)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to store local game in kv store")
+	}
+	if !ok {
+		return errLocalGameChanged
+	}
+
+	p.localGames.set(lg.RootPostID, lg)
+	p.sendLocalGameUpdatedEvent(LocalGameUpdatedEvent{GameID: lg.ID, RootPostID: lg.RootPostID})
+	return nil
+}
+
+func (p *Plugin) getLocalRecord(userID string) (*LocalRecord, error) {
+	var record LocalRecord
+	if err := p.pluginAPI.KV.Get(localRecordKey+userID, &record); err != nil {
+		return nil, errors.Wrap(err, "failed to get local record from kv store")
+	}
+	return &record, nil
+}
+
+func (p *Plugin) recordLocalResult(lg *LocalGame, result chess.Result) {
+	update := func(userID string, apply func(r *LocalRecord)) {
+		err := p.pluginAPI.KV.SetAtomicWithRetries(localRecordKey+userID, func(old []byte) (interface{}, error) {
+			var record LocalRecord
+			if old != nil {
+				if err := json.Unmarshal(old, &record); err != nil {
+					return nil, err
+				}
+			}
+			apply(&record)
+			return record, nil
+		})
+		if err != nil {
+			p.API.LogWarn("failed to record local game result", "userID", userID, "error", err.Error())
+		}
+	}
+
+	winner, decisive := result.Winner()
+	for _, c := range []chess.Color{chess.White, chess.Black} {
+		switch {
+		case !decisive:
+			update(lg.userOf(c), func(r *LocalRecord) { r.Draws++ })
+		case c == winner:
+			update(lg.userOf(c), func(r *LocalRecord) { r.Wins++ })
+		default:
+			update(lg.userOf(c), func(r *LocalRecord) { r.Losses++ })
+		}
+	}
+}
+
+// handleLocalGamePost treats replies from the players in a game thread as
+// moves or game actions. Anything that does not look like either is left
+// alone so the thread can still be used for chat.
+func (p *Plugin) handleLocalGamePost(post *model.Post) {
+	text := strings.TrimSpace(post.Message)
+	action := strings.ToLower(text)
+	if action != "resign" && action != "draw" && !moveLikeRegexp.MatchString(text) {
+		return
+	}
+
+	lg, err := p.getLocalGameByPost(post.RootId)
+	if err != nil {
+		p.API.LogWarn("failed to get local game", "rootID", post.RootId, "error", err.Error())
+		return
+	}
+	if lg == nil || lg.Result != "" {
+		return
+	}
+
+	color, ok := lg.colorOf(post.UserId)
+	if !ok {
+		return
+	}
+
+	// Reload from the KV store so a stale cache entry cannot lose moves.
+	lg, old, err := p.getLocalGame(lg.ID)
+	if err != nil || lg == nil {
+		p.API.LogWarn("failed to load local game", "rootID", post.RootId, "error", fmt.Sprint(err))
+		return
+	}
+	game, err := lg.replay()
+	if err != nil {
+		p.API.LogWarn("failed to replay local game", "gameID", lg.ID, "error", err.Error())
+		return
+	}
+	if game.IsOver() {
+		return
+	}
+
+	var reply string
+	switch action {
+	case "resign":
+		game.Resign(color)
+		reply = fmt.Sprintf("%s resigned.", p.mention(post.UserId))
+	case "draw":
+		opponent := lg.userOf(color.Other())
+		if lg.DrawOfferedBy == opponent {
+			game.AgreeDraw()
+			reply = "Draw agreed."
+		} else {
+			lg.DrawOfferedBy = post.UserId
+			reply = fmt.Sprintf("%s offers a draw. %s, reply `draw` to accept.", p.mention(post.UserId), p.mention(opponent))
+		}
+	default:
+		if game.Position().Turn != color {
+			p.sendEphemeral(post, "It is not your turn.")
+			return
+		}
+		number, turn := game.Position().FullmoveNumber, game.Position().Turn
+		san, err := game.PlayMove(text)
+		if err != nil {
+			p.sendEphemeral(post, fmt.Sprintf("Illegal move `%s`.", text))
+			return
+		}
+		lg.Moves = append(lg.Moves, game.UCIMoves()[len(game.Moves())-1])
+		lg.DrawOfferedBy = ""
+		if turn == chess.White {
+			reply = fmt.Sprintf("**%d. %s**", number, san)
+		} else {
+			reply = fmt.Sprintf("**%d... %s**", number, san)
+		}
+	}
+
+	if game.IsOver() {
+		lg.Result = string(game.Result())
+		lg.Termination = string(game.Termination())
+		reply += fmt.Sprintf("\n\nGame over: **%s** by %s.", lg.Result, lg.Termination)
+	} else if action != "draw" {
+		reply += fmt.Sprintf(" %s to move.", p.mention(lg.userOf(game.Position().Turn)))
+	}
+	lg.UpdatedAt = model.GetMillis()
+
+	if err := p.saveLocalGame(lg, old); err != nil {
+		if err == errLocalGameChanged {
+			p.sendEphemeral(post, "The game changed while your move was processed, please try again.")
+			return
+		}
+		p.API.LogWarn("failed to save local game", "gameID", lg.ID, "error", err.Error())
+		return
+	}
+
+	if game.IsOver() {
+		p.recordLocalResult(lg, game.Result())
+	}
+
+	p.updateLocalGamePosts(lg, game, reply)
+}
+
+func (p *Plugin) updateLocalGamePosts(lg *LocalGame, game *chess.Game, reply string) {
+	threadPost := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: lg.ChannelID,
+		RootId:    lg.RootPostID,
+		Message:   reply + "\n\n" + p.boardMarkdown(game.Position().FEN(), lastMoveUCI(lg), false),
+	}
+	if err := p.pluginAPI.Post.CreatePost(threadPost); err != nil {
+		p.API.LogWarn("failed to post local game move", "gameID", lg.ID, "error", err.Error())
+	}
+
+	root, err := p.pluginAPI.Post.GetPost(lg.RootPostID)
+	if err != nil {
+		p.API.LogWarn("failed to get local game post", "gameID", lg.ID, "error", err.Error())
+		return
+	}
+	root.Message = p.localGameMessage(lg, game)
+	if err := p.pluginAPI.Post.UpdatePost(root); err != nil {
+		p.API.LogWarn("failed to update local game post", "gameID", lg.ID, "error", err.Error())
+	}
+}
+
+func (p *Plugin) sendEphemeral(post *model.Post, message string) {
+	p.pluginAPI.Post.SendEphemeralPost(post.UserId, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: post.ChannelId,
+		RootId:    post.RootId,
+		Message:   message,
+	})
+}
@@ -25,7 +25,7 @@ type OAuthBroker struct {
 }
 
 func (p *Plugin) getOAuthConfig() (*oauth2.Config, error) {
-	scopes := []string{"preference:read"}
+	scopes := []string{"preference:read", "tournament:write"}
 	config := p.getConfiguration()
 
 	baseURL := config.getBaseURL()
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	lichessStatusJobKey   = "lichess_status"
+	lichessStatusKey      = "lichessstatuses"
+	lichessStatusInterval = time.Minute
+	// lichessStatusMaxAge is how old the stored statuses may get before
+	// /lichess who asks Lichess itself, e.g. when the job is not running.
+	lichessStatusMaxAge = 5 * time.Minute
+)
+
+// LichessStatus is what Lichess shows of a connected user right now.
+type LichessStatus struct {
+	LichessUsername string `json:"lichess_username"`
+	Online          bool   `json:"online"`
+	Playing         bool   `json:"playing"`
+	Streaming       bool   `json:"streaming"`
+	GameID          string `json:"game_id,omitempty"`
+}
+
+// LichessStatuses are the statuses of the connected users by Mattermost user
+// ID, as of the last poll.
+type LichessStatuses struct {
+	UpdatedAt time.Time                 `json:"updated_at"`
+	Users     map[string]*LichessStatus `json:"users"`
+}
+
+func (p *Plugin) getLichessStatuses() (*LichessStatuses, error) {
+	statuses := &LichessStatuses{Users: map[string]*LichessStatus{}}
+	if err := p.pluginAPI.KV.Get(lichessStatusKey, statuses); err != nil {
+		return nil, errors.Wrap(err, "failed to get Lichess statuses from kv store")
+	}
+	if statuses.Users == nil {
+		statuses.Users = map[string]*LichessStatus{}
+	}
+	return statuses, nil
+}
+
+// fetchLichessStatuses asks Lichess for the statuses of the connected users.
+// Users Lichess doesn't know are left out.
+func (p *Plugin) fetchLichessStatuses(ctx context.Context, connected map[string]string) (*LichessStatuses, error) {
+	statuses := &LichessStatuses{UpdatedAt: time.Now(), Users: map[string]*LichessStatus{}}
+	if len(connected) == 0 {
+		return statuses, nil
+	}
+
+	userIDs := map[string]string{}
+	var ids []string
+	for userID, lichessUsername := range connected {
+		id := strings.ToLower(lichessUsername)
+		userIDs[id] = userID
+		ids = append(ids, id)
+	}
+
+	polled, err := p.newLichessClient().UsersStatus(ctx, ids, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Lichess user statuses")
+	}
+	for _, status := range polled {
+		userID, ok := userIDs[strings.ToLower(status.Id)]
+		if !ok {
+			continue
+		}
+		statuses.Users[userID] = &LichessStatus{
+			LichessUsername: connected[userID],
+			Online:          status.Online,
+			Playing:         status.Playing,
+			Streaming:       status.Streaming,
+			GameID:          status.PlayingId,
+		}
+	}
+	return statuses, nil
+}
+
+// updateLichessStatuses is run by the Lichess status job. It stores the
+// statuses of all connected users and updates the custom statuses of those
+// who asked for it.
+func (p *Plugin) updateLichessStatuses(ctx context.Context) {
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	statuses, err := p.fetchLichessStatuses(ctx, connected)
+	if err != nil {
+		p.API.LogWarn("failed to poll Lichess statuses", "error", err.Error())
+		return
+	}
+	if _, err := p.pluginAPI.KV.Set(lichessStatusKey, statuses); err != nil {
+		p.API.LogWarn("failed to store Lichess statuses", "error", err.Error())
+	}
+
+	p.updatePlayingStatuses(ctx, statuses)
+}
+
+// currentLichessStatuses returns the stored statuses, or polls Lichess when
+// they are out of date.
+func (p *Plugin) currentLichessStatuses(ctx context.Context) (*LichessStatuses, error) {
+	statuses, err := p.getLichessStatuses()
+	if err == nil && time.Since(statuses.UpdatedAt) <= lichessStatusMaxAge {
+		return statuses, nil
+	}
+	if err != nil {
+		p.API.LogWarn("failed to get Lichess statuses", "error", err.Error())
+	}
+
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		return nil, err
+	}
+	return p.fetchLichessStatuses(ctx, connected)
+}
+
+// handleGetStatuses returns the statuses of the users who share a team with
+// the requester, narrowed to the user_id parameters when there are any.
+func (p *Plugin) handleGetStatuses(w http.ResponseWriter, r *http.Request) {
+	// The server sets the header for authenticated requests, unlike the
+	// MMUSERID cookie which the client controls.
+	requesterID := r.Header.Get("Mattermost-User-ID")
+	if requesterID == "" {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "not authorized", StatusCode: http.StatusUnauthorized})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	statuses, err := p.currentLichessStatuses(ctx)
+	if err != nil {
+		p.API.LogWarn("failed to get Lichess statuses", "error", err.Error())
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "failed to get Lichess statuses", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	teamIDs, err := p.getUserTeamIDs(requesterID)
+	if err != nil {
+		p.API.LogWarn("failed to get teams", "userID", requesterID, "error", err.Error())
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "failed to get Lichess statuses", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	wanted := map[string]bool{}
+	for _, userID := range r.URL.Query()["user_id"] {
+		wanted[userID] = true
+	}
+	visible := &LichessStatuses{UpdatedAt: statuses.UpdatedAt, Users: map[string]*LichessStatus{}}
+	for userID, status := range statuses.Users {
+		if len(wanted) > 0 && !wanted[userID] {
+			continue
+		}
+		if userID == requesterID || p.sharesTeam(teamIDs, userID) {
+			visible.Users[userID] = status
+		}
+	}
+	p.writeJSON(w, visible)
+}
+
+// sharesTeam tells whether a user is a member of one of the teams. The teams
+// of users are cached, so polling the statuses looks them up only every
+// few minutes.
+func (p *Plugin) sharesTeam(teamIDs map[string]bool, userID string) bool {
+	userTeamIDs, err := p.getUserTeamIDs(userID)
+	if err != nil {
+		return false
+	}
+	for teamID := range userTeamIDs {
+		if teamIDs[teamID] {
+			return true
+		}
+	}
+	return false
+}
+
+// whoEntry is a team member who is on Lichess.
+type whoEntry struct {
+	username string
+	status   *LichessStatus
+}
+
+func (p *Plugin) executeWhoCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) > 0 {
+		return ephemeralResponse("Use `/lichess who` to see who in this team is online on Lichess.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	statuses, err := p.currentLichessStatuses(ctx)
+	if err != nil {
+		p.API.LogWarn("failed to get Lichess statuses", "error", err.Error())
+		return ephemeralResponse("Failed to get the Lichess statuses.")
+	}
+
+	var entries []whoEntry
+	for userID, status := range statuses.Users {
+		if !status.Online {
+			continue
+		}
+		member, appErr := p.API.GetTeamMember(args.TeamId, userID)
+		if appErr != nil || member.DeleteAt != 0 {
+			continue
+		}
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil || user.DeleteAt != 0 {
+			continue
+		}
+		entries = append(entries, whoEntry{username: user.Username, status: status})
+	}
+	if len(entries) == 0 {
+		return ephemeralResponse("Nobody in this team is online on Lichess right now.")
+	}
+
+	// Those free for a game come first.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].status.Playing != entries[j].status.Playing {
+			return !entries[i].status.Playing
+		}
+		return entries[i].username < entries[j].username
+	})
+
+	baseURL := p.getConfiguration().getBaseURL()
+	var b strings.Builder
+	b.WriteString("#### Online on Lichess\n")
+	for _, entry := range entries {
+		status := entry.status
+		fmt.Fprintf(&b, "* @%s - [%s](%s@/%s)", entry.username, status.LichessUsername, baseURL, status.LichessUsername)
+		var doing []string
+		switch {
+		case status.Playing && status.GameID != "":
+			doing = append(doing, fmt.Sprintf("playing [a game](%s%s)", baseURL, status.GameID))
+		case status.Playing:
+			doing = append(doing, "playing")
+		}
+		if status.Streaming {
+			doing = append(doing, "streaming")
+		}
+		if len(doing) > 0 {
+			b.WriteString(", " + strings.Join(doing, " and "))
+		}
+		b.WriteString("\n")
+	}
+	return ephemeralResponse(b.String())
+}
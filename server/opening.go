@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/eco"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	explorerMovesShown    = 8
+	explorerTopGamesShown = 3
+	explorerTimeout       = 20 * time.Second
+)
+
+var moveNumberRegexp = regexp.MustCompile(`^\d+\.+`)
+
+func (p *Plugin) executeOpeningCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	database := lichess.ExplorerDatabaseLichess
+	player := ""
+	var position []string
+	for i := 0; i < len(params); i++ {
+		switch params[i] {
+		case "--masters":
+			database = lichess.ExplorerDatabaseMasters
+		case "--lichess":
+			database = lichess.ExplorerDatabaseLichess
+		case "--player":
+			if i+1 == len(params) {
+				return ephemeralResponse("Missing value for --player.")
+			}
+			i++
+			database, player = lichess.ExplorerDatabasePlayer, params[i]
+		default:
+			position = append(position, params[i])
+		}
+	}
+
+	game, err := parseExplorerPosition(position)
+	if err != nil {
+		return ephemeralResponsef("%s. Use `/lichess opening e4 e5 Nf3` or a FEN, optionally followed by `--masters`, `--lichess` or `--player <user>`.", err.Error())
+	}
+
+	start := game.StartingPosition()
+	q := lichess.ExplorerQuery{
+		Fen:  start.FEN(),
+		Play: game.UCIMoves(),
+	}
+	if database == lichess.ExplorerDatabasePlayer {
+		q.Player, err = p.lichessUsernameFromParam(player)
+		if err != nil {
+			return ephemeralResponse(err.Error())
+		}
+		q.Color = "white"
+		if game.Position().Turn == chess.Black {
+			q.Color = "black"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), explorerTimeout)
+	defer cancel()
+
+	client := lichess.NewClient(p.getConfiguration().getExplorerURL(), &http.Client{})
+	explorer, err := client.Explore(ctx, database, q)
+	if err != nil {
+		p.API.LogWarn("failed to query opening explorer", "database", database, "error", err.Error())
+		return ephemeralResponse("Failed to query the opening explorer.")
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+		Message:   p.openingMessage(game, database, q.Player, explorer),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post opening", "error", err.Error())
+		return ephemeralResponse("Failed to post the opening.")
+	}
+	return &model.CommandResponse{}
+}
+
+// parseExplorerPosition reads a FEN, or moves in SAN or UCI from the
+// starting position. Move numbers are skipped, so pasted PGN movetext works.
+func parseExplorerPosition(params []string) (*chess.Game, error) {
+	if len(params) == 0 {
+		return chess.NewGame(chess.Standard.StartingPosition()), nil
+	}
+	if strings.Contains(params[0], "/") {
+		pos, err := chess.ParseFEN(strings.Join(params, " "))
+		if err != nil {
+			return nil, errors.Errorf("invalid FEN: %s", err.Error())
+		}
+		return chess.NewGame(pos), nil
+	}
+
+	game := chess.NewGame(chess.Standard.StartingPosition())
+	for _, param := range params {
+		move := moveNumberRegexp.ReplaceAllString(param, "")
+		if move == "" {
+			continue
+		}
+		if _, err := game.PlayMove(move); err != nil {
+			return nil, errors.Errorf("%q is not a legal move", move)
+		}
+	}
+	return game, nil
+}
+
+// lichessUsernameFromParam accepts a Lichess username, or a mention of a
+// Mattermost user who connected their account.
+func (p *Plugin) lichessUsernameFromParam(param string) (string, error) {
+	if !strings.HasPrefix(param, "@") {
+		return param, nil
+	}
+	user, err := p.userFromMention(param)
+	if err != nil {
+		return "", err
+	}
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return "", errors.New("failed to load the connected users")
+	}
+	lichessUsername, ok := connected[user.Id]
+	if !ok {
+		return "", errors.Errorf("@%s has not connected a Lichess account", user.Username)
+	}
+	return lichessUsername, nil
+}
+
+func (p *Plugin) openingMessage(game *chess.Game, database, player string, explorer *lichess.Explorer) string {
+	var b strings.Builder
+	title := "Opening explorer"
+	if explorer.Opening != nil && explorer.Opening.Name != "" {
+		title = openingText(*explorer.Opening)
+	} else if classified, ok := eco.Classify(game); ok {
+		title = classified.String()
+	}
+	fmt.Fprintf(&b, "#### %s\n", title)
+
+	switch database {
+	case lichess.ExplorerDatabaseMasters:
+		b.WriteString("Masters database")
+	case lichess.ExplorerDatabasePlayer:
+		fmt.Fprintf(&b, "Games of %s", player)
+	default:
+		b.WriteString("Lichess database")
+	}
+	if moves := movesText(game); moves != "" {
+		fmt.Fprintf(&b, " after %s", moves)
+	}
+	b.WriteString("\n\n")
+
+	lastMove := ""
+	if ucis := game.UCIMoves(); len(ucis) > 0 {
+		lastMove = ucis[len(ucis)-1]
+	}
+	pos := game.Position()
+	b.WriteString(p.boardMarkdown(pos.FEN(), lastMove, pos.Turn == chess.Black))
+	b.WriteString("\n\n")
+
+	total := explorer.White + explorer.Draws + explorer.Black
+	if total == 0 {
+		b.WriteString("No games reached this position.")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "%s games: %s\n\n", formatCount(total), wdlText(explorer.White, explorer.Draws, explorer.Black))
+
+	moves := explorer.Moves
+	if len(moves) > explorerMovesShown {
+		moves = moves[:explorerMovesShown]
+	}
+	if len(moves) > 0 {
+		b.WriteString("| Move | Games | White / Draw / Black | Rating |\n")
+		b.WriteString("|:-----|------:|:---------------------|-------:|\n")
+		for _, move := range moves {
+			games := move.White + move.Draws + move.Black
+			fmt.Fprintf(&b, "| **%s** | %s | %s | %d |\n", move.San, formatCount(games), wdlText(move.White, move.Draws, move.Black), move.AverageRating)
+		}
+	}
+
+	topGames := explorer.TopGames
+	if len(topGames) == 0 {
+		topGames = explorer.RecentGames
+	}
+	if len(topGames) > explorerTopGamesShown {
+		topGames = topGames[:explorerTopGamesShown]
+	}
+	if len(topGames) > 0 {
+		b.WriteString("\n")
+		baseURL := p.getConfiguration().getBaseURL()
+		for _, g := range topGames {
+			result := "½-½"
+			switch g.Winner {
+			case "white":
+				result = "1-0"
+			case "black":
+				result = "0-1"
+			}
+			fmt.Fprintf(&b, "* [%s (%d) - %s (%d), %s](%s%s)", g.White.Name, g.White.Rating, g.Black.Name, g.Black.Rating, result, baseURL, g.Id)
+			if g.Year > 0 {
+				fmt.Fprintf(&b, " %d", g.Year)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// wdlText formats results as percentages of white wins, draws and black
+// wins.
+func wdlText(white, draws, black int) string {
+	total := white + draws + black
+	if total == 0 {
+		return ""
+	}
+	percent := func(n int) int {
+		return (n*100 + total/2) / total
+	}
+	return fmt.Sprintf("%d%% / %d%% / %d%%", percent(white), percent(draws), percent(black))
+}
+
+// formatCount abbreviates large counts like 1.2M or 35k.
+func formatCount(n int) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 10000:
+		return fmt.Sprintf("%dk", n/1000)
+	default:
+		return fmt.Sprint(n)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/eco"
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	pgnExportTimeout   = 30 * time.Second
+	localGamesPageSize = 1000
+)
+
+func (p *Plugin) executePGNCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	switch {
+	case len(params) == 1 && params[0] == "local":
+		return p.executeLocalPGNCommand(args)
+	case len(params) == 2 && params[0] == "tournament":
+		return p.executeTournamentPGNCommand(args, params[1])
+	case len(params) == 1:
+		gameID, resp := p.resolveGameID(params[0])
+		if resp != nil {
+			return resp
+		}
+		if err := p.exportGamePGN(args.ChannelId, args.RootId, gameID); err != nil {
+			p.API.LogWarn("failed to export game", "gameID", gameID, "error", err.Error())
+			return ephemeralResponse(lichessErrorText(err))
+		}
+		return &model.CommandResponse{}
+	default:
+		return ephemeralResponse("Use `/lichess pgn <game ID or link>`, `/lichess pgn tournament <tournament link>` or `/lichess pgn local`.")
+	}
+}
+
+// exportGamePGN replies with the PGN of a Lichess game as a file.
+func (p *Plugin) exportGamePGN(channelID, rootID, gameID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pgnExportTimeout)
+	defer cancel()
+
+	pgn, err := p.newLichessClient().GamePGN(ctx, gameID)
+	if err != nil {
+		return err
+	}
+	link := p.getConfiguration().getBaseURL() + gameID
+	return p.postPGN(channelID, rootID, "lichess_"+gameID+".pgn", fmt.Sprintf("PGN of [%s](%s)", gameID, link), pgn)
+}
+
+func (p *Plugin) executeTournamentPGNCommand(args *model.CommandArgs, ref string) *model.CommandResponse {
+	_, id := parseTournamentRef(ref)
+	if !tournamentIDRegexp.MatchString(id) {
+		return ephemeralResponsef("%q is not a link to a Lichess tournament.", ref)
+	}
+
+	t, err := p.getTournament(id)
+	if err != nil {
+		p.API.LogWarn("failed to get tournament", "error", err.Error())
+		return ephemeralResponse("Failed to load the tournament.")
+	}
+	if t == nil {
+		return ephemeralResponse("Only the games of tournaments followed by the plugin can be exported. Use `/lichess tournaments track` to follow it first.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*pgnExportTimeout)
+	defer cancel()
+
+	client := p.newStreamingLichessClient()
+	var pgn string
+	if t.Kind == tournamentKindSwiss {
+		pgn, err = client.SwissGamesPGN(ctx, t.ID)
+	} else {
+		pgn, err = client.ArenaGamesPGN(ctx, t.ID)
+	}
+	if err != nil {
+		p.API.LogWarn("failed to export tournament games", "tournamentID", t.ID, "error", err.Error())
+		return ephemeralResponse(lichessErrorText(err))
+	}
+	if strings.TrimSpace(pgn) == "" {
+		return ephemeralResponsef("[%s](%s) has no games yet.", t.Name, p.tournamentURL(t))
+	}
+
+	message := fmt.Sprintf("Games of [%s](%s)", t.Name, p.tournamentURL(t))
+	if err := p.postPGN(args.ChannelId, args.RootId, "lichess_tournament_"+t.ID+".pgn", message, pgn); err != nil {
+		p.API.LogWarn("failed to post tournament games", "error", err.Error())
+		return ephemeralResponse("Failed to post the games.")
+	}
+	return &model.CommandResponse{}
+}
+
+// executeLocalPGNCommand exports the local games played in the channel, the
+// oldest first, as one PGN.
+func (p *Plugin) executeLocalPGNCommand(args *model.CommandArgs) *model.CommandResponse {
+	games, err := p.getChannelLocalGames(args.ChannelId)
+	if err != nil {
+		p.API.LogWarn("failed to get local games", "error", err.Error())
+		return ephemeralResponse("Failed to load the local games.")
+	}
+	if len(games) == 0 {
+		return ephemeralResponse("No local games were played in this channel.")
+	}
+
+	var b strings.Builder
+	for _, lg := range games {
+		if err := p.writeLocalGamePGN(&b, lg); err != nil {
+			p.API.LogWarn("failed to export local game", "gameID", lg.ID, "error", err.Error())
+		}
+	}
+
+	message := fmt.Sprintf("%d local games of this channel", len(games))
+	if err := p.postPGN(args.ChannelId, args.RootId, "local_games.pgn", message, b.String()); err != nil {
+		p.API.LogWarn("failed to post local games", "error", err.Error())
+		return ephemeralResponse("Failed to post the games.")
+	}
+	return &model.CommandResponse{}
+}
+
+// getChannelLocalGames scans the stored local games for the ones played in a
+// channel. Local games are not indexed by channel, and exports are rare.
+func (p *Plugin) getChannelLocalGames(channelID string) ([]*LocalGame, error) {
+	var games []*LocalGame
+	for page := 0; ; page++ {
+		keys, err := p.pluginAPI.KV.ListKeys(page, localGamesPageSize, pluginapi.WithPrefix(localGameKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list local games")
+		}
+		for _, key := range keys {
+			lg, _, err := p.getLocalGame(strings.TrimPrefix(key, localGameKey))
+			if err != nil {
+				return nil, err
+			}
+			if lg != nil && lg.ChannelID == channelID {
+				games = append(games, lg)
+			}
+		}
+		if len(keys) < localGamesPageSize {
+			break
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].CreatedAt < games[j].CreatedAt
+	})
+	return games, nil
+}
+
+func (p *Plugin) writeLocalGamePGN(b *strings.Builder, lg *LocalGame) error {
+	game, err := lg.replay()
+	if err != nil {
+		return err
+	}
+
+	tags := map[string]string{
+		"Event": "Mattermost local game",
+		"Site":  *p.pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL + "/_redirect/pl/" + lg.RootPostID,
+		"Date":  time.UnixMilli(lg.CreatedAt).UTC().Format("2006.01.02"),
+		"White": p.localPlayerName(lg.WhiteUserID),
+		"Black": p.localPlayerName(lg.BlackUserID),
+	}
+	if termination := game.Termination(); termination != "" {
+		tags["Termination"] = string(termination)
+	}
+	if opening, ok := eco.Classify(game); ok {
+		tags["ECO"], tags["Opening"] = opening.ECO, opening.Name
+	}
+	return chess.WritePGN(b, game, tags)
+}
+
+func (p *Plugin) localPlayerName(userID string) string {
+	user, err := p.pluginAPI.User.Get(userID)
+	if err != nil {
+		return "?"
+	}
+	return user.Username
+}
+
+// postPGN uploads a PGN file and posts it, in the thread of rootID if set.
+func (p *Plugin) postPGN(channelID, rootID, filename, message, pgn string) error {
+	fileInfo, err := p.pluginAPI.File.Upload(strings.NewReader(pgn), filename, channelID)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload PGN")
+	}
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		RootId:    rootID,
+		Message:   message,
+		FileIds:   model.StringArray{fileInfo.Id},
+	}
+	return errors.Wrap(p.pluginAPI.Post.CreatePost(post), "failed to post PGN")
+}
+
+func (p *Plugin) pgnExportAction(gameID string) *model.PostAction {
+	return &model.PostAction{
+		Id:   "exportpgn",
+		Name: "Export PGN",
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			URL:     *p.pluginAPI.Configuration.GetConfig().ServiceSettings.SiteURL + pluginURLPath + "/pgn/export",
+			Context: map[string]interface{}{"game_id": gameID},
+		},
+	}
+}
+
+// handlePGNExport replies to a game post with the game's PGN when its Export
+// button is clicked.
+func (p *Plugin) handlePGNExport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	post, ok := p.actionPost(userID, request.PostId)
+	if !ok {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	}
+
+	// Only games the post offers to export can be exported.
+	requested, _ := request.Context["game_id"].(string)
+	gameID := ""
+	for _, context := range actionContexts(post, "/pgn/export") {
+		if stored, _ := context["game_id"].(string); stored == requested {
+			gameID = stored
+			break
+		}
+	}
+	if !gameIDRegexp.MatchString(gameID) {
+		http.Error(w, "invalid game", http.StatusBadRequest)
+		return
+	}
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+
+	response := &model.PostActionIntegrationResponse{}
+	if err := p.exportGamePGN(post.ChannelId, rootID, gameID); err != nil {
+		p.API.LogWarn("failed to export game", "gameID", gameID, "error", err.Error())
+		response.EphemeralText = lichessErrorText(err)
+	}
+	p.writeJSON(w, response)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	playingStatusKey = "playingstatus"
+	// playingStatusExpiry clears the custom status even if the end of the
+	// game is never noticed, e.g. because the plugin was disabled.
+	playingStatusExpiry = 6 * time.Hour
+
+	playingStatusEmoji = "chess_pawn"
+)
+
+// PlayingStatusSubscription is a user whose Mattermost status shows their
+// Lichess games. Game is set while they play.
+type PlayingStatusSubscription struct {
+	DND  bool
+	Game *PlayingStatusGame
+}
+
+// PlayingStatusGame is the game a user plays and the statuses to restore
+// when it ends. PreviousStatus is only set when it was changed to Do Not
+// Disturb.
+type PlayingStatusGame struct {
+	GameID               string
+	Text                 string
+	PreviousCustomStatus *model.CustomStatus
+	PreviousStatus       string
+}
+
+func (p *Plugin) executeStatusCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	switch {
+	case len(params) == 1 && params[0] == "off":
+		var game *PlayingStatusGame
+		err := p.updatePlayingStatusSubscriptions(func(subs map[string]*PlayingStatusSubscription) {
+			if sub := subs[args.UserId]; sub != nil {
+				game = sub.Game
+			}
+			delete(subs, args.UserId)
+		})
+		if err != nil {
+			p.API.LogWarn("failed to unsubscribe from playing status", "error", err.Error())
+			return ephemeralResponse("Failed to turn off the playing status.")
+		}
+		if game != nil {
+			p.restoreStatus(args.UserId, game)
+		}
+		return ephemeralResponse("Your status will not show your Lichess games anymore.")
+	case len(params) >= 1 && params[0] == "on":
+		dnd := false
+		for _, param := range params[1:] {
+			if param != "--dnd" {
+				return ephemeralResponsef("Unknown option %q.", param)
+			}
+			dnd = true
+		}
+
+		connected, err := p.getConnectedUsers()
+		if err != nil {
+			p.API.LogWarn("failed to get connected users", "error", err.Error())
+			return ephemeralResponse("Failed to load the connected users.")
+		}
+		if _, ok := connected[args.UserId]; !ok {
+			return p.notConnectedResponse()
+		}
+
+		err = p.updatePlayingStatusSubscriptions(func(subs map[string]*PlayingStatusSubscription) {
+			if sub := subs[args.UserId]; sub != nil {
+				sub.DND = dnd
+				return
+			}
+			subs[args.UserId] = &PlayingStatusSubscription{DND: dnd}
+		})
+		if err != nil {
+			p.API.LogWarn("failed to subscribe to playing status", "error", err.Error())
+			return ephemeralResponse("Failed to turn on the playing status.")
+		}
+		if dnd {
+			return ephemeralResponse("While you play on Lichess, your custom status will say so and you will be set to Do Not Disturb.")
+		}
+		return ephemeralResponse("While you play on Lichess, your custom status will say so.")
+	default:
+		return ephemeralResponse("Use `/lichess status on [--dnd]` to show your Lichess games in your custom status, optionally setting you to Do Not Disturb, or `/lichess status off` to stop.")
+	}
+}
+
+func (p *Plugin) getPlayingStatusSubscriptions() (map[string]*PlayingStatusSubscription, error) {
+	subs := map[string]*PlayingStatusSubscription{}
+	if err := p.pluginAPI.KV.Get(playingStatusKey, &subs); err != nil {
+		return nil, errors.Wrap(err, "failed to get playing status subscriptions from kv store")
+	}
+	return subs, nil
+}
+
+func (p *Plugin) updatePlayingStatusSubscriptions(update func(subs map[string]*PlayingStatusSubscription)) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(playingStatusKey, func(old []byte) (interface{}, error) {
+		subs := map[string]*PlayingStatusSubscription{}
+		if old != nil {
+			if err := json.Unmarshal(old, &subs); err != nil {
+				return nil, err
+			}
+		}
+		update(subs)
+		return subs, nil
+	})
+	return errors.Wrap(err, "failed to store playing status subscriptions")
+}
+
+// updatePlayingStatuses sets the custom status of subscribed users who
+// started a game and restores it for those whose game ended.
+func (p *Plugin) updatePlayingStatuses(ctx context.Context, statuses *LichessStatuses) {
+	subs, err := p.getPlayingStatusSubscriptions()
+	if err != nil {
+		p.API.LogWarn("failed to get playing status subscriptions", "error", err.Error())
+		return
+	}
+
+	changed := map[string]*PlayingStatusGame{}
+	for userID, sub := range subs {
+		gameID := ""
+		if status := statuses.Users[userID]; status != nil && status.Playing {
+			gameID = status.GameID
+		}
+		switch {
+		case gameID != "" && (sub.Game == nil || sub.Game.GameID != gameID):
+			game, err := p.setPlayingStatus(ctx, userID, gameID, sub)
+			if err != nil {
+				p.API.LogWarn("failed to set playing status", "userID", userID, "error", err.Error())
+				continue
+			}
+			changed[userID] = game
+		case gameID == "" && sub.Game != nil:
+			p.restoreStatus(userID, sub.Game)
+			changed[userID] = nil
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	err = p.updatePlayingStatusSubscriptions(func(subs map[string]*PlayingStatusSubscription) {
+		for userID, game := range changed {
+			if sub := subs[userID]; sub != nil {
+				sub.Game = game
+			}
+		}
+	})
+	if err != nil {
+		p.API.LogWarn("failed to store playing statuses", "error", err.Error())
+	}
+}
+
+// setPlayingStatus shows the game in the custom status of the user. When
+// the user moves on to another game, the statuses saved for the first one
+// are kept.
+func (p *Plugin) setPlayingStatus(ctx context.Context, userID, gameID string, sub *PlayingStatusSubscription) (*PlayingStatusGame, error) {
+	text := "Playing on Lichess"
+	if game, err := p.newLichessClient().ExportGame(ctx, gameID); err == nil {
+		text = playingStatusText(game)
+	} else {
+		p.API.LogWarn("failed to get played game", "gameID", gameID, "error", err.Error())
+	}
+
+	playing := sub.Game
+	first := playing == nil
+	if first {
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get user")
+		}
+		playing = &PlayingStatusGame{PreviousCustomStatus: user.GetCustomStatus()}
+	}
+	playing.GameID, playing.Text = gameID, text
+
+	customStatus := &model.CustomStatus{
+		Emoji:     playingStatusEmoji,
+		Text:      text,
+		Duration:  "date_and_time",
+		ExpiresAt: time.Now().Add(playingStatusExpiry),
+	}
+	if appErr := p.API.UpdateUserCustomStatus(userID, customStatus); appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to set custom status")
+	}
+
+	// Do Not Disturb is set after the custom status, so playing is always
+	// returned once the user's status changed and can be restored.
+	if first && sub.DND {
+		if err := p.setPlayingDND(userID, playing); err != nil {
+			p.API.LogWarn("failed to set Do Not Disturb", "userID", userID, "error", err.Error())
+		}
+	}
+	return playing, nil
+}
+
+// setPlayingDND puts the user in Do Not Disturb, and records their status to
+// restore after the game.
+func (p *Plugin) setPlayingDND(userID string, playing *PlayingStatusGame) error {
+	status, err := p.pluginAPI.User.GetStatus(userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user status")
+	}
+	if status.Status == model.StatusDnd {
+		return nil
+	}
+	if _, err := p.pluginAPI.User.UpdateStatus(userID, model.StatusDnd); err != nil {
+		return errors.Wrap(err, "failed to update user status")
+	}
+	playing.PreviousStatus = status.Status
+	return nil
+}
+
+func playingStatusText(game *lichess.Game) string {
+	kind := game.Speed
+	if variant, err := chess.ParseVariant(game.Variant); err == nil && variant != chess.Standard {
+		kind = variant.Name()
+	}
+	if kind == "" {
+		return "Playing on Lichess"
+	}
+	return fmt.Sprintf("Playing %s on Lichess", kind)
+}
+
+// restoreStatus puts back the statuses the user had before the game, unless
+// they changed them in the meantime.
+func (p *Plugin) restoreStatus(userID string, playing *PlayingStatusGame) {
+	user, err := p.pluginAPI.User.Get(userID)
+	if err != nil {
+		p.API.LogWarn("failed to get user", "userID", userID, "error", err.Error())
+		return
+	}
+
+	if current := user.GetCustomStatus(); current != nil && current.Emoji == playingStatusEmoji && current.Text == playing.Text {
+		previous := playing.PreviousCustomStatus
+		var appErr *model.AppError
+		if previous != nil && (previous.ExpiresAt.IsZero() || previous.ExpiresAt.After(time.Now())) {
+			appErr = p.API.UpdateUserCustomStatus(userID, previous)
+		} else {
+			appErr = p.API.RemoveUserCustomStatus(userID)
+		}
+		if appErr != nil {
+			p.API.LogWarn("failed to restore custom status", "userID", userID, "error", appErr.Error())
+		}
+	}
+
+	if playing.PreviousStatus == "" {
+		return
+	}
+	status, err := p.pluginAPI.User.GetStatus(userID)
+	if err != nil {
+		p.API.LogWarn("failed to get user status", "userID", userID, "error", err.Error())
+		return
+	}
+	if status.Status != model.StatusDnd {
+		return
+	}
+	if _, err := p.pluginAPI.User.UpdateStatus(userID, playing.PreviousStatus); err != nil {
+		p.API.LogWarn("failed to restore user status", "userID", userID, "error", err.Error())
+	}
+}
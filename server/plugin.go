@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/uci"
 	"github.com/gorilla/mux"
 	pluginapi "github.com/mattermost/mattermost-plugin-api"
 	"github.com/mattermost/mattermost-server/v6/model"
@@ -23,6 +28,38 @@ type Plugin struct {
 	router *mux.Router
 
 	oauthBroker *OAuthBroker
+
+	clusterEvents *clusterEventBus
+
+	botUserID string
+
+	localGames *localGameCache
+
+	accounts *accountCache
+	teams    *teamCache
+
+	scheduler *scheduler
+
+	tv     *liveRunner
+	watch  *liveRunner
+	arenas *liveRunner
+
+	engineLock      sync.Mutex
+	engines         *uci.Pool
+	enginePath      string
+	engineProcesses int
+
+	// analyses tracks the game analyses running in the background, which
+	// stop when analysesCtx is cancelled.
+	analyses       sync.WaitGroup
+	analysesCtx    context.Context
+	cancelAnalyses context.CancelFunc
+
+	// unfurls tracks the link previews fetched in the background, which
+	// stop when unfurlsCtx is cancelled.
+	unfurls       sync.WaitGroup
+	unfurlsCtx    context.Context
+	cancelUnfurls context.CancelFunc
 }
 
 type LichessUserInfo struct {
@@ -58,19 +95,118 @@ func (p *Plugin) OnActivate() error {
 
 	p.initializeAPI()
 
+	p.clusterEvents = newClusterEventBus(p.API)
+	if err := p.registerClusterEventHandlers(); err != nil {
+		return errors.Wrap(err, "failed to register cluster event handlers")
+	}
 	p.oauthBroker = NewOAuthBroker(p.sendOAuthCompleteEvent)
 
+	p.localGames = newLocalGameCache()
+	p.accounts = newAccountCache()
+	p.teams = newTeamCache()
+	p.analysesCtx, p.cancelAnalyses = context.WithCancel(context.Background())
+	p.unfurlsCtx, p.cancelUnfurls = context.WithCancel(context.Background())
+
+	if err := p.backfillConnectedUsers(); err != nil {
+		p.API.LogWarn("failed to backfill connected users", "error", err.Error())
+	}
+
+	botUserID, err := p.pluginAPI.Bot.EnsureBot(&model.Bot{
+		Username:    "lichess",
+		DisplayName: "Lichess",
+		Description: "Created by the Lichess plugin.",
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot")
+	}
+	p.botUserID = botUserID
+
+	p.scheduler = newScheduler(p)
+	p.scheduler.every(dailyPuzzleJobKey, dailyPuzzleCheckInterval, p.postDailyPuzzle)
+	p.scheduler.every(ratingSnapshotJobKey, ratingSnapshotInterval, p.snapshotRatings)
+	p.scheduler.every(weeklyDigestJobKey, weeklyDigestCheckInterval, p.postWeeklyDigest)
+	p.scheduler.every(teamSyncJobKey, teamSyncInterval, p.syncTeams)
+	p.scheduler.every(tournamentWatchJobKey, tournamentWatchInterval, p.watchTournaments)
+	p.scheduler.every(gameReportJobKey, gameReportInterval, p.sendGameReports)
+	p.scheduler.every(lichessStatusJobKey, lichessStatusInterval, p.updateLichessStatuses)
+
+	if err := p.registerCommands(); err != nil {
+		return err
+	}
+
+	p.tv = newLiveRunner(p, tvRunnerName, tvEndedNote, p.streamTV)
+	if err := p.tv.start(); err != nil {
+		return err
+	}
+	p.watch = newLiveRunner(p, watchRunnerName, watchEndedNote, p.streamWatchedGame)
+	if err := p.watch.start(); err != nil {
+		p.tv.stop()
+		return err
+	}
+	p.arenas = newLiveRunner(p, arenaRunnerName, arenaEndedNote, p.streamArena)
+	p.arenas.ended = p.archiveExpiredArena
+	if err := p.arenas.start(); err != nil {
+		p.tv.stop()
+		p.watch.stop()
+		return err
+	}
+	// The scheduler starts last, so a failed activation leaves no job running.
+	if err := p.scheduler.start(); err != nil {
+		p.tv.stop()
+		p.watch.stop()
+		p.arenas.stop()
+		return err
+	}
+
 	return nil
 }
 
 func (p *Plugin) OnDeactivate() error {
 	p.oauthBroker.Close()
 
+	if p.tv != nil {
+		p.tv.stop()
+	}
+	if p.watch != nil {
+		p.watch.stop()
+	}
+	if p.arenas != nil {
+		p.arenas.stop()
+	}
+	if p.scheduler != nil {
+		p.scheduler.stop()
+	}
+	if p.cancelAnalyses != nil {
+		p.cancelAnalyses()
+		p.analyses.Wait()
+	}
+	if p.cancelUnfurls != nil {
+		p.cancelUnfurls()
+		p.unfurls.Wait()
+	}
+	p.closeEnginePool()
+
 	return nil
 }
 
 func (p *Plugin) OnPluginClusterEvent(c *plugin.Context, ev model.PluginClusterEvent) {
-	p.HandleClusterEvent(ev)
+	if p.clusterEvents != nil {
+		p.clusterEvents.handle(ev)
+	}
+}
+
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.UserId == p.botUserID {
+		return
+	}
+
+	p.goUnfurl(post)
+	if post.RootId == "" {
+		return
+	}
+
+	p.handleLocalGamePost(post)
+	p.handlePuzzlePost(post)
 }
 
 func (p *Plugin) setDefaultConfiguration() error {
@@ -95,6 +231,30 @@ func (p *Plugin) setDefaultConfiguration() error {
 	return nil
 }
 
+// newLichessClient returns an anonymous client for the configured Lichess
+// server.
+func (p *Plugin) newLichessClient() *lichess.Client {
+	return lichess.NewClient(p.getConfiguration().getBaseURL(), &http.Client{Timeout: 30 * time.Second})
+}
+
+// newUserLichessClient returns a client authenticated as the Lichess account
+// the user connected.
+func (p *Plugin) newUserLichessClient(userID string) (*lichess.Client, error) {
+	info, err := p.getLichessUserInfo(userID)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(info.Token))
+	httpClient.Timeout = 30 * time.Second
+	return lichess.NewClient(p.getConfiguration().getBaseURL(), httpClient), nil
+}
+
+// newStreamingLichessClient returns an anonymous client without a timeout
+// for streamed responses. Callers bound requests through their context.
+func (p *Plugin) newStreamingLichessClient() *lichess.Client {
+	return lichess.NewClient(p.getConfiguration().getBaseURL(), &http.Client{})
+}
+
 func (p *Plugin) storeLichessUserInfo(info *LichessUserInfo) error {
 	config := p.getConfiguration()
 
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	dailyPuzzleJobKey        = "daily_puzzle"
+	dailyPuzzleLastKey       = "dailypuzzle_last"
+	puzzlePostKey            = "puzzlepost_"
+	dailyPuzzleCheckInterval = time.Hour
+	puzzleThreadExpiry       = 7 * 24 * time.Hour
+)
+
+// PuzzleThread is the state of a puzzle post. Every user solves the puzzle
+// on their own, so progress is kept per user as the number of solution
+// moves already played.
+type PuzzleThread struct {
+	PuzzleID string
+	FEN      string
+	Solution []string
+	Progress map[string]int
+	Solvers  []string
+}
+
+// puzzlePosition replays the game the puzzle was taken from up to the
+// position the solver has to move in.
+func puzzlePosition(puzzle *lichess.DailyPuzzle) (*chess.Position, error) {
+	game := chess.NewGame(chess.NewPosition())
+	for _, san := range strings.Fields(puzzle.Game.Pgn) {
+		if _, err := game.PlayMove(san); err != nil {
+			return nil, errors.Wrapf(err, "failed to replay puzzle %s", puzzle.Puzzle.Id)
+		}
+	}
+	return game.Position(), nil
+}
+
+// postDailyPuzzle is run by the daily puzzle job. It checks every hour and
+// posts the puzzle once it changed.
+func (p *Plugin) postDailyPuzzle(ctx context.Context) {
+	channelIDs := p.getConfiguration().getDailyPuzzleChannelIDs()
+	if len(channelIDs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	puzzle, err := p.newLichessClient().DailyPuzzle(ctx)
+	if err != nil {
+		p.API.LogWarn("failed to fetch daily puzzle", "error", err.Error())
+		return
+	}
+
+	last, appErr := p.API.KVGet(dailyPuzzleLastKey)
+	if appErr != nil {
+		p.API.LogWarn("failed to get last daily puzzle", "error", appErr.Error())
+		return
+	}
+	if string(last) == puzzle.Puzzle.Id {
+		return
+	}
+
+	pos, err := puzzlePosition(puzzle)
+	if err != nil {
+		p.API.LogWarn("failed to build daily puzzle position", "error", err.Error())
+		return
+	}
+
+	thread := &PuzzleThread{
+		PuzzleID: puzzle.Puzzle.Id,
+		FEN:      pos.FEN(),
+		Solution: puzzle.Puzzle.Solution,
+		Progress: map[string]int{},
+	}
+
+	for _, channelID := range channelIDs {
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			Message:   p.dailyPuzzleMessage(puzzle, pos),
+		}
+		if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+			p.API.LogWarn("failed to post daily puzzle", "channelID", channelID, "error", err.Error())
+			continue
+		}
+		if err := p.pluginAPI.KV.SetWithExpiry(puzzlePostKey+post.Id, thread, puzzleThreadExpiry); err != nil {
+			p.API.LogWarn("failed to store daily puzzle post", "postID", post.Id, "error", err.Error())
+		}
+	}
+
+	if appErr := p.API.KVSet(dailyPuzzleLastKey, []byte(puzzle.Puzzle.Id)); appErr != nil {
+		p.API.LogWarn("failed to store last daily puzzle", "error", appErr.Error())
+	}
+}
+
+func (p *Plugin) dailyPuzzleMessage(puzzle *lichess.DailyPuzzle, pos *chess.Position) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Lichess daily puzzle\n")
+	fmt.Fprintf(&b, "**%s to play.** Rating %d, played %d times.", capitalize(pos.Turn.String()), puzzle.Puzzle.Rating, puzzle.Puzzle.Plays)
+	fmt.Fprintf(&b, "\nReply in this thread with your moves in SAN or UCI. [Solve on Lichess](%straining/%s)\n\n", p.getConfiguration().getBaseURL(), puzzle.Puzzle.Id)
+	b.WriteString(p.boardMarkdown(pos.FEN(), "", pos.Turn == chess.Black))
+	return b.String()
+}
+
+// handlePuzzlePost checks a move replied to a puzzle post against the
+// solution. Feedback is ephemeral so the solution is not spoiled for others;
+// only solving the puzzle is announced in the thread.
+func (p *Plugin) handlePuzzlePost(post *model.Post) {
+	text := strings.TrimSpace(post.Message)
+	if !moveLikeRegexp.MatchString(text) {
+		return
+	}
+
+	key := puzzlePostKey + post.RootId
+	exists, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.API.LogWarn("failed to get puzzle thread", "rootID", post.RootId, "error", appErr.Error())
+		return
+	}
+	if exists == nil {
+		return
+	}
+
+	var feedback string
+	var solvedRank int
+	err := p.updatePuzzleThread(key, func(thread *PuzzleThread) (bool, error) {
+		feedback, solvedRank = "", 0
+
+		progress := thread.Progress[post.UserId]
+		if progress >= len(thread.Solution) {
+			feedback = "You already solved this puzzle."
+			return false, nil
+		}
+
+		pos, err := chess.ParseFEN(thread.FEN)
+		if err != nil {
+			return false, err
+		}
+		for _, uci := range thread.Solution[:progress] {
+			m, err := pos.ParseMove(uci)
+			if err != nil {
+				return false, err
+			}
+			pos = pos.Play(m)
+		}
+
+		m, err := pos.ParseMove(text)
+		if err != nil {
+			feedback = fmt.Sprintf("`%s` is not a legal move here.", text)
+			return false, nil
+		}
+
+		// Any mate is accepted, like on Lichess.
+		next := pos.Play(m)
+		mate := next.InCheck() && !next.HasLegalMoves()
+		if pos.UCI(m) != thread.Solution[progress] && !mate {
+			feedback = fmt.Sprintf("`%s` is not the best move, try again.", pos.SAN(m))
+			return false, nil
+		}
+
+		progress++
+		if progress >= len(thread.Solution) || mate {
+			thread.Progress[post.UserId] = len(thread.Solution)
+			thread.Solvers = append(thread.Solvers, post.UserId)
+			solvedRank = len(thread.Solvers)
+			return true, nil
+		}
+
+		reply, err := next.ParseMove(thread.Solution[progress])
+		if err != nil {
+			return false, err
+		}
+		after := next.Play(reply)
+		thread.Progress[post.UserId] = progress + 1
+		feedback = fmt.Sprintf("Correct! The opponent answers **%s**. Your move.\n\n%s",
+			next.SAN(reply), p.boardMarkdown(after.FEN(), next.UCI(reply), after.Turn == chess.Black))
+		return true, nil
+	})
+	if err != nil {
+		p.API.LogWarn("failed to update puzzle thread", "rootID", post.RootId, "error", err.Error())
+		return
+	}
+
+	if feedback != "" {
+		p.sendEphemeral(post, feedback)
+	}
+	if solvedRank > 0 {
+		message := fmt.Sprintf("%s solved the puzzle! (#%d)", p.mention(post.UserId), solvedRank)
+		if solvedRank == 1 {
+			message = fmt.Sprintf(":trophy: %s solved the puzzle first!", p.mention(post.UserId))
+		}
+		reply := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: post.ChannelId,
+			RootId:    post.RootId,
+			Message:   message,
+		}
+		if err := p.pluginAPI.Post.CreatePost(reply); err != nil {
+			p.API.LogWarn("failed to post puzzle solver", "rootID", post.RootId, "error", err.Error())
+		}
+	}
+}
+
+// updatePuzzleThread applies update with compare and set, retrying when
+// several users answer at once. The expiry of the thread is kept.
+func (p *Plugin) updatePuzzleThread(key string, update func(thread *PuzzleThread) (bool, error)) error {
+	for i := 0; i < 5; i++ {
+		old, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get puzzle thread from kv store")
+		}
+		if old == nil {
+			return errors.New("puzzle thread expired")
+		}
+
+		var thread PuzzleThread
+		if err := json.Unmarshal(old, &thread); err != nil {
+			return errors.Wrap(err, "failed to unmarshal puzzle thread")
+		}
+		if thread.Progress == nil {
+			thread.Progress = map[string]int{}
+		}
+
+		changed, err := update(&thread)
+		if err != nil || !changed {
+			return err
+		}
+
+		data, err := json.Marshal(thread)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal puzzle thread")
+		}
+		saved, appErr := p.API.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        old,
+			ExpireInSeconds: int64(puzzleThreadExpiry / time.Second),
+		})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to store puzzle thread in kv store")
+		}
+		if saved {
+			return nil
+		}
+	}
+	return errors.New("too many concurrent puzzle thread updates")
+}
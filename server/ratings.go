@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/render"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	ratingSnapshotJobKey   = "rating_snapshot"
+	ratingHistoryKey       = "ratinghistory_"
+	ratingSnapshotInterval = 24 * time.Hour
+	// ratingHistoryLimit bounds the stored snapshots to about two years.
+	ratingHistoryLimit = 2 * 366
+
+	defaultProgressPeriod = 90 * 24 * time.Hour
+)
+
+// RatingSnapshot holds the ratings and game counts of a user at one point in
+// time, keyed by perf. Perfs without games are left out. Storm is the Puzzle
+// Storm high score and StormRuns the number of Storm runs played.
+type RatingSnapshot struct {
+	Time      int64
+	Ratings   map[string]int
+	Games     map[string]int
+	Storm     int
+	StormRuns int
+}
+
+func newRatingSnapshot(account lichess.LichessAccount, now int64) RatingSnapshot {
+	snapshot := RatingSnapshot{
+		Time:      now,
+		Ratings:   map[string]int{},
+		Games:     map[string]int{},
+		Storm:     account.Perfs.Storm.Score,
+		StormRuns: account.Perfs.Storm.Runs,
+	}
+	for _, key := range lichess.PerfKeys {
+		perf, _ := account.Perfs.Get(key)
+		if perf.Games == 0 {
+			continue
+		}
+		snapshot.Ratings[key] = perf.Rating
+		snapshot.Games[key] = perf.Games
+	}
+	return snapshot
+}
+
+// sameRatings reports whether nothing was played between two snapshots.
+func (s RatingSnapshot) sameRatings(other RatingSnapshot) bool {
+	if len(s.Games) != len(other.Games) || s.Storm != other.Storm || s.StormRuns != other.StormRuns {
+		return false
+	}
+	for key, games := range s.Games {
+		if other.Games[key] != games || other.Ratings[key] != s.Ratings[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshotRatings is run by the rating snapshot job. It stores the current
+// ratings of every connected user once a day.
+func (p *Plugin) snapshotRatings(ctx context.Context) {
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return
+	}
+	if len(connected) == 0 {
+		return
+	}
+
+	usernames := make([]string, 0, len(connected))
+	for _, lichessUsername := range connected {
+		usernames = append(usernames, lichessUsername)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	accounts, err := p.getLichessAccounts(ctx, usernames)
+	if err != nil {
+		p.API.LogWarn("failed to fetch Lichess users", "error", err.Error())
+		return
+	}
+
+	now := model.GetMillis()
+	for userID, lichessUsername := range connected {
+		account, ok := accounts[strings.ToLower(lichessUsername)]
+		if !ok {
+			continue
+		}
+		if err := p.appendRatingSnapshot(userID, newRatingSnapshot(account, now)); err != nil {
+			p.API.LogWarn("failed to store rating snapshot", "userID", userID, "error", err.Error())
+		}
+	}
+}
+
+// appendRatingSnapshot adds a snapshot to the history of a user. Snapshots
+// equal to the previous one are skipped, so users who don't play don't fill
+// up the history.
+func (p *Plugin) appendRatingSnapshot(userID string, snapshot RatingSnapshot) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(ratingHistoryKey+userID, func(old []byte) (interface{}, error) {
+		var history []RatingSnapshot
+		if old != nil {
+			if err := json.Unmarshal(old, &history); err != nil {
+				return nil, err
+			}
+		}
+		if len(history) > 0 && history[len(history)-1].sameRatings(snapshot) {
+			return old, nil
+		}
+		history = append(history, snapshot)
+		if len(history) > ratingHistoryLimit {
+			history = history[len(history)-ratingHistoryLimit:]
+		}
+		return history, nil
+	})
+	return errors.Wrap(err, "failed to store rating history")
+}
+
+func (p *Plugin) getRatingHistory(userID string) ([]RatingSnapshot, error) {
+	var history []RatingSnapshot
+	if err := p.pluginAPI.KV.Get(ratingHistoryKey+userID, &history); err != nil {
+		return nil, errors.Wrap(err, "failed to get rating history from kv store")
+	}
+	return history, nil
+}
+
+// parsePeriod parses periods like 30d, 12w, 6m and 1y, or one of the words
+// week, month, quarter, year and all. All is reported as zero.
+func parsePeriod(s string) (time.Duration, bool) {
+	const day = 24 * time.Hour
+	switch strings.ToLower(s) {
+	case "week":
+		return 7 * day, true
+	case "month":
+		return 30 * day, true
+	case "quarter":
+		return 90 * day, true
+	case "year":
+		return 365 * day, true
+	case "all":
+		return 0, true
+	}
+
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	switch s[len(s)-1] {
+	case 'd':
+		return time.Duration(n) * day, true
+	case 'w':
+		return time.Duration(n) * 7 * day, true
+	case 'm':
+		return time.Duration(n) * 30 * day, true
+	case 'y':
+		return time.Duration(n) * 365 * day, true
+	default:
+		return 0, false
+	}
+}
+
+func periodText(period time.Duration) string {
+	if period == 0 {
+		return "all time"
+	}
+	days := int(period / (24 * time.Hour))
+	if days == 1 {
+		return "the last day"
+	}
+	return fmt.Sprintf("the last %d days", days)
+}
+
+type progressEntry struct {
+	username string
+	lichess  string
+	points   []render.Point
+}
+
+func (e progressEntry) change() int {
+	if len(e.points) == 0 {
+		return 0
+	}
+	return int(e.points[len(e.points)-1].Value - e.points[0].Value)
+}
+
+func (p *Plugin) executeProgressCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	perfKey, period := "blitz", defaultProgressPeriod
+	var users []*model.User
+	for _, param := range params {
+		if strings.HasPrefix(param, "@") {
+			user, err := p.userFromMention(param)
+			if err != nil {
+				return ephemeralResponse(err.Error())
+			}
+			users = append(users, user)
+			continue
+		}
+		if key, ok := lichess.ParsePerfKey(param); ok {
+			perfKey = key
+			continue
+		}
+		if d, ok := parsePeriod(param); ok {
+			period = d
+			continue
+		}
+		return ephemeralResponsef("Unknown option %q. Use a perf such as %s, a period such as `30d`, `6m` or `all`, and @users to compare.", param, strings.Join(lichess.PerfKeys, ", "))
+	}
+	if len(users) == 0 {
+		user, err := p.pluginAPI.User.Get(args.UserId)
+		if err != nil {
+			return ephemeralResponse("Failed to load your user.")
+		}
+		users = append(users, user)
+	}
+
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return ephemeralResponse("Failed to load the connected users.")
+	}
+
+	var entries []progressEntry
+	var usernames []string
+	for _, user := range users {
+		lichessUsername, ok := connected[user.Id]
+		if !ok {
+			return ephemeralResponsef("@%s has not connected a Lichess account.", user.Username)
+		}
+		entries = append(entries, progressEntry{username: user.Username, lichess: lichessUsername})
+		usernames = append(usernames, lichessUsername)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	accounts, err := p.getLichessAccounts(ctx, usernames)
+	if err != nil {
+		p.API.LogWarn("failed to fetch Lichess users", "error", err.Error())
+		return ephemeralResponse("Failed to fetch ratings from Lichess.")
+	}
+
+	now := time.Now()
+	from := now.Add(-period)
+	for i, user := range users {
+		history, err := p.getRatingHistory(user.Id)
+		if err != nil {
+			p.API.LogWarn("failed to get rating history", "userID", user.Id, "error", err.Error())
+			return ephemeralResponse("Failed to load the rating history.")
+		}
+		if account, ok := accounts[strings.ToLower(entries[i].lichess)]; ok {
+			history = append(history, newRatingSnapshot(account, model.GetMillis()))
+		}
+		entries[i].points = ratingPoints(history, perfKey, period, from)
+		if period == 0 && len(entries[i].points) > 0 && entries[i].points[0].Time.Before(from) {
+			from = entries[i].points[0].Time
+		}
+	}
+
+	series := make([]render.Series, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry.points) == 0 {
+			continue
+		}
+		series = append(series, render.Series{Label: fmt.Sprintf("@%s (%s)", entry.username, entry.lichess), Points: entry.points})
+	}
+	if len(series) == 0 {
+		return ephemeralResponsef("No %s games found for %s.", lichess.PerfName(perfKey), periodText(period))
+	}
+
+	title := fmt.Sprintf("%s rating, %s", lichess.PerfName(perfKey), periodText(period))
+	chart := render.LineChart(series, render.ChartOptions{Title: title, From: from, To: now})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, chart); err != nil {
+		p.API.LogWarn("failed to encode progress chart", "error", err.Error())
+		return ephemeralResponse("Failed to draw the chart.")
+	}
+
+	fileInfo, err := p.pluginAPI.File.Upload(&buf, "progress.png", args.ChannelId)
+	if err != nil {
+		p.API.LogWarn("failed to upload progress chart", "error", err.Error())
+		return ephemeralResponse("Failed to upload the chart.")
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+		Message:   progressText(title, entries),
+		FileIds:   model.StringArray{fileInfo.Id},
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post progress chart", "error", err.Error())
+		return ephemeralResponse("Failed to post the chart.")
+	}
+	return &model.CommandResponse{}
+}
+
+// ratingPoints turns the history of one perf into chart points. Snapshots
+// are only stored when something changed, so the last rating before the
+// period starts is carried over to its beginning.
+func ratingPoints(history []RatingSnapshot, perfKey string, period time.Duration, from time.Time) []render.Point {
+	var points []render.Point
+	var before *render.Point
+	for _, snapshot := range history {
+		rating, ok := snapshot.Ratings[perfKey]
+		if !ok {
+			continue
+		}
+		point := render.Point{Time: time.Unix(0, snapshot.Time*int64(time.Millisecond)), Value: float64(rating)}
+		if period != 0 && point.Time.Before(from) {
+			before = &render.Point{Time: from, Value: point.Value}
+			continue
+		}
+		points = append(points, point)
+	}
+	if before != nil {
+		points = append([]render.Point{*before}, points...)
+	}
+	return points
+}
+
+func progressText(title string, entries []progressEntry) string {
+	sorted := make([]progressEntry, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry.points) > 0 {
+			sorted = append(sorted, entry)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].change() > sorted[j].change()
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### %s\n", title)
+	b.WriteString("| User | From | To | Change |\n")
+	b.WriteString("|:-----|-----:|---:|-------:|\n")
+	for _, entry := range sorted {
+		first, last := entry.points[0].Value, entry.points[len(entry.points)-1].Value
+		fmt.Fprintf(&b, "| @%s | %d | %d | %s |\n", entry.username, int(first), int(last), formatProgress(entry.change()))
+	}
+	return b.String()
+}
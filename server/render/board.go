@@ -0,0 +1,151 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+)
+
+const (
+	SquareSize  = 56
+	spriteScale = 3
+	spriteSize  = 16 * spriteScale
+	BoardSize   = 8 * SquareSize
+
+	// EvalBarWidth is the width of the bar drawn left of the board by
+	// BoardWithEvalBar.
+	EvalBarWidth = 16
+)
+
+var (
+	lightSquare   = color.RGBA{240, 217, 181, 255}
+	darkSquare    = color.RGBA{181, 136, 99, 255}
+	lastMoveLight = color.RGBA{205, 210, 106, 255}
+	lastMoveDark  = color.RGBA{170, 162, 58, 255}
+	checkSquare   = color.RGBA{224, 80, 64, 255}
+
+	outlineColor    = color.RGBA{20, 20, 20, 255}
+	whitePieceColor = color.RGBA{250, 250, 250, 255}
+	blackPieceColor = color.RGBA{60, 60, 60, 255}
+	blackDetail     = color.RGBA{210, 210, 210, 255}
+
+	evalBarWhite  = color.RGBA{250, 250, 250, 255}
+	evalBarBlack  = color.RGBA{64, 64, 64, 255}
+	evalBarMiddle = color.RGBA{214, 79, 0, 255}
+)
+
+type BoardOptions struct {
+	// Flip draws the board from black's point of view.
+	Flip bool
+	// LastMove highlights the squares of the previous move when set.
+	LastMove *chess.Move
+}
+
+// Board draws the position as an image.
+func Board(pos *chess.Position, opts BoardOptions) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, BoardSize, BoardSize))
+	DrawBoard(img, image.Point{}, pos, opts)
+	return img
+}
+
+// DrawBoard draws the position onto dst with its top left corner at origin.
+func DrawBoard(dst draw.Image, origin image.Point, pos *chess.Position, opts BoardOptions) {
+	checked := chess.NoSquare
+	if pos.InCheck() {
+		checked = pos.KingSquare(pos.Turn)
+	}
+
+	for sq := chess.Square(0); sq < 64; sq++ {
+		rect := squareRect(sq, opts.Flip).Add(origin)
+		light := (sq.File()+sq.Rank())%2 == 1
+
+		fill := darkSquare
+		if light {
+			fill = lightSquare
+		}
+		if opts.LastMove != nil && (opts.LastMove.From == sq || opts.LastMove.To == sq) {
+			fill = lastMoveDark
+			if light {
+				fill = lastMoveLight
+			}
+		}
+		if sq == checked {
+			fill = checkSquare
+		}
+		draw.Draw(dst, rect, &image.Uniform{C: fill}, image.Point{}, draw.Src)
+
+		if pc := pos.Board[sq]; pc != chess.NoPiece {
+			margin := (SquareSize - spriteSize) / 2
+			drawPiece(dst, rect.Min.Add(image.Pt(margin, margin)), pc)
+		}
+	}
+}
+
+// BoardWithEvalBar draws the position with an evaluation bar on its left.
+// white is the share of the bar filled for white, from 0 to 1.
+func BoardWithEvalBar(pos *chess.Position, opts BoardOptions, white float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, EvalBarWidth+BoardSize, BoardSize))
+	DrawEvalBar(img, image.Rect(0, 0, EvalBarWidth, BoardSize), white, opts.Flip)
+	DrawBoard(img, image.Pt(EvalBarWidth, 0), pos, opts)
+	return img
+}
+
+// DrawEvalBar fills r with white's share from the bottom, or from the top
+// when the board is flipped, and marks the middle.
+func DrawEvalBar(dst draw.Image, r image.Rectangle, white float64, flip bool) {
+	if white < 0 {
+		white = 0
+	} else if white > 1 {
+		white = 1
+	}
+	split := r.Max.Y - int(white*float64(r.Dy())+0.5)
+	top, bottom := evalBarBlack, evalBarWhite
+	if flip {
+		split = r.Min.Y + int(white*float64(r.Dy())+0.5)
+		top, bottom = evalBarWhite, evalBarBlack
+	}
+	draw.Draw(dst, image.Rect(r.Min.X, r.Min.Y, r.Max.X, split), &image.Uniform{C: top}, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(r.Min.X, split, r.Max.X, r.Max.Y), &image.Uniform{C: bottom}, image.Point{}, draw.Src)
+
+	middle := r.Min.Y + r.Dy()/2
+	draw.Draw(dst, image.Rect(r.Min.X, middle-1, r.Max.X, middle+1), &image.Uniform{C: evalBarMiddle}, image.Point{}, draw.Src)
+}
+
+func squareRect(sq chess.Square, flip bool) image.Rectangle {
+	col, row := sq.File(), 7-sq.Rank()
+	if flip {
+		col, row = 7-col, 7-row
+	}
+	return image.Rect(col*SquareSize, row*SquareSize, (col+1)*SquareSize, (row+1)*SquareSize)
+}
+
+func drawPiece(dst draw.Image, at image.Point, pc chess.Piece) {
+	mask := sprites[pc.Type()]
+	body, detail := whitePieceColor, outlineColor
+	if pc.Color() == chess.Black {
+		body, detail = blackPieceColor, blackDetail
+	}
+
+	filled := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < 16 && y < 16 && mask[y][x] != ' '
+	}
+
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if !filled(x, y) {
+				continue
+			}
+			c := body
+			switch {
+			case !filled(x-1, y) || !filled(x+1, y) || !filled(x, y-1) || !filled(x, y+1):
+				c = outlineColor
+			case mask[y][x] == '.':
+				c = detail
+			}
+			r := image.Rect(x*spriteScale, y*spriteScale, (x+1)*spriteScale, (y+1)*spriteScale).Add(at)
+			draw.Draw(dst, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+		}
+	}
+}
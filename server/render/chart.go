@@ -0,0 +1,236 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+)
+
+const (
+	ChartWidth  = 720
+	ChartHeight = 360
+
+	chartTextScale = 2
+	chartMargin    = 16
+	lineThickness  = 3
+)
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartGrid       = color.RGBA{228, 228, 228, 255}
+	chartAxis       = color.RGBA{160, 160, 160, 255}
+	chartText       = color.RGBA{64, 64, 64, 255}
+
+	// seriesColors are assigned to the series in order and repeat after
+	// the last one.
+	seriesColors = []color.RGBA{
+		{56, 147, 232, 255},
+		{214, 79, 0, 255},
+		{98, 153, 36, 255},
+		{159, 74, 181, 255},
+		{200, 160, 0, 255},
+		{0, 150, 150, 255},
+		{220, 60, 120, 255},
+		{110, 110, 110, 255},
+	}
+)
+
+// Point is a value at a point in time.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is one line of a chart. Points must be sorted by time.
+type Series struct {
+	Label  string
+	Points []Point
+}
+
+type ChartOptions struct {
+	Title string
+	// From and To are the time range shown. Points outside of it are
+	// clipped.
+	From, To time.Time
+}
+
+// LineChart draws the series as lines over time, with a legend naming them
+// in the order given.
+func LineChart(series []Series, opts ChartOptions) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, ChartWidth, ChartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: chartBackground}, image.Point{}, draw.Src)
+
+	lineHeight := TextHeight(chartTextScale)
+	DrawText(img, image.Pt(chartMargin, chartMargin), opts.Title, chartText, chartTextScale)
+	legendBottom := drawLegend(img, series, chartMargin+lineHeight+10)
+
+	minValue, maxValue := valueRange(series)
+	step := niceStep((maxValue - minValue) / 5)
+	low := math.Floor(minValue/step) * step
+	high := math.Ceil(maxValue/step) * step
+	if high == low {
+		high += step
+	}
+
+	labelWidth := 0
+	for v := low; v <= high; v += step {
+		if w := TextWidth(formatValue(v), chartTextScale); w > labelWidth {
+			labelWidth = w
+		}
+	}
+
+	plot := image.Rect(chartMargin+labelWidth+8, legendBottom+lineHeight+8, ChartWidth-chartMargin-8, ChartHeight-chartMargin-lineHeight-16)
+	from, to := opts.From, opts.To
+	if !to.After(from) {
+		to = from.Add(time.Hour)
+	}
+	x := func(t time.Time) int {
+		frac := float64(t.Sub(from)) / float64(to.Sub(from))
+		return plot.Min.X + int(math.Round(frac*float64(plot.Dx())))
+	}
+	y := func(v float64) int {
+		frac := (v - low) / (high - low)
+		return plot.Max.Y - int(math.Round(frac*float64(plot.Dy())))
+	}
+
+	for v := low; v <= high; v += step {
+		py := y(v)
+		fillRect(img, image.Rect(plot.Min.X, py, plot.Max.X, py+1), chartGrid)
+		label := formatValue(v)
+		DrawText(img, image.Pt(plot.Min.X-8-TextWidth(label, chartTextScale), py-lineHeight/2), label, chartText, chartTextScale)
+	}
+
+	const ticks = 5
+	layout := "Jan 2"
+	if to.Sub(from) > 365*24*time.Hour {
+		layout = "Jan 2006"
+	}
+	for i := 0; i <= ticks; i++ {
+		t := from.Add(time.Duration(float64(to.Sub(from)) * float64(i) / ticks))
+		px := x(t)
+		fillRect(img, image.Rect(px, plot.Max.Y, px+1, plot.Max.Y+5), chartAxis)
+		label := t.Format(layout)
+		lx := px - TextWidth(label, chartTextScale)/2
+		if lx < chartMargin {
+			lx = chartMargin
+		}
+		if max := ChartWidth - chartMargin - TextWidth(label, chartTextScale); lx > max {
+			lx = max
+		}
+		DrawText(img, image.Pt(lx, plot.Max.Y+8), label, chartText, chartTextScale)
+	}
+	fillRect(img, image.Rect(plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y+1), chartAxis)
+
+	clip := img.SubImage(plot.Inset(-lineThickness)).(*image.RGBA)
+	for i, s := range series {
+		c := seriesColors[i%len(seriesColors)]
+		var prev image.Point
+		for j, point := range s.Points {
+			pt := image.Pt(x(point.Time), y(point.Value))
+			if j > 0 {
+				drawLine(clip, prev, pt, c)
+			}
+			prev = pt
+		}
+		if len(s.Points) > 0 {
+			fillRect(clip, image.Rectangle{Min: prev, Max: prev}.Inset(-lineThickness), c)
+		}
+	}
+
+	return img
+}
+
+// drawLegend lays out the series labels in rows starting at top and returns
+// the bottom of the last row.
+func drawLegend(img *image.RGBA, series []Series, top int) int {
+	lineHeight := TextHeight(chartTextScale)
+	x, y := chartMargin, top
+	for i, s := range series {
+		width := lineHeight + 6 + TextWidth(s.Label, chartTextScale)
+		if x > chartMargin && x+width > ChartWidth-chartMargin {
+			x, y = chartMargin, y+lineHeight+6
+		}
+		fillRect(img, image.Rect(x, y, x+lineHeight, y+lineHeight), seriesColors[i%len(seriesColors)])
+		DrawText(img, image.Pt(x+lineHeight+6, y), s.Label, chartText, chartTextScale)
+		x += width + 18
+	}
+	return y + lineHeight
+}
+
+func valueRange(series []Series) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, point := range s.Points {
+			low = math.Min(low, point.Value)
+			high = math.Max(high, point.Value)
+		}
+	}
+	if math.IsInf(low, 1) {
+		return 0, 100
+	}
+	return low, high
+}
+
+// niceStep rounds a raw grid step up to 1, 2, 2.5 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 10
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, f := range []float64{1, 2, 2.5, 5, 10} {
+		if step := f * magnitude; step >= raw {
+			return step
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprint(int(v))
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+func fillRect(dst draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(dst, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+// drawLine draws a thick line with Bresenham's algorithm.
+func drawLine(dst draw.Image, from, to image.Point, c color.Color) {
+	dx, dy := abs(to.X-from.X), -abs(to.Y-from.Y)
+	sx, sy := 1, 1
+	if from.X > to.X {
+		sx = -1
+	}
+	if from.Y > to.Y {
+		sy = -1
+	}
+	half := lineThickness / 2
+	err := dx + dy
+	for p := from; ; {
+		fillRect(dst, image.Rect(p.X-half, p.Y-half, p.X-half+lineThickness, p.Y-half+lineThickness), c)
+		if p == to {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			p.X += sx
+		}
+		if e2 <= dx {
+			err += dx
+			p.Y += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package render
+
+import "github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+
+// Piece sprites are 16x16 masks: '#' is the body of the piece and '.' marks
+// interior detail lines. The outline is derived from the mask when drawing.
+var sprites = map[chess.PieceType][16]string{
+	chess.Pawn: {
+		"                ",
+		"                ",
+		"                ",
+		"       ##       ",
+		"      ####      ",
+		"      ####      ",
+		"       ##       ",
+		"      ####      ",
+		"       ##       ",
+		"      ####      ",
+		"     ######     ",
+		"    ########    ",
+		"   ##########   ",
+		"   ##########   ",
+		"                ",
+		"                ",
+	},
+	chess.Knight: {
+		"                ",
+		"       # #      ",
+		"      #####     ",
+		"     #######    ",
+		"    ##.######   ",
+		"   ##########   ",
+		"  ###########   ",
+		"  ####  #####   ",
+		"   ##  ######   ",
+		"      ######    ",
+		"     #######    ",
+		"    ########    ",
+		"   ##########   ",
+		"   ##########   ",
+		"                ",
+		"                ",
+	},
+	chess.Bishop: {
+		"                ",
+		"       ##       ",
+		"      ####      ",
+		"     ###.##     ",
+		"     ##.###     ",
+		"     #.####     ",
+		"     ######     ",
+		"      ####      ",
+		"      ####      ",
+		"     ######     ",
+		"      ####      ",
+		"    ########    ",
+		"   ##########   ",
+		"   ##########   ",
+		"                ",
+		"                ",
+	},
+	chess.Rook: {
+		"                ",
+		"                ",
+		"   ##  ##  ##   ",
+		"   ##########   ",
+		"   ##########   ",
+		"    ########    ",
+		"     ######     ",
+		"     ######     ",
+		"     ######     ",
+		"     ######     ",
+		"    ########    ",
+		"   ##########   ",
+		"  ############  ",
+		"  ############  ",
+		"                ",
+		"                ",
+	},
+	chess.Queen: {
+		"                ",
+		"  #  #    #  #  ",
+		"  #  # ## #  #  ",
+		"  ## ###### ##  ",
+		"  ############  ",
+		"   ##########   ",
+		"    ########    ",
+		"    ########    ",
+		"     ######     ",
+		"     ######     ",
+		"    ########    ",
+		"   ##########   ",
+		"  ############  ",
+		"  ############  ",
+		"                ",
+		"                ",
+	},
+	chess.King: {
+		"       ##       ",
+		"     ######     ",
+		"       ##       ",
+		"    ########    ",
+		"   ##########   ",
+		"   ##########   ",
+		"   ##########   ",
+		"    ########    ",
+		"     ######     ",
+		"     ######     ",
+		"    ########    ",
+		"   ##########   ",
+		"  ############  ",
+		"  ############  ",
+		"                ",
+		"                ",
+	},
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	gameReportJobKey   = "game_report"
+	gameReportsKey     = "gamereports"
+	gameReportInterval = 5 * time.Minute
+	// gameReportLookback is how far back finished games are looked for.
+	// Lichess filters games by their start, so a longer game is missed.
+	gameReportLookback = 24 * time.Hour
+	// maxGameReportsPerCheck bounds the games reported per user and check,
+	// so a long session doesn't occupy the engine for too long.
+	maxGameReportsPerCheck = 5
+	maxReportedGameIDs     = 100
+	minReportPlies         = 10
+	maxReportedMoves       = 10
+)
+
+// GameReportSubscription is a user who wants a report after each game.
+// Reported holds the IDs of the last games that were reported or skipped.
+type GameReportSubscription struct {
+	OptedInAt int64
+	Reported  []string
+}
+
+// flaggedMove is a move of a game report judged as an inaccuracy, a mistake
+// or a blunder.
+type flaggedMove struct {
+	ply      int
+	judgment moveJudgment
+	san      string
+	best     string
+}
+
+// gameReport is the analysis of one player's moves in a game.
+type gameReport struct {
+	accuracy    float64
+	hasAccuracy bool
+	acpl        int
+	counts      map[moveJudgment]int
+	flagged     []flaggedMove
+}
+
+func (p *Plugin) executeReportCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 || (params[0] != "on" && params[0] != "off") {
+		return ephemeralResponse("Use `/lichess report on` to get a report of your accuracy and mistakes after each Lichess game, or `/lichess report off` to stop.")
+	}
+
+	if params[0] == "off" {
+		err := p.updateGameReportSubscriptions(func(subs map[string]*GameReportSubscription) {
+			delete(subs, args.UserId)
+		})
+		if err != nil {
+			p.API.LogWarn("failed to unsubscribe from game reports", "error", err.Error())
+			return ephemeralResponse("Failed to turn off the game reports.")
+		}
+		return ephemeralResponse("You will not get game reports anymore.")
+	}
+
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return ephemeralResponse("Failed to load the connected users.")
+	}
+	if _, ok := connected[args.UserId]; !ok {
+		return p.notConnectedResponse()
+	}
+
+	err = p.updateGameReportSubscriptions(func(subs map[string]*GameReportSubscription) {
+		if subs[args.UserId] == nil {
+			subs[args.UserId] = &GameReportSubscription{OptedInAt: model.GetMillis()}
+		}
+	})
+	if err != nil {
+		p.API.LogWarn("failed to subscribe to game reports", "error", err.Error())
+		return ephemeralResponse("Failed to turn on the game reports.")
+	}
+	if p.getConfiguration().EnginePath == "" {
+		return ephemeralResponse("You will get a report by direct message after each game you analyse on Lichess.")
+	}
+	return ephemeralResponse("You will get a report by direct message after each of your Lichess games.")
+}
+
+func (p *Plugin) getGameReportSubscriptions() (map[string]*GameReportSubscription, error) {
+	subs := map[string]*GameReportSubscription{}
+	if err := p.pluginAPI.KV.Get(gameReportsKey, &subs); err != nil {
+		return nil, errors.Wrap(err, "failed to get game report subscriptions from kv store")
+	}
+	return subs, nil
+}
+
+func (p *Plugin) updateGameReportSubscriptions(update func(subs map[string]*GameReportSubscription)) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(gameReportsKey, func(old []byte) (interface{}, error) {
+		subs := map[string]*GameReportSubscription{}
+		if old != nil {
+			if err := json.Unmarshal(old, &subs); err != nil {
+				return nil, err
+			}
+		}
+		update(subs)
+		return subs, nil
+	})
+	return errors.Wrap(err, "failed to store game report subscriptions")
+}
+
+// sendGameReports is run by the game report job. It looks for the games the
+// subscribed users finished since the last check and sends them a report.
+func (p *Plugin) sendGameReports(ctx context.Context) {
+	subs, err := p.getGameReportSubscriptions()
+	if err != nil {
+		p.API.LogWarn("failed to get game report subscriptions", "error", err.Error())
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return
+	}
+
+	for userID, sub := range subs {
+		if ctx.Err() != nil {
+			return
+		}
+		lichessUsername, ok := connected[userID]
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, gameAnalysisWait)
+		games, err := p.gamesToReport(ctx, lichessUsername, sub)
+		if err != nil {
+			p.API.LogWarn("failed to get games to report", "lichessUsername", lichessUsername, "error", err.Error())
+		}
+		var reported []string
+		for _, game := range games {
+			done, err := p.sendGameReport(ctx, userID, lichessUsername, game)
+			if err != nil {
+				p.API.LogWarn("failed to send game report", "gameID", game.Id, "error", err.Error())
+				continue
+			}
+			if done {
+				reported = append(reported, game.Id)
+			}
+		}
+		cancel()
+
+		if len(reported) == 0 {
+			continue
+		}
+		err = p.updateGameReportSubscriptions(func(subs map[string]*GameReportSubscription) {
+			if sub := subs[userID]; sub != nil {
+				sub.Reported = append(sub.Reported, reported...)
+				if len(sub.Reported) > maxReportedGameIDs {
+					sub.Reported = sub.Reported[len(sub.Reported)-maxReportedGameIDs:]
+				}
+			}
+		})
+		if err != nil {
+			p.API.LogWarn("failed to store reported games", "userID", userID, "error", err.Error())
+		}
+	}
+}
+
+// gamesToReport returns the finished games of a user that were not reported
+// yet, the oldest first. Without a local engine, games not analysed on
+// Lichess are left out, so they don't hold back the games that can be
+// reported.
+func (p *Plugin) gamesToReport(ctx context.Context, lichessUsername string, sub *GameReportSubscription) ([]*lichess.Game, error) {
+	since := time.Now().Add(-gameReportLookback)
+	if optedIn := time.UnixMilli(sub.OptedInAt); optedIn.After(since) {
+		since = optedIn
+	}
+	reported := map[string]bool{}
+	for _, id := range sub.Reported {
+		reported[id] = true
+	}
+
+	canAnalyse := p.enginePool() != nil
+
+	var games []*lichess.Game
+	filter := lichess.GamesFilter{Since: since, Evals: true}
+	err := p.newStreamingLichessClient().UserGames(ctx, lichessUsername, filter, func(game *lichess.Game) error {
+		if reported[game.Id] || !isGameFinished(game) || game.Status == "aborted" || game.Status == "noStart" {
+			return nil
+		}
+		if len(game.Analysis) == 0 && !canAnalyse {
+			return nil
+		}
+		if len(strings.Fields(game.Moves)) < minReportPlies {
+			return nil
+		}
+		games = append(games, game)
+		return nil
+	})
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].LastMoveAt < games[j].LastMoveAt
+	})
+	if len(games) > maxGameReportsPerCheck {
+		games = games[:maxGameReportsPerCheck]
+	}
+	return games, err
+}
+
+// sendGameReport analyses a game and sends the report to the user who
+// played it. Without a local engine only games analysed on Lichess can be
+// reported, and the others are not done, as the user may still request an
+// analysis. Games that cannot be fully evaluated are skipped silently.
+func (p *Plugin) sendGameReport(ctx context.Context, userID, lichessUsername string, game *lichess.Game) (bool, error) {
+	if len(game.Analysis) == 0 && p.enginePool() == nil {
+		return false, nil
+	}
+	replayed, err := replayLichessGame(game)
+	if err != nil {
+		return false, err
+	}
+	color := chess.White
+	if strings.EqualFold(game.Players.Black.User.Name, lichessUsername) {
+		color = chess.Black
+	}
+
+	evals, source, err := p.evaluateGame(ctx, replayed, game.Analysis)
+	if err != nil {
+		return false, err
+	}
+	scores, ok := gameScores(replayed, evals)
+	if !ok {
+		return true, nil
+	}
+	report := newGameReport(replayed, evals, scores, color)
+
+	post := &model.Post{
+		UserId:  p.botUserID,
+		Message: p.gameReportMessage(game, replayed, evals, report, color, source),
+	}
+	if err := p.pluginAPI.Post.DM(p.botUserID, userID, post); err != nil {
+		return false, errors.Wrap(err, "failed to send game report")
+	}
+	return true, nil
+}
+
+// gameScores returns the evaluation of every position of a game, or false
+// when some are unknown. The final position of a finished game is scored by
+// its result.
+func gameScores(game *chess.Game, evals []*positionEval) ([]evalScore, bool) {
+	scores := make([]evalScore, len(evals))
+	for i, eval := range evals {
+		if eval != nil {
+			scores[i] = eval.Score
+			continue
+		}
+		if i != len(evals)-1 || !game.IsOver() {
+			return nil, false
+		}
+		switch game.Result() {
+		case chess.WhiteWins:
+			scores[i] = evalScore{Mate: 1}
+		case chess.BlackWins:
+			scores[i] = evalScore{Mate: -1}
+		}
+	}
+	return scores, true
+}
+
+func newGameReport(game *chess.Game, evals []*positionEval, scores []evalScore, color chess.Color) *gameReport {
+	report := &gameReport{counts: map[moveJudgment]int{}}
+	report.accuracy, report.hasAccuracy = gameAccuracy(scores, game.StartingPosition().Turn, color)
+
+	positions := game.Positions()
+	sans := game.SANMoves()
+	moves, loss := 0, 0
+	for i, san := range sans {
+		pos := positions[i]
+		if pos.Turn != color {
+			continue
+		}
+		moves++
+		loss += centipawnLoss(scores[i], scores[i+1], color)
+
+		judgment := judgeMove(scores[i], scores[i+1], color)
+		if judgment == goodMove {
+			continue
+		}
+		report.counts[judgment]++
+		flagged := flaggedMove{ply: i, judgment: judgment, san: san}
+		if best := evals[i].BestMove; best != "" {
+			if m, err := pos.ParseMove(best); err == nil && pos.SAN(m) != san {
+				flagged.best = pos.SAN(m)
+			}
+		}
+		report.flagged = append(report.flagged, flagged)
+	}
+	if moves > 0 {
+		report.acpl = int(math.Round(float64(loss) / float64(moves)))
+	}
+	return report
+}
+
+func (p *Plugin) gameReportMessage(game *lichess.Game, replayed *chess.Game, evals []*positionEval, report *gameReport, color chess.Color, source string) string {
+	opponent := game.Players.Black
+	if color == chess.Black {
+		opponent = game.Players.White
+	}
+	mode := "Casual"
+	if game.Rated {
+		mode = "Rated"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Game report: [%s %s game against %s](%s%s)\n", mode, game.Speed, playerText(opponent), p.getConfiguration().getBaseURL(), game.Id)
+	fmt.Fprintf(&b, "%s\n\n", gameResultText(game))
+	if report.hasAccuracy {
+		fmt.Fprintf(&b, "Accuracy **%.0f%%**, average centipawn loss **%d**\n\n", report.accuracy, report.acpl)
+	} else {
+		fmt.Fprintf(&b, "Average centipawn loss **%d**\n\n", report.acpl)
+	}
+	fmt.Fprintf(&b, "%s, %s, %s\n",
+		countText(report.counts[inaccuracy], "inaccuracy", "inaccuracies"),
+		countText(report.counts[mistake], "mistake", "mistakes"),
+		countText(report.counts[blunder], "blunder", "blunders"))
+
+	positions := replayed.Positions()
+	ucis := replayed.UCIMoves()
+	for i, flagged := range report.flagged {
+		if i == maxReportedMoves {
+			fmt.Fprintf(&b, "\n*%d more moves were flagged.*\n", len(report.flagged)-maxReportedMoves)
+			break
+		}
+		pos := positions[flagged.ply]
+		number := fmt.Sprintf("%d.", pos.FullmoveNumber)
+		if pos.Turn == chess.Black {
+			number = fmt.Sprintf("%d...", pos.FullmoveNumber)
+		}
+		fmt.Fprintf(&b, "\n##### %s %s%s %s\n", number, flagged.san, flagged.judgment.symbol(), flagged.judgment)
+		lastMove := ""
+		if flagged.ply > 0 {
+			lastMove = ucis[flagged.ply-1]
+		}
+		b.WriteString(p.evalBoardMarkdown(pos.FEN(), lastMove, color == chess.Black, evals[flagged.ply].Score))
+		b.WriteString("\n\n")
+		if flagged.best != "" {
+			fmt.Fprintf(&b, "Best was **%s**.\n", flagged.best)
+		}
+	}
+	fmt.Fprintf(&b, "\n*Moves evaluated by %s. Use `/lichess report off` to stop these reports.*", source)
+	return b.String()
+}
+
+func countText(n int, singular, plural string) string {
+	if n == 1 {
+		return "**1** " + singular
+	}
+	return fmt.Sprintf("**%d** %s", n, plural)
+}
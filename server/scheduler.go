@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/pkg/errors"
+)
+
+const (
+	schedulerMutexKey = "scheduler_leader"
+	// jobRunsKey stores when each recurring job last finished, so a new
+	// leader carries on with the schedule of the previous one.
+	jobRunsKey = "jobruns"
+	// scheduledJobsKey stores the one-shot jobs that have not run yet.
+	scheduledJobsKey = "scheduledjobs"
+
+	schedulerTick = 10 * time.Second
+)
+
+// ScheduledJob is a one-shot job. Kind selects the handler that runs it,
+// which is given the payload.
+type ScheduledJob struct {
+	Kind    string
+	RunAt   int64
+	Payload string
+}
+
+type recurringJob struct {
+	interval time.Duration
+	run      func(ctx context.Context)
+}
+
+// scheduler runs the background jobs of the plugin. Every node starts a
+// scheduler, but only the node holding its lock runs jobs; the others wait
+// for the lock in case that node goes away. Jobs must be registered before
+// the scheduler starts.
+type scheduler struct {
+	plugin *Plugin
+
+	recurring map[string]recurringJob
+	handlers  map[string]func(ctx context.Context, payload string)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	wake   chan struct{}
+
+	lock    sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+func newScheduler(p *Plugin) *scheduler {
+	return &scheduler{
+		plugin:    p,
+		recurring: map[string]recurringJob{},
+		handlers:  map[string]func(ctx context.Context, payload string){},
+		done:      make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		running:   map[string]bool{},
+	}
+}
+
+// every runs fn every interval, counted from the end of its last run. A job
+// that never ran starts right away. The context of a job is cancelled when
+// the scheduler stops.
+func (s *scheduler) every(key string, interval time.Duration, fn func(ctx context.Context)) {
+	s.recurring[key] = recurringJob{interval: interval, run: fn}
+}
+
+// handle registers the handler of a kind of one-shot jobs.
+func (s *scheduler) handle(kind string, fn func(ctx context.Context, payload string)) {
+	s.handlers[kind] = fn
+}
+
+// scheduleOnce stores a one-shot job to run at runAt on whichever node leads
+// by then. Scheduling a key again replaces its job.
+func (s *scheduler) scheduleOnce(key, kind string, runAt time.Time, payload string) error {
+	if _, ok := s.handlers[kind]; !ok {
+		return errors.Errorf("no handler for jobs of kind %q", kind)
+	}
+	err := s.updateScheduledJobs(func(jobs map[string]*ScheduledJob) {
+		jobs[key] = &ScheduledJob{Kind: kind, RunAt: runAt.UnixMilli(), Payload: payload}
+	})
+	if err != nil {
+		return err
+	}
+	s.poke()
+	return nil
+}
+
+// cancelOnce removes a one-shot job that has not started yet.
+func (s *scheduler) cancelOnce(key string) error {
+	return s.updateScheduledJobs(func(jobs map[string]*ScheduledJob) {
+		delete(jobs, key)
+	})
+}
+
+func (s *scheduler) start() error {
+	mutex, err := cluster.NewMutex(s.plugin.API, schedulerMutexKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to create scheduler mutex")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		defer close(s.done)
+		if err := mutex.LockWithContext(ctx); err != nil {
+			return
+		}
+		defer mutex.Unlock()
+		s.run(ctx)
+	}()
+	return nil
+}
+
+// stop stops scheduling jobs, cancels the running ones and waits for them to
+// return.
+func (s *scheduler) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// poke makes the scheduler look for due jobs now.
+func (s *scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	defer s.wg.Wait()
+
+	lastRuns, err := s.getJobRuns()
+	if err != nil {
+		s.plugin.API.LogWarn("failed to get job runs", "error", err.Error())
+		lastRuns = map[string]int64{}
+	}
+
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		for key, job := range s.recurring {
+			s.lock.Lock()
+			next := time.UnixMilli(lastRuns[key]).Add(job.interval)
+			s.lock.Unlock()
+			if now.Before(next) {
+				continue
+			}
+			job := job
+			s.launch(ctx, key, job.run, func() {
+				finished := time.Now().UnixMilli()
+				s.lock.Lock()
+				lastRuns[key] = finished
+				s.lock.Unlock()
+				if err := s.storeJobRun(key, finished); err != nil {
+					s.plugin.API.LogWarn("failed to store job run", "job", key, "error", err.Error())
+				}
+			})
+		}
+		s.runDueJobs(ctx, now)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// runDueJobs takes the one-shot jobs that are due out of the KV store and
+// runs them. A job is removed before it runs, so it runs at most once even
+// if the node goes away meanwhile. A job whose key is still running from an
+// earlier schedule is left for a later tick.
+func (s *scheduler) runDueJobs(ctx context.Context, now time.Time) {
+	scheduled := map[string]*ScheduledJob{}
+	if err := s.plugin.pluginAPI.KV.Get(scheduledJobsKey, &scheduled); err != nil {
+		s.plugin.API.LogWarn("failed to get scheduled jobs", "error", err.Error())
+		return
+	}
+	anyDue := false
+	s.lock.Lock()
+	for key, job := range scheduled {
+		if s.isDue(key, job, now) {
+			anyDue = true
+			break
+		}
+	}
+	s.lock.Unlock()
+	if !anyDue {
+		return
+	}
+
+	var due map[string]*ScheduledJob
+	err := s.updateScheduledJobs(func(jobs map[string]*ScheduledJob) {
+		due = map[string]*ScheduledJob{}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for key, job := range jobs {
+			if s.isDue(key, job, now) {
+				due[key] = job
+				delete(jobs, key)
+			}
+		}
+	})
+	if err != nil {
+		s.plugin.API.LogWarn("failed to take due jobs", "error", err.Error())
+		return
+	}
+
+	for key, job := range due {
+		handler, ok := s.handlers[job.Kind]
+		if !ok {
+			s.plugin.API.LogWarn("dropped scheduled job without handler", "job", key, "kind", job.Kind)
+			continue
+		}
+		payload := job.Payload
+		s.launch(ctx, onceJobKey(key), func(ctx context.Context) { handler(ctx, payload) }, nil)
+	}
+}
+
+// isDue tells whether a one-shot job can run now. The lock must be held.
+func (s *scheduler) isDue(key string, job *ScheduledJob, now time.Time) bool {
+	return job.RunAt <= now.UnixMilli() && !s.running[onceJobKey(key)]
+}
+
+// onceJobKey keeps the keys of one-shot jobs apart from the recurring ones
+// among the running jobs.
+func onceJobKey(key string) string {
+	return "once_" + key
+}
+
+// launch runs a job in the background unless it is still running, and calls
+// finished after it unless it panicked or ctx was cancelled meanwhile, since
+// the job may then have stopped partway.
+func (s *scheduler) launch(ctx context.Context, key string, run func(ctx context.Context), finished func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running[key] {
+		return
+	}
+	s.running[key] = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		completed := false
+		defer func() {
+			if x := recover(); x != nil {
+				s.plugin.API.LogError("recovered from a panic in a job", "job", key, "error", x)
+			}
+			if completed && finished != nil {
+				finished()
+			}
+			s.lock.Lock()
+			delete(s.running, key)
+			s.lock.Unlock()
+		}()
+		run(ctx)
+		completed = ctx.Err() == nil
+	}()
+}
+
+func (s *scheduler) getJobRuns() (map[string]int64, error) {
+	runs := map[string]int64{}
+	if err := s.plugin.pluginAPI.KV.Get(jobRunsKey, &runs); err != nil {
+		return nil, errors.Wrap(err, "failed to get job runs from kv store")
+	}
+	return runs, nil
+}
+
+func (s *scheduler) storeJobRun(key string, finished int64) error {
+	err := s.plugin.pluginAPI.KV.SetAtomicWithRetries(jobRunsKey, func(old []byte) (interface{}, error) {
+		runs := map[string]int64{}
+		if old != nil {
+			if err := json.Unmarshal(old, &runs); err != nil {
+				return nil, err
+			}
+		}
+		runs[key] = finished
+		return runs, nil
+	})
+	return errors.Wrap(err, "failed to store job run")
+}
+
+func (s *scheduler) updateScheduledJobs(update func(jobs map[string]*ScheduledJob)) error {
+	err := s.plugin.pluginAPI.KV.SetAtomicWithRetries(scheduledJobsKey, func(old []byte) (interface{}, error) {
+		jobs := map[string]*ScheduledJob{}
+		if old != nil {
+			if err := json.Unmarshal(old, &jobs); err != nil {
+				return nil, err
+			}
+		}
+		update(jobs)
+		return jobs, nil
+	})
+	return errors.Wrap(err, "failed to store scheduled jobs")
+}
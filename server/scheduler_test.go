@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV backs the KV store methods of a mocked API with a map.
+type fakeKV struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func newTestPlugin(t *testing.T) (*Plugin, *plugintest.API, *fakeKV) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+
+	kv := &fakeKV{values: map[string][]byte{}}
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		return kv.values[key]
+	}, nil).Maybe()
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) bool {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if options.Atomic && !bytes.Equal(kv.values[key], options.OldValue) {
+			return false
+		}
+		if value == nil {
+			delete(kv.values, key)
+		} else {
+			kv.values[key] = value
+		}
+		return true
+	}, nil).Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.pluginAPI = pluginapi.NewClient(api, nil)
+	return p, api, kv
+}
+
+func scheduledJobs(t *testing.T, p *Plugin) map[string]*ScheduledJob {
+	t.Helper()
+	jobs := map[string]*ScheduledJob{}
+	require.NoError(t, p.pluginAPI.KV.Get(scheduledJobsKey, &jobs))
+	return jobs
+}
+
+func TestSchedulerRunsDueJobs(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	s := newScheduler(p)
+
+	var lock sync.Mutex
+	var ran []string
+	s.handle("test", func(ctx context.Context, payload string) {
+		lock.Lock()
+		defer lock.Unlock()
+		ran = append(ran, payload)
+	})
+
+	now := time.Now()
+	require.NoError(t, s.scheduleOnce("due", "test", now.Add(-time.Second), "due payload"))
+	require.NoError(t, s.scheduleOnce("later", "test", now.Add(time.Hour), "later payload"))
+
+	s.runDueJobs(context.Background(), now)
+	s.wg.Wait()
+
+	assert.Equal(t, []string{"due payload"}, ran)
+	jobs := scheduledJobs(t, p)
+	assert.Len(t, jobs, 1)
+	assert.Contains(t, jobs, "later")
+
+	// A job runs once.
+	s.runDueJobs(context.Background(), now)
+	s.wg.Wait()
+	assert.Len(t, ran, 1)
+}
+
+func TestSchedulerKeepsJobsStillRunning(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	s := newScheduler(p)
+
+	ran := make(chan string, 1)
+	s.handle("test", func(ctx context.Context, payload string) {
+		ran <- payload
+	})
+	now := time.Now()
+	require.NoError(t, s.scheduleOnce("key", "test", now, "second run"))
+
+	// The key is still running from an earlier schedule, so the job stays
+	// stored instead of being dropped.
+	s.running[onceJobKey("key")] = true
+	s.runDueJobs(context.Background(), now)
+	s.wg.Wait()
+	assert.Empty(t, ran)
+	assert.Contains(t, scheduledJobs(t, p), "key")
+
+	delete(s.running, onceJobKey("key"))
+	s.runDueJobs(context.Background(), now)
+	s.wg.Wait()
+	assert.Equal(t, "second run", <-ran)
+	assert.Empty(t, scheduledJobs(t, p))
+}
+
+func TestSchedulerScheduleOnce(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	s := newScheduler(p)
+	s.handle("test", func(ctx context.Context, payload string) {})
+
+	assert.Error(t, s.scheduleOnce("key", "unknown", time.Now(), ""))
+	assert.Empty(t, scheduledJobs(t, p))
+
+	runAt := time.Now().Add(time.Hour)
+	require.NoError(t, s.scheduleOnce("key", "test", runAt, "first"))
+	require.NoError(t, s.scheduleOnce("key", "test", runAt, "second"))
+	jobs := scheduledJobs(t, p)
+	require.Contains(t, jobs, "key")
+	assert.Equal(t, &ScheduledJob{Kind: "test", RunAt: runAt.UnixMilli(), Payload: "second"}, jobs["key"])
+
+	require.NoError(t, s.cancelOnce("key"))
+	assert.Empty(t, scheduledJobs(t, p))
+}
+
+func TestSchedulerLaunchRecovers(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	s := newScheduler(p)
+	api.On("LogError", "recovered from a panic in a job", "job", "panics", "error", "boom").Once()
+
+	finished := false
+	s.launch(context.Background(), "panics", func(ctx context.Context) { panic("boom") }, func() { finished = true })
+	s.wg.Wait()
+
+	// A job that panicked is not counted as run.
+	assert.False(t, finished)
+	assert.Empty(t, s.running)
+}
+
+func TestSchedulerLaunchCancels(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	s := newScheduler(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	s.launch(ctx, "blocks", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	}, nil)
+	<-started
+
+	// The key is running, so it is not launched twice.
+	s.launch(ctx, "blocks", func(ctx context.Context) { t.Error("launched a running job") }, nil)
+
+	cancel()
+	s.wg.Wait()
+}
+
+func TestSchedulerSkipsCancelledRuns(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	s := newScheduler(p)
+
+	started := make(chan struct{})
+	s.every("blocks", time.Hour, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+	<-started
+
+	// The job is cut off by the scheduler stopping, so the next leader runs
+	// it again instead of waiting for its interval.
+	cancel()
+	<-done
+	runs, err := s.getJobRuns()
+	require.NoError(t, err)
+	assert.NotContains(t, runs, "blocks")
+}
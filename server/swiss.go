@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	swissCreateUsage = "Use `/lichess swiss create \"Name\" 10+5 7` with the number of rounds, optionally followed by a variant and `casual`."
+
+	// swissPairingSpread bounds how far apart the games of one round are
+	// created.
+	swissPairingSpread = time.Minute
+
+	// finalStandingsShown bounds the players listed when a Swiss tournament
+	// ends.
+	finalStandingsShown = 30
+)
+
+func (p *Plugin) executeSwissCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 || params[0] != "create" {
+		return ephemeralResponse("Use `/lichess swiss create \"Name\" 10+5 7` to create a 7 round Swiss.")
+	}
+	return p.executeSwissCreateCommand(args, params[1:])
+}
+
+// executeSwissCreateCommand creates a Swiss tournament for the configured
+// Lichess team, as the Lichess account of the user running the command.
+func (p *Plugin) executeSwissCreateCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	client, teamID, resp := p.tournamentCreatorClient(args)
+	if resp != nil {
+		return resp
+	}
+
+	if len(params) == 0 {
+		return ephemeralResponse(swissCreateUsage)
+	}
+	// The name comes first, so a name that looks like an option stays a
+	// name.
+	opts := lichess.SwissOptions{Name: params[0], Rated: true}
+	timeControl := ""
+	for _, param := range params[1:] {
+		if timeControl == "" && strings.Contains(param, "+") {
+			clockTime, increment, err := parseTimeControl(param)
+			if err != nil {
+				return ephemeralResponse(err.Error())
+			}
+			opts.ClockLimit, opts.ClockIncrement, timeControl = int(clockTime*60), increment, param
+			continue
+		}
+		if rounds, err := strconv.Atoi(param); err == nil && rounds > 0 && opts.NbRounds == 0 {
+			opts.NbRounds = rounds
+			continue
+		}
+		if param == "casual" && opts.Rated {
+			opts.Rated = false
+			continue
+		}
+		if v, err := chess.ParseVariant(param); err == nil && opts.Variant == "" {
+			opts.Variant = string(v)
+			continue
+		}
+		return ephemeralResponsef("Unknown option %q. Quote the tournament name if it has spaces.", param)
+	}
+	if opts.Name == "" || timeControl == "" || opts.NbRounds == 0 {
+		return ephemeralResponse(swissCreateUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	swiss, err := client.CreateSwiss(ctx, teamID, opts)
+	if err != nil {
+		p.API.LogWarn("failed to create swiss", "error", err.Error())
+		return ephemeralResponse(lichessErrorText(err))
+	}
+
+	t := &TrackedTournament{
+		ID:          swiss.Id,
+		Kind:        tournamentKindSwiss,
+		Name:        swiss.Name,
+		TimeControl: timeControl,
+		ChannelID:   args.ChannelId,
+		CreatorID:   args.UserId,
+		CreatedAt:   model.GetMillis(),
+		StartsAt:    lichessTime(swiss.StartsAt, 0),
+	}
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		Message:   p.swissMessage(t, swiss),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to post swiss", "error", err.Error())
+		return ephemeralResponsef("Created the tournament at %sswiss/%s, but failed to post it.", p.getConfiguration().getBaseURL(), swiss.Id)
+	}
+	t.PostID = post.Id
+
+	if err := p.trackTournament(t); err != nil {
+		p.API.LogWarn("failed to track swiss", "error", err.Error())
+		return ephemeralResponse("Created the tournament, but failed to follow its rounds.")
+	}
+	return &model.CommandResponse{}
+}
+
+// updateSwiss refreshes the Swiss post, posts the pairings of new rounds in
+// its thread and the final standings once the tournament is over.
+func (p *Plugin) updateSwiss(ctx context.Context, t *TrackedTournament) error {
+	client := p.newStreamingLichessClient()
+	swiss, err := client.Swiss(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := p.updateTournamentPost(t, p.swissMessage(t, swiss)); err != nil {
+		return err
+	}
+	if swiss.Round == 0 {
+		return nil
+	}
+
+	mentions := p.lichessMentions()
+	if err := p.postSwissPairings(ctx, client, t, swiss, mentions); err != nil {
+		return err
+	}
+	if swiss.Status != "finished" {
+		return nil
+	}
+
+	var results []*lichess.SwissResult
+	err = client.SwissResults(ctx, t.ID, maxArchivedStandings, func(result *lichess.SwissResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get swiss results")
+	}
+
+	if err := p.postTournamentResults(t, p.swissStandingsMessage(t, swiss, results, mentions)); err != nil {
+		return err
+	}
+
+	standings := make([]TournamentStanding, len(results))
+	for i, result := range results {
+		standings[i] = TournamentStanding{
+			Rank:        result.Rank,
+			Username:    result.Username,
+			Score:       result.Points,
+			TieBreak:    result.TieBreak,
+			Performance: result.Performance,
+		}
+	}
+	return p.finishTournament(t, standings, swiss.NbPlayers)
+}
+
+// postSwissPairings posts the games of the current round that were not
+// posted yet. Lichess pairs a round all at once, so its games are the ones
+// created last; the games of earlier rounds missed meanwhile, such as while
+// the plugin was down, are recorded without being posted.
+func (p *Plugin) postSwissPairings(ctx context.Context, client *lichess.Client, t *TrackedTournament, swiss *lichess.Swiss, mentions map[string]string) error {
+	posted := make(map[string]bool, len(t.PairedGames))
+	for _, id := range t.PairedGames {
+		posted[id] = true
+	}
+
+	var games []*lichess.Game
+	var newest int64
+	err := client.SwissGames(ctx, t.ID, func(game *lichess.Game) error {
+		if !posted[game.Id] {
+			games = append(games, game)
+		}
+		if game.CreatedAt > newest {
+			newest = game.CreatedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get swiss games")
+	}
+	if len(games) == 0 {
+		return nil
+	}
+
+	var current []*lichess.Game
+	for _, game := range games {
+		if game.CreatedAt >= newest-swissPairingSpread.Milliseconds() {
+			current = append(current, game)
+		}
+	}
+	if len(current) > 0 {
+		reply := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: t.ChannelID,
+			RootId:    t.PostID,
+			Message:   p.swissPairingsMessage(swiss, current, mentions),
+		}
+		if err := p.pluginAPI.Post.CreatePost(reply); err != nil {
+			return errors.Wrap(err, "failed to post swiss pairings")
+		}
+	}
+
+	for _, game := range games {
+		t.PairedGames = append(t.PairedGames, game.Id)
+	}
+	t.Round = swiss.Round
+	return p.saveTournament(t)
+}
+
+func (p *Plugin) swissMessage(t *TrackedTournament, swiss *lichess.Swiss) string {
+	url := fmt.Sprintf("%sswiss/%s", p.getConfiguration().getBaseURL(), swiss.Id)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### [%s](%s)\n", swiss.Name, url)
+	fmt.Fprintf(&b, "%s Swiss, %d rounds", t.TimeControl, swiss.NbRounds)
+	if swiss.Variant != "" && swiss.Variant != string(chess.Standard) {
+		if v, err := chess.ParseVariant(swiss.Variant); err == nil {
+			fmt.Fprintf(&b, ", %s", v.Name())
+		}
+	}
+	if !swiss.Rated {
+		b.WriteString(", casual")
+	}
+	fmt.Fprintf(&b, ". %d players.\n", swiss.NbPlayers)
+
+	switch swiss.Status {
+	case "finished":
+		b.WriteString("**Finished.** The final standings are in the thread.\n")
+	case "started":
+		fmt.Fprintf(&b, "**Round %d of %d**", swiss.Round, swiss.NbRounds)
+		if swiss.NbOngoing > 0 {
+			fmt.Fprintf(&b, ", %d games in progress.", swiss.NbOngoing)
+		} else if swiss.NextRound.In > 0 {
+			fmt.Fprintf(&b, " finished, the next round starts in %s.", formatWait(swiss.NextRound.In))
+		}
+		b.WriteString(" Pairings are posted in the thread.\n")
+	default:
+		if swiss.NextRound.In > 0 {
+			fmt.Fprintf(&b, "**Starts in %s.** ", formatWait(swiss.NextRound.In))
+		}
+		fmt.Fprintf(&b, "[Join the tournament](%s)\n", url)
+	}
+	return b.String()
+}
+
+func (p *Plugin) swissPairingsMessage(swiss *lichess.Swiss, games []*lichess.Game, mentions map[string]string) string {
+	baseURL := p.getConfiguration().getBaseURL()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Round %d pairings\n", swiss.Round)
+	for _, game := range games {
+		white, black := game.Players.White, game.Players.Black
+		result := gameResult(game)
+		if !isGameFinished(game) {
+			result = "playing"
+		}
+		fmt.Fprintf(&b, "* %s - %s: [%s](%s%s)\n",
+			playerName(white.User.Name, white.User.Title, mentions),
+			playerName(black.User.Name, black.User.Title, mentions),
+			result, baseURL, game.Id)
+	}
+	return b.String()
+}
+
+func (p *Plugin) swissStandingsMessage(t *TrackedTournament, swiss *lichess.Swiss, results []*lichess.SwissResult, mentions map[string]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### :trophy: %s final standings\n", t.Name)
+	if len(results) == 0 {
+		b.WriteString("Nobody played.")
+		return b.String()
+	}
+	b.WriteString("| # | Player | Points | Tie-break | Performance |\n")
+	b.WriteString("|--:|:-------|-------:|----------:|------------:|\n")
+	shown := results
+	if len(shown) > finalStandingsShown {
+		shown = shown[:finalStandingsShown]
+	}
+	for _, result := range shown {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %d |\n",
+			placeEmoji(result.Rank), playerName(result.Username, result.Title, mentions),
+			formatPoints(result.Points), formatPoints(result.TieBreak), result.Performance)
+	}
+	if swiss.NbPlayers > len(shown) {
+		fmt.Fprintf(&b, "\n%d more players on [Lichess](%sswiss/%s).", swiss.NbPlayers-len(shown), p.getConfiguration().getBaseURL(), swiss.Id)
+	}
+	return b.String()
+}
+
+// formatPoints prints half points as .5 and drops the fraction otherwise.
+func formatPoints(points float64) string {
+	return strconv.FormatFloat(points, 'f', -1, 64)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	teamLinksKey     = "lichessteamlinks"
+	teamSyncJobKey   = "team_sync"
+	teamSyncInterval = 6 * time.Hour
+	// maxUnconnectedListed bounds the Lichess team members without a
+	// Mattermost account listed in a report.
+	maxUnconnectedListed = 20
+)
+
+// TeamLink maps a Lichess team to the members of a Mattermost channel, or of
+// a whole Mattermost team when ChannelID is empty. Sync reports are posted to
+// ReportChannelID, only when they changed since LastReport.
+type TeamLink struct {
+	LichessTeamID   string
+	TeamID          string
+	ChannelID       string
+	ReportChannelID string
+	AutoAdd         bool
+	CreatorID       string
+	CreatedAt       int64
+	LastReport      string
+}
+
+func (l *TeamLink) same(other *TeamLink) bool {
+	return l.LichessTeamID == other.LichessTeamID && l.TeamID == other.TeamID && l.ChannelID == other.ChannelID
+}
+
+// teamSyncResult lists the differences between a Lichess team and the
+// Mattermost users it is linked to, by Mattermost username unless noted.
+type teamSyncResult struct {
+	missingOnLichess    []string
+	missingInMattermost []string
+	added               []string
+	// unconnected are the Lichess usernames of members without a connected
+	// Mattermost account.
+	unconnected []string
+}
+
+func (p *Plugin) getTeamLinks() ([]*TeamLink, error) {
+	var links []*TeamLink
+	if err := p.pluginAPI.KV.Get(teamLinksKey, &links); err != nil {
+		return nil, errors.Wrap(err, "failed to get team links from kv store")
+	}
+	return links, nil
+}
+
+// updateTeamLinks applies f to the stored team links and saves the result.
+func (p *Plugin) updateTeamLinks(f func(links []*TeamLink) ([]*TeamLink, error)) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(teamLinksKey, func(old []byte) (interface{}, error) {
+		var links []*TeamLink
+		if old != nil {
+			if err := json.Unmarshal(old, &links); err != nil {
+				return nil, err
+			}
+		}
+		links, err := f(links)
+		if err != nil {
+			return nil, err
+		}
+		if links == nil {
+			links = []*TeamLink{}
+		}
+		return links, nil
+	})
+	return errors.Wrap(err, "failed to store team links")
+}
+
+// syncTeams is run by the team sync job.
+func (p *Plugin) syncTeams(ctx context.Context) {
+	links, err := p.getTeamLinks()
+	if err != nil {
+		p.API.LogWarn("failed to get team links", "error", err.Error())
+		return
+	}
+
+	for _, link := range links {
+		if ctx.Err() != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		result, err := p.syncTeam(ctx, link)
+		cancel()
+		if err != nil {
+			p.API.LogWarn("failed to sync Lichess team", "lichessTeamID", link.LichessTeamID, "error", err.Error())
+			continue
+		}
+
+		report := p.teamSyncReport(link, result)
+		if report == link.LastReport {
+			continue
+		}
+
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: link.ReportChannelID,
+			Message:   report,
+		}
+		if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+			p.API.LogWarn("failed to post team sync report", "channelID", link.ReportChannelID, "error", err.Error())
+			continue
+		}
+
+		err = p.updateTeamLinks(func(links []*TeamLink) ([]*TeamLink, error) {
+			for _, l := range links {
+				if l.same(link) {
+					l.LastReport = report
+				}
+			}
+			return links, nil
+		})
+		if err != nil {
+			p.API.LogWarn("failed to store team sync report", "error", err.Error())
+		}
+	}
+}
+
+// syncTeam compares the members of the Lichess team with the connected users
+// of the linked channel or team, adding missing ones when the link asks for
+// it.
+func (p *Plugin) syncTeam(ctx context.Context, link *TeamLink) (*teamSyncResult, error) {
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		return nil, err
+	}
+	byLichessID := make(map[string]string, len(connected))
+	for userID, lichessUsername := range connected {
+		byLichessID[strings.ToLower(lichessUsername)] = userID
+	}
+
+	inLichessTeam := map[string]bool{}
+	result := &teamSyncResult{}
+	err = p.newStreamingLichessClient().TeamMembers(ctx, link.LichessTeamID, func(member *lichess.TeamMember) error {
+		id := strings.ToLower(member.Id)
+		inLichessTeam[id] = true
+		if _, ok := byLichessID[id]; !ok {
+			name := member.Name
+			if name == "" {
+				name = member.Username
+			}
+			if name == "" {
+				name = member.Id
+			}
+			result.unconnected = append(result.unconnected, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get members of Lichess team %s", link.LichessTeamID)
+	}
+
+	for lichessID, userID := range byLichessID {
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil || user.DeleteAt != 0 || user.IsBot {
+			continue
+		}
+
+		linked := p.isLinkedMember(link, userID)
+		switch {
+		case linked && !inLichessTeam[lichessID]:
+			result.missingOnLichess = append(result.missingOnLichess, user.Username)
+		case !linked && inLichessTeam[lichessID]:
+			if link.AutoAdd {
+				if err := p.addLinkedMember(link, userID); err != nil {
+					p.API.LogWarn("failed to add Lichess team member", "userID", userID, "error", err.Error())
+				} else {
+					result.added = append(result.added, user.Username)
+					continue
+				}
+			}
+			result.missingInMattermost = append(result.missingInMattermost, user.Username)
+		}
+	}
+
+	sort.Strings(result.missingOnLichess)
+	sort.Strings(result.missingInMattermost)
+	sort.Strings(result.added)
+	sort.Strings(result.unconnected)
+	return result, nil
+}
+
+func (p *Plugin) isLinkedMember(link *TeamLink, userID string) bool {
+	if link.ChannelID != "" {
+		member, appErr := p.API.GetChannelMember(link.ChannelID, userID)
+		return appErr == nil && member != nil
+	}
+	member, appErr := p.API.GetTeamMember(link.TeamID, userID)
+	return appErr == nil && member.DeleteAt == 0
+}
+
+func (p *Plugin) addLinkedMember(link *TeamLink, userID string) error {
+	if link.ChannelID != "" {
+		if _, appErr := p.API.AddChannelMember(link.ChannelID, userID); appErr != nil {
+			return errors.Wrap(appErr, "failed to add channel member")
+		}
+		return nil
+	}
+	if _, appErr := p.API.CreateTeamMember(link.TeamID, userID); appErr != nil {
+		return errors.Wrap(appErr, "failed to add team member")
+	}
+	return nil
+}
+
+func (p *Plugin) teamLinkTarget(link *TeamLink) string {
+	if link.ChannelID != "" {
+		if channel, appErr := p.API.GetChannel(link.ChannelID); appErr == nil {
+			return "~" + channel.Name
+		}
+		return "a deleted channel"
+	}
+	if team, appErr := p.API.GetTeam(link.TeamID); appErr == nil {
+		return "the " + team.DisplayName + " team"
+	}
+	return "a deleted team"
+}
+
+func (p *Plugin) teamSyncReport(link *TeamLink, result *teamSyncResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Lichess team [%s](%steam/%s) and %s\n", link.LichessTeamID, p.getConfiguration().getBaseURL(), link.LichessTeamID, p.teamLinkTarget(link))
+
+	if len(result.missingOnLichess) == 0 && len(result.missingInMattermost) == 0 && len(result.added) == 0 {
+		b.WriteString("All connected members are in sync.\n")
+	}
+	if len(result.added) > 0 {
+		fmt.Fprintf(&b, "* Added Lichess team members: %s\n", mentionList(result.added))
+	}
+	if len(result.missingInMattermost) > 0 {
+		fmt.Fprintf(&b, "* In the Lichess team but not in %s: %s\n", p.teamLinkTarget(link), mentionList(result.missingInMattermost))
+	}
+	if len(result.missingOnLichess) > 0 {
+		fmt.Fprintf(&b, "* Not in the Lichess team yet: %s\n", mentionList(result.missingOnLichess))
+	}
+	if n := len(result.unconnected); n > 0 {
+		listed := result.unconnected
+		if n > maxUnconnectedListed {
+			listed = listed[:maxUnconnectedListed]
+		}
+		fmt.Fprintf(&b, "* %d Lichess team members have not connected a Mattermost account: %s", n, strings.Join(listed, ", "))
+		if n > len(listed) {
+			fmt.Fprintf(&b, " and %d more", n-len(listed))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func mentionList(usernames []string) string {
+	mentions := make([]string, len(usernames))
+	for i, username := range usernames {
+		mentions[i] = "@" + username
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// parseLichessTeamID accepts a team ID or a link to the team page.
+func parseLichessTeamID(s string) string {
+	s = strings.TrimSuffix(s, "/")
+	if i := strings.LastIndex(s, "/team/"); i >= 0 {
+		s = s[i+len("/team/"):]
+	}
+	return strings.ToLower(s)
+}
+
+func (p *Plugin) executeTeamCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 {
+		return ephemeralResponse("Use `link`, `unlink`, `list` or `sync`.")
+	}
+	if !p.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionManageTeam) {
+		return ephemeralResponse("Only team admins can manage Lichess team links.")
+	}
+
+	if params[0] == "list" {
+		return p.executeTeamListCommand(args)
+	}
+
+	link := &TeamLink{
+		TeamID:          args.TeamId,
+		ChannelID:       args.ChannelId,
+		ReportChannelID: args.ChannelId,
+		CreatorID:       args.UserId,
+		CreatedAt:       model.GetMillis(),
+	}
+	for _, param := range params[1:] {
+		switch param {
+		case "--team":
+			link.ChannelID = ""
+		case "--autoadd":
+			link.AutoAdd = true
+		default:
+			if link.LichessTeamID != "" {
+				return ephemeralResponsef("Unknown option %q.", param)
+			}
+			link.LichessTeamID = parseLichessTeamID(param)
+		}
+	}
+	if link.LichessTeamID == "" {
+		return ephemeralResponse("Missing the Lichess team ID.")
+	}
+
+	switch params[0] {
+	case "link":
+		return p.executeTeamLinkCommand(link)
+	case "unlink":
+		return p.executeTeamUnlinkCommand(link)
+	case "sync":
+		return p.executeTeamSyncCommand(link)
+	default:
+		return ephemeralResponsef("Unknown team command %q. Use `link`, `unlink`, `list` or `sync`.", params[0])
+	}
+}
+
+func (p *Plugin) executeTeamLinkCommand(link *TeamLink) *model.CommandResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// Reading the first member is enough to tell whether the team exists.
+	errFound := errors.New("found")
+	err := p.newStreamingLichessClient().TeamMembers(ctx, link.LichessTeamID, func(*lichess.TeamMember) error {
+		return errFound
+	})
+	if lichess.IsNotFound(err) {
+		return ephemeralResponsef("Lichess team %q does not exist.", link.LichessTeamID)
+	} else if err != nil && err != errFound {
+		p.API.LogWarn("failed to check Lichess team", "lichessTeamID", link.LichessTeamID, "error", err.Error())
+		return ephemeralResponse("Failed to reach Lichess.")
+	}
+
+	err = p.updateTeamLinks(func(links []*TeamLink) ([]*TeamLink, error) {
+		for i, l := range links {
+			if l.same(link) {
+				links[i] = link
+				return links, nil
+			}
+		}
+		return append(links, link), nil
+	})
+	if err != nil {
+		p.API.LogWarn("failed to link Lichess team", "error", err.Error())
+		return ephemeralResponse("Failed to link the team.")
+	}
+
+	text := fmt.Sprintf("Linked Lichess team `%s` to %s. Differences are reported in this channel", link.LichessTeamID, p.teamLinkTarget(link))
+	if link.AutoAdd {
+		text += " and connected members of the Lichess team are added automatically"
+	}
+	return ephemeralResponse(text + ".")
+}
+
+func (p *Plugin) executeTeamUnlinkCommand(link *TeamLink) *model.CommandResponse {
+	found := false
+	err := p.updateTeamLinks(func(links []*TeamLink) ([]*TeamLink, error) {
+		kept := links[:0]
+		for _, l := range links {
+			if l.same(link) {
+				found = true
+				continue
+			}
+			kept = append(kept, l)
+		}
+		return kept, nil
+	})
+	if err != nil {
+		p.API.LogWarn("failed to unlink Lichess team", "error", err.Error())
+		return ephemeralResponse("Failed to unlink the team.")
+	}
+	if !found {
+		return ephemeralResponsef("Lichess team `%s` is not linked to %s.", link.LichessTeamID, p.teamLinkTarget(link))
+	}
+	return ephemeralResponsef("Unlinked Lichess team `%s` from %s.", link.LichessTeamID, p.teamLinkTarget(link))
+}
+
+func (p *Plugin) executeTeamListCommand(args *model.CommandArgs) *model.CommandResponse {
+	links, err := p.getTeamLinks()
+	if err != nil {
+		p.API.LogWarn("failed to get team links", "error", err.Error())
+		return ephemeralResponse("Failed to load the team links.")
+	}
+
+	var b strings.Builder
+	for _, link := range links {
+		if link.TeamID != args.TeamId {
+			continue
+		}
+		fmt.Fprintf(&b, "* `%s` linked to %s", link.LichessTeamID, p.teamLinkTarget(link))
+		if link.AutoAdd {
+			b.WriteString(", adding members automatically")
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return ephemeralResponse("No Lichess teams are linked in this team.")
+	}
+	return ephemeralResponse("#### Linked Lichess teams\n" + b.String())
+}
+
+func (p *Plugin) executeTeamSyncCommand(link *TeamLink) *model.CommandResponse {
+	links, err := p.getTeamLinks()
+	if err != nil {
+		p.API.LogWarn("failed to get team links", "error", err.Error())
+		return ephemeralResponse("Failed to load the team links.")
+	}
+	var stored *TeamLink
+	for _, l := range links {
+		if l.same(link) {
+			stored = l
+		}
+	}
+	if stored == nil {
+		return ephemeralResponsef("Lichess team `%s` is not linked to %s.", link.LichessTeamID, p.teamLinkTarget(link))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := p.syncTeam(ctx, stored)
+	if err != nil {
+		p.API.LogWarn("failed to sync Lichess team", "lichessTeamID", stored.LichessTeamID, "error", err.Error())
+		return ephemeralResponse("Failed to sync the team.")
+	}
+	return ephemeralResponse(p.teamSyncReport(stored, result))
+}
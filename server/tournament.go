@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	tournamentKey           = "tournament_"
+	tournamentsKey          = "tournaments"
+	activeTournamentsKey    = "activetournaments"
+	tournamentWatchJobKey   = "tournament_watch"
+	tournamentWatchInterval = time.Minute
+	// standingsShown is the number of players listed in live standings.
+	standingsShown = 10
+	// maxArchivedStandings bounds the final standings kept per tournament.
+	maxArchivedStandings = 200
+
+	tournamentKindArena = "arena"
+	tournamentKindSwiss = "swiss"
+)
+
+// TrackedTournament is a Lichess tournament followed in a channel. Its post
+// shows the live standings until the tournament finishes, after which it is
+// kept as the archive of the event. CreatedAt is when it was followed,
+// StartsAt when it starts on Lichess and EndsAt when an arena is due to end,
+// or 0 when unknown.
+type TrackedTournament struct {
+	ID          string
+	Kind        string
+	Name        string
+	TimeControl string
+	ChannelID   string
+	PostID      string
+	// ResultsPostID is the reply announcing the results, once posted.
+	ResultsPostID string
+	CreatorID     string
+	CreatedAt     int64
+	StartsAt      int64
+	EndsAt        int64
+	Finished      bool
+	FinishedAt    int64
+	// Round and PairedGames track the Swiss pairings already posted.
+	Round       int
+	PairedGames []string
+	NbPlayers   int
+	Standings   []TournamentStanding
+}
+
+// TournamentStanding is the final result of a player. UserID is set when the
+// Lichess account was connected to Mattermost when the tournament ended.
+type TournamentStanding struct {
+	Rank        int
+	Username    string
+	UserID      string
+	Score       float64
+	TieBreak    float64
+	Performance int
+}
+
+func (p *Plugin) getTournament(id string) (*TrackedTournament, error) {
+	var t *TrackedTournament
+	if err := p.pluginAPI.KV.Get(tournamentKey+id, &t); err != nil {
+		return nil, errors.Wrap(err, "failed to get tournament from kv store")
+	}
+	return t, nil
+}
+
+func (p *Plugin) saveTournament(t *TrackedTournament) error {
+	if _, err := p.pluginAPI.KV.Set(tournamentKey+t.ID, t); err != nil {
+		return errors.Wrap(err, "failed to store tournament")
+	}
+	return nil
+}
+
+func (p *Plugin) getActiveTournaments() ([]string, error) {
+	var ids []string
+	if err := p.pluginAPI.KV.Get(activeTournamentsKey, &ids); err != nil {
+		return nil, errors.Wrap(err, "failed to get active tournaments from kv store")
+	}
+	return ids, nil
+}
+
+// setTournamentActive adds or removes a tournament from the ones the watch
+// job updates.
+func (p *Plugin) setTournamentActive(id string, active bool) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(activeTournamentsKey, func(old []byte) (interface{}, error) {
+		var ids []string
+		if old != nil {
+			if err := json.Unmarshal(old, &ids); err != nil {
+				return nil, err
+			}
+		}
+		kept := []string{}
+		for _, existing := range ids {
+			if existing != id {
+				kept = append(kept, existing)
+			}
+		}
+		if active {
+			kept = append(kept, id)
+		}
+		return kept, nil
+	})
+	return errors.Wrap(err, "failed to store active tournaments")
+}
+
+// getTournamentIDs returns the IDs of every tournament the plugin followed,
+// oldest first.
+func (p *Plugin) getTournamentIDs() ([]string, error) {
+	var ids []string
+	if err := p.pluginAPI.KV.Get(tournamentsKey, &ids); err != nil {
+		return nil, errors.Wrap(err, "failed to get tournaments from kv store")
+	}
+	return ids, nil
+}
+
+func (p *Plugin) addTournamentID(id string) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(tournamentsKey, func(old []byte) (interface{}, error) {
+		var ids []string
+		if old != nil {
+			if err := json.Unmarshal(old, &ids); err != nil {
+				return nil, err
+			}
+		}
+		for _, existing := range ids {
+			if existing == id {
+				return ids, nil
+			}
+		}
+		return append(ids, id), nil
+	})
+	return errors.Wrap(err, "failed to store tournament index")
+}
+
+// trackTournament stores a new tournament and starts updating its post.
+// Arenas follow their results stream, Swiss tournaments are updated by the
+// watch job.
+func (p *Plugin) trackTournament(t *TrackedTournament) error {
+	if err := p.saveTournament(t); err != nil {
+		return err
+	}
+	if err := p.addTournamentID(t.ID); err != nil {
+		return err
+	}
+	if t.Kind == tournamentKindArena {
+		expiresAt := t.startTime() + arenaPostLifetime.Milliseconds()
+		if t.EndsAt != 0 {
+			expiresAt = t.EndsAt + arenaPostMargin.Milliseconds()
+		}
+		return p.arenas.add(LivePost{
+			PostID:    t.PostID,
+			ChannelID: t.ChannelID,
+			Key:       t.ID,
+			ExpiresAt: expiresAt,
+		})
+	}
+	return p.setTournamentActive(t.ID, true)
+}
+
+// startTime is when the tournament starts, or when it was followed for
+// tournaments stored without a start time.
+func (t *TrackedTournament) startTime() int64 {
+	if t.StartsAt == 0 {
+		return t.CreatedAt
+	}
+	return t.StartsAt
+}
+
+// watchTournaments is run by the tournament watch job and refreshes the
+// posts of every Swiss tournament that has not finished yet.
+func (p *Plugin) watchTournaments(ctx context.Context) {
+	ids, err := p.getActiveTournaments()
+	if err != nil {
+		p.API.LogWarn("failed to get active tournaments", "error", err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		t, err := p.getTournament(id)
+		if err != nil {
+			p.API.LogWarn("failed to get tournament", "tournamentID", id, "error", err.Error())
+			continue
+		}
+		if t == nil || t.Finished {
+			if err := p.setTournamentActive(id, false); err != nil {
+				p.API.LogWarn("failed to stop watching tournament", "tournamentID", id, "error", err.Error())
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		switch t.Kind {
+		case tournamentKindSwiss:
+			err = p.updateSwiss(ctx, t)
+		default:
+			err = errors.Errorf("unknown tournament kind %q", t.Kind)
+		}
+		cancel()
+
+		if lichess.IsNotFound(err) {
+			p.API.LogWarn("tournament no longer exists", "tournamentID", id)
+			if err := p.setTournamentActive(id, false); err != nil {
+				p.API.LogWarn("failed to stop watching tournament", "tournamentID", id, "error", err.Error())
+			}
+		} else if err != nil {
+			p.API.LogWarn("failed to update tournament", "tournamentID", id, "error", err.Error())
+		}
+	}
+}
+
+// updateTournamentPost replaces the message of the tournament post if it
+// changed.
+func (p *Plugin) updateTournamentPost(t *TrackedTournament, message string) error {
+	post, err := p.pluginAPI.Post.GetPost(t.PostID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get tournament post")
+	}
+	if post.Message == message {
+		return nil
+	}
+	post.Message = message
+	return errors.Wrap(p.pluginAPI.Post.UpdatePost(post), "failed to update tournament post")
+}
+
+// postTournamentResults replies to the tournament post with its results.
+// The reply is recorded, so it isn't posted again when archiving the
+// tournament fails and is retried.
+func (p *Plugin) postTournamentResults(t *TrackedTournament, message string) error {
+	if t.ResultsPostID != "" {
+		return nil
+	}
+	reply := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: t.ChannelID,
+		RootId:    t.PostID,
+		Message:   message,
+	}
+	if err := p.pluginAPI.Post.CreatePost(reply); err != nil {
+		return errors.Wrap(err, "failed to post tournament results")
+	}
+	t.ResultsPostID = reply.Id
+	return p.saveTournament(t)
+}
+
+// finishTournament archives the final standings and stops the watch job
+// from updating the tournament.
+func (p *Plugin) finishTournament(t *TrackedTournament, standings []TournamentStanding, nbPlayers int) error {
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		return err
+	}
+	userIDs := make(map[string]string, len(connected))
+	for userID, lichessUsername := range connected {
+		userIDs[strings.ToLower(lichessUsername)] = userID
+	}
+	for i := range standings {
+		standings[i].UserID = userIDs[strings.ToLower(standings[i].Username)]
+	}
+
+	t.Standings = standings
+	t.NbPlayers = nbPlayers
+	t.Finished = true
+	t.FinishedAt = time.Now().UnixMilli()
+	if err := p.saveTournament(t); err != nil {
+		return err
+	}
+	return p.setTournamentActive(t.ID, false)
+}
+
+// playerName shows a Lichess player with the Mattermost user who connected
+// the account, if any.
+func playerName(username, title string, mentions map[string]string) string {
+	name := username
+	if title != "" {
+		name = title + " " + username
+	}
+	if mention, ok := mentions[strings.ToLower(username)]; ok {
+		return fmt.Sprintf("%s (%s)", mention, name)
+	}
+	return name
+}
+
+// placeEmoji returns the medal for the first three places.
+func placeEmoji(rank int) string {
+	switch rank {
+	case 1:
+		return ":1st_place_medal:"
+	case 2:
+		return ":2nd_place_medal:"
+	case 3:
+		return ":3rd_place_medal:"
+	default:
+		return fmt.Sprintf("%d.", rank)
+	}
+}
+
+// tournamentCreatorClient checks that the user may create tournaments for
+// the configured Lichess team and returns a client acting as their Lichess
+// account. Otherwise it returns the response explaining why not.
+func (p *Plugin) tournamentCreatorClient(args *model.CommandArgs) (*lichess.Client, string, *model.CommandResponse) {
+	if !p.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionManageTeam) {
+		return nil, "", ephemeralResponse("Only team admins can create tournaments.")
+	}
+	teamID := p.getConfiguration().LichessTeamID
+	if teamID == "" {
+		return nil, "", ephemeralResponse("A system admin has to configure the Lichess team of this server first.")
+	}
+
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return nil, "", ephemeralResponse("Failed to load the connected users.")
+	}
+	if _, ok := connected[args.UserId]; !ok {
+		return nil, "", p.notConnectedResponse()
+	}
+	client, err := p.newUserLichessClient(args.UserId)
+	if err != nil {
+		p.API.LogWarn("failed to create Lichess client", "error", err.Error())
+		return nil, "", p.notConnectedResponse()
+	}
+	return client, teamID, nil
+}
+
+// parseTimeControl parses a clock like 3+2 into its initial time in minutes
+// and increment in seconds.
+func parseTimeControl(s string) (float64, int, error) {
+	parts := strings.Split(s, "+")
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid time control %q, use minutes+increment like 3+2", s)
+	}
+	var minutes float64
+	var increment int
+	if _, err := fmt.Sscanf(parts[0], "%g", &minutes); err != nil || minutes < 0 {
+		return 0, 0, errors.Errorf("invalid initial time %q", parts[0])
+	}
+	if _, err := fmt.Sscanf(parts[1], "%d", &increment); err != nil || increment < 0 {
+		return 0, 0, errors.Errorf("invalid increment %q", parts[1])
+	}
+	return minutes, increment, nil
+}
+
+// lichessErrorText explains a failed request to a user. Lichess reports
+// invalid input with a 400 and a JSON description of the problem.
+func lichessErrorText(err error) string {
+	apiErr, ok := errors.Cause(err).(*lichess.APIError)
+	if !ok {
+		return "Failed to reach Lichess."
+	}
+	switch apiErr.StatusCode {
+	case 400:
+		return "Lichess rejected the request: " + apiErr.Message
+	case 401, 403:
+		return "Lichess did not allow the request. Reconnect your Lichess account to grant the plugin the required permissions."
+	case 429:
+		return "Lichess is rate limiting requests, please try again in a minute."
+	default:
+		return "Lichess returned an error: " + apiErr.Error()
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	tvRunnerName   = "tv"
+	tvPostLifetime = 2 * time.Hour
+
+	tvEndedNote = "\n\n*Lichess TV has ended here. Use `/lichess tv` to tune in again.*"
+)
+
+// tvState is what is known about the featured game of a TV channel.
+type tvState struct {
+	game       lichess.TVFeedData
+	fen        string
+	lastMove   string
+	whiteClock int
+	blackClock int
+}
+
+// streamTV follows a TV channel and publishes its featured game. The feed
+// never ends on its own, so it is retried until ctx is done.
+func (p *Plugin) streamTV(ctx context.Context, tvChannel string, publish func(message string)) bool {
+	client := p.newStreamingLichessClient()
+	var state *tvState
+	for {
+		err := client.TVFeed(ctx, tvChannel, func(event *lichess.TVFeedEvent) error {
+			switch event.T {
+			case "featured":
+				state = &tvState{game: event.D, fen: event.D.Fen}
+				for _, player := range event.D.Players {
+					if player.Color == "white" {
+						state.whiteClock = player.Seconds
+					} else {
+						state.blackClock = player.Seconds
+					}
+				}
+			case "fen":
+				if state == nil {
+					return nil
+				}
+				state.fen, state.lastMove = event.D.Fen, event.D.Lm
+				state.whiteClock, state.blackClock = event.D.Wc, event.D.Bc
+			default:
+				return nil
+			}
+			publish(p.tvMessage(tvChannel, state))
+			return nil
+		})
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			p.API.LogWarn("Lichess TV feed failed", "tvChannel", tvChannel, "error", err.Error())
+		}
+		if !sleepOrDone(ctx, liveRetryInterval) {
+			return false
+		}
+	}
+}
+
+func (p *Plugin) tvMessage(tvChannel string, state *tvState) string {
+	var white, black string
+	for _, player := range state.game.Players {
+		name := player.User.Name
+		if player.User.Title != "" {
+			name = player.User.Title + " " + name
+		}
+		text := fmt.Sprintf("**%s** (%d)", name, player.Rating)
+		if player.Color == "white" {
+			white = fmt.Sprintf("%s, %s", text, formatSeconds(state.whiteClock))
+		} else {
+			black = fmt.Sprintf("%s, %s", text, formatSeconds(state.blackClock))
+		}
+	}
+
+	flip := state.game.Orientation == "black"
+	top, bottom := black, white
+	if flip {
+		top, bottom = white, black
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### Lichess TV: %s\n", tvChannelName(tvChannel))
+	b.WriteString(top + "\n\n")
+	b.WriteString(p.boardMarkdown(streamFEN(state.fen, state.lastMove), state.lastMove, flip))
+	b.WriteString("\n\n" + bottom + "\n\n")
+	fmt.Fprintf(&b, "[Watch on Lichess](%s%s)", p.getConfiguration().getBaseURL(), state.game.Id)
+	return b.String()
+}
+
+func tvChannelName(tvChannel string) string {
+	switch tvChannel {
+	case "best":
+		return "Top rated"
+	case "computer":
+		return "Computer"
+	case "bot":
+		return "Bot"
+	default:
+		return lichess.PerfName(tvChannel)
+	}
+}
+
+func tvAutocompleteItems() []model.AutocompleteListItem {
+	items := []model.AutocompleteListItem{{Item: "stop", HelpText: "Stop Lichess TV in this channel"}}
+	for _, tvChannel := range lichess.TVChannels {
+		items = append(items, model.AutocompleteListItem{Item: tvChannel, HelpText: tvChannelName(tvChannel)})
+	}
+	return items
+}
+
+// formatSeconds formats a remaining clock time like 2:05 or 1:02:05.
+func formatSeconds(seconds int) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func (p *Plugin) executeTVCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	tvChannel := "best"
+	if len(params) > 0 {
+		if params[0] == "stop" {
+			return p.executeTVStopCommand(args)
+		}
+		channel, ok := lichess.ParseTVChannel(params[0])
+		if !ok {
+			return ephemeralResponsef("Unknown TV channel %q. Use one of %s.", params[0], strings.Join(lichess.TVChannels, ", "))
+		}
+		tvChannel = channel
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		Message:   fmt.Sprintf("#### Lichess TV: %s\nTuning in...", tvChannelName(tvChannel)),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to create TV post", "error", err.Error())
+		return ephemeralResponse("Failed to post Lichess TV.")
+	}
+
+	err := p.tv.add(LivePost{
+		PostID:    post.Id,
+		ChannelID: args.ChannelId,
+		Key:       tvChannel,
+		ExpiresAt: model.GetMillis() + tvPostLifetime.Milliseconds(),
+	})
+	if err != nil {
+		p.API.LogWarn("failed to store TV post", "error", err.Error())
+		return ephemeralResponse("Failed to start Lichess TV.")
+	}
+	return &model.CommandResponse{}
+}
+
+func (p *Plugin) executeTVStopCommand(args *model.CommandArgs) *model.CommandResponse {
+	stopped, err := p.tv.remove(func(post LivePost) bool {
+		return post.ChannelID == args.ChannelId
+	})
+	if err != nil {
+		p.API.LogWarn("failed to stop TV posts", "error", err.Error())
+		return ephemeralResponse("Failed to stop Lichess TV.")
+	}
+	if stopped == 0 {
+		return ephemeralResponse("Lichess TV is not shown in this channel.")
+	}
+	return ephemeralResponse("Stopped Lichess TV in this channel.")
+}
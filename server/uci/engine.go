@@ -0,0 +1,371 @@
+// Package uci runs chess engines that speak the Universal Chess Interface,
+// such as Stockfish, as child processes.
+package uci
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	quitTimeout      = 2 * time.Second
+)
+
+// stopTimeout is how long a stopped search may take to report its best move.
+var stopTimeout = 5 * time.Second
+
+// ErrEngineExited is returned when the engine process ends while it is used.
+var ErrEngineExited = errors.New("the engine exited")
+
+// Request is a position to analyse and when to stop. Without a depth, move
+// time or node limit the search runs until the context is done.
+type Request struct {
+	// FEN is the position the moves are played from, the standard starting
+	// position when empty.
+	FEN string
+	// Moves are in UCI notation.
+	Moves    []string
+	Depth    int
+	MoveTime time.Duration
+	Nodes    int
+	// MultiPV is the number of best lines searched, at least one.
+	MultiPV int
+}
+
+// Score is an evaluation from the point of view of the side to move.
+type Score struct {
+	Centipawns int
+	// Mate is the number of moves to mate, negative when the side to move
+	// is mated, and zero when no mate was found.
+	Mate int
+}
+
+// Line is one of the best lines of a search.
+type Line struct {
+	Depth int
+	Score Score
+	// Moves are in UCI notation, starting with the move searched.
+	Moves []string
+}
+
+// Analysis is the result of a search. Lines are ordered from the best.
+type Analysis struct {
+	// Engine is the name the engine reported.
+	Engine   string
+	BestMove string
+	Depth    int
+	Nodes    int64
+	Lines    []Line
+}
+
+// Engine is one running engine process. It analyses one position at a time
+// and is not safe for concurrent use.
+type Engine struct {
+	Name string
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string
+	multiPV int
+	// err is set when the engine cannot be used anymore, for example when
+	// it did not answer in time and its output is out of step.
+	err error
+}
+
+// Start launches the engine at path, waits for it to accept UCI and sets
+// the options, for example Threads or Hash.
+func Start(ctx context.Context, path string, options map[string]string) (*Engine, error) {
+	cmd := exec.Command(path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open engine input")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open engine output")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start engine")
+	}
+
+	e := &Engine{
+		cmd:     cmd,
+		stdin:   stdin,
+		lines:   make(chan string, 64),
+		multiPV: 1,
+	}
+	go e.read(stdout)
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	if err := e.handshake(ctx, options); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Engine) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e.lines <- scanner.Text()
+	}
+	close(e.lines)
+}
+
+func (e *Engine) handshake(ctx context.Context, options map[string]string) error {
+	if err := e.send("uci"); err != nil {
+		return err
+	}
+	err := e.waitFor(ctx, "uciok", func(fields []string) {
+		if len(fields) > 2 && fields[0] == "id" && fields[1] == "name" {
+			e.Name = strings.Join(fields[2:], " ")
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "engine did not accept UCI")
+	}
+	for name, value := range options {
+		if err := e.setOption(name, value); err != nil {
+			return err
+		}
+	}
+	return e.sync(ctx)
+}
+
+func (e *Engine) send(command string) error {
+	if _, err := io.WriteString(e.stdin, command+"\n"); err != nil {
+		return errors.Wrap(err, "failed to write to engine")
+	}
+	return nil
+}
+
+func (e *Engine) setOption(name, value string) error {
+	return e.send(fmt.Sprintf("setoption name %s value %s", name, value))
+}
+
+// sync waits until the engine has processed the commands sent so far.
+func (e *Engine) sync(ctx context.Context) error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	return e.waitFor(ctx, "readyok", nil)
+}
+
+// waitFor reads the engine output up to a line starting with token, passing
+// the lines before it to fn.
+func (e *Engine) waitFor(ctx context.Context, token string, fn func(fields []string)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-e.lines:
+			if !ok {
+				return ErrEngineExited
+			}
+			fields := strings.Fields(line)
+			if len(fields) > 0 && fields[0] == token {
+				return nil
+			}
+			if fn != nil {
+				fn(fields)
+			}
+		}
+	}
+}
+
+// Analyse searches a position. When the context is done the search is
+// stopped and the context's error returned.
+func (e *Engine) Analyse(ctx context.Context, req Request) (*Analysis, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	position, err := positionCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	analysis, err := e.analyse(ctx, req, position)
+	if err != nil && errors.Cause(err) != ctx.Err() {
+		e.err = err
+	}
+	return analysis, err
+}
+
+func (e *Engine) analyse(ctx context.Context, req Request, position string) (*Analysis, error) {
+	multiPV := req.MultiPV
+	if multiPV < 1 {
+		multiPV = 1
+	}
+	if multiPV != e.multiPV {
+		if err := e.setOption("MultiPV", strconv.Itoa(multiPV)); err != nil {
+			return nil, err
+		}
+		e.multiPV = multiPV
+	}
+	if err := e.sync(ctx); err != nil {
+		return nil, errors.Wrap(err, "engine is not ready")
+	}
+
+	if err := e.send(position); err != nil {
+		return nil, err
+	}
+	if err := e.send(goCommand(req)); err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{Engine: e.Name}
+	lines := make([]Line, multiPV)
+	done := ctx.Done()
+	var stopped <-chan time.Time
+	for {
+		select {
+		case <-done:
+			done = nil
+			if err := e.send("stop"); err != nil {
+				return nil, err
+			}
+			stopped = time.After(stopTimeout)
+		case <-stopped:
+			return nil, errors.New("engine did not stop")
+		case line, ok := <-e.lines:
+			if !ok {
+				return nil, ErrEngineExited
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "info":
+				parseInfo(fields[1:], analysis, lines)
+			case "bestmove":
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if len(fields) > 1 && fields[1] != "(none)" {
+					analysis.BestMove = fields[1]
+				}
+				for _, l := range lines {
+					if len(l.Moves) > 0 {
+						analysis.Lines = append(analysis.Lines, l)
+					}
+				}
+				return analysis, nil
+			}
+		}
+	}
+}
+
+func positionCommand(req Request) (string, error) {
+	for _, s := range append([]string{req.FEN}, req.Moves...) {
+		if strings.ContainsAny(s, "\r\n") {
+			return "", errors.New("invalid position")
+		}
+	}
+	command := "position startpos"
+	if req.FEN != "" {
+		command = "position fen " + req.FEN
+	}
+	if len(req.Moves) > 0 {
+		command += " moves " + strings.Join(req.Moves, " ")
+	}
+	return command, nil
+}
+
+func goCommand(req Request) string {
+	command := "go"
+	if req.Depth > 0 {
+		command += " depth " + strconv.Itoa(req.Depth)
+	}
+	if req.MoveTime > 0 {
+		command += " movetime " + strconv.FormatInt(req.MoveTime.Milliseconds(), 10)
+	}
+	if req.Nodes > 0 {
+		command += " nodes " + strconv.Itoa(req.Nodes)
+	}
+	if command == "go" {
+		command += " infinite"
+	}
+	return command
+}
+
+// parseInfo reads an info line into the analysis and its lines. Scores that
+// are only bounds are skipped, as the previous line is more accurate.
+func parseInfo(fields []string, analysis *Analysis, lines []Line) {
+	line := Line{}
+	multiPV := 1
+	bound := false
+	for i := 0; i < len(fields); i++ {
+		next := func() int {
+			if i+1 == len(fields) {
+				return 0
+			}
+			i++
+			n, _ := strconv.Atoi(fields[i])
+			return n
+		}
+		switch fields[i] {
+		case "depth":
+			line.Depth = next()
+		case "multipv":
+			multiPV = next()
+		case "nodes":
+			analysis.Nodes = int64(next())
+		case "score":
+			if i+1 == len(fields) {
+				return
+			}
+			i++
+			switch fields[i] {
+			case "cp":
+				line.Score.Centipawns = next()
+			case "mate":
+				line.Score.Mate = next()
+			}
+		case "lowerbound", "upperbound":
+			bound = true
+		case "pv":
+			line.Moves = append([]string(nil), fields[i+1:]...)
+			i = len(fields)
+		case "string":
+			return
+		}
+	}
+	if bound || len(line.Moves) == 0 || multiPV < 1 || multiPV > len(lines) {
+		return
+	}
+	lines[multiPV-1] = line
+	if multiPV == 1 {
+		analysis.Depth = line.Depth
+	}
+}
+
+// Close asks the engine to quit, and kills it when it does not.
+func (e *Engine) Close() {
+	_ = e.send("quit")
+	_ = e.stdin.Close()
+
+	exited := make(chan struct{})
+	go func() {
+		for range e.lines {
+		}
+		_ = e.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(quitTimeout):
+		_ = e.cmd.Process.Kill()
+		<-exited
+	}
+}
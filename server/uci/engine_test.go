@@ -0,0 +1,180 @@
+package uci
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEngineEnv makes the test binary run as a scripted engine instead of
+// the tests. Start runs engines without arguments, so the mode is passed in
+// the environment the child inherits.
+const fakeEngineEnv = "UCI_FAKE_ENGINE"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeEngineEnv); mode != "" {
+		fakeEngine(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeEngine speaks just enough UCI for the tests. Its name carries its
+// process ID, so the tests can tell engines apart. In the "hang" mode it
+// never answers a go command.
+func fakeEngine(mode string) {
+	out := bufio.NewWriter(os.Stdout)
+	say := func(format string, args ...interface{}) {
+		fmt.Fprintf(out, format+"\n", args...)
+		out.Flush()
+	}
+
+	multiPV := 1
+	searching := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			say("id name Fake %d", os.Getpid())
+			say("id author nobody")
+			say("option name MultiPV type spin default 1 min 1 max 500")
+			say("uciok")
+		case "isready":
+			say("readyok")
+		case "setoption":
+			if len(fields) == 5 && fields[2] == "MultiPV" {
+				fmt.Sscan(fields[4], &multiPV)
+			}
+		case "go":
+			if mode == "hang" {
+				continue
+			}
+			say("info depth 1 multipv 1 score cp 10 nodes 20 pv e2e4")
+			say("info string searching")
+			if fields[len(fields)-1] == "infinite" {
+				searching = true
+				continue
+			}
+			say("info depth 12 seldepth 16 multipv 1 score cp 40 lowerbound nodes 9000 pv d2d4")
+			say("info depth 12 seldepth 16 multipv 1 score cp 31 nodes 10000 nps 500000 pv e2e4 e7e5 g1f3")
+			if multiPV > 1 {
+				say("info depth 12 seldepth 15 multipv 2 score mate -3 nodes 10000 pv f2f3 e7e5")
+			}
+			say("bestmove e2e4 ponder e7e5")
+		case "stop":
+			if searching {
+				searching = false
+				say("bestmove e2e4")
+			}
+		case "quit":
+			return
+		}
+	}
+}
+
+func startFakeEngine(t *testing.T, mode string) *Engine {
+	t.Helper()
+	t.Setenv(fakeEngineEnv, mode)
+	e, err := Start(context.Background(), os.Args[0], map[string]string{"Hash": "16"})
+	if err != nil {
+		t.Fatalf("failed to start engine: %v", err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+func TestStartHandshake(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+	if want := fmt.Sprintf("Fake %d", e.cmd.Process.Pid); e.Name != want {
+		t.Errorf("got name %q, want %q", e.Name, want)
+	}
+}
+
+func TestAnalyseMultiPV(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+
+	analysis, err := e.Analyse(context.Background(), Request{Moves: []string{"e2e4"}, Depth: 12, MultiPV: 2})
+	if err != nil {
+		t.Fatalf("failed to analyse: %v", err)
+	}
+	want := &Analysis{
+		Engine:   e.Name,
+		BestMove: "e2e4",
+		Depth:    12,
+		Nodes:    10000,
+		Lines: []Line{
+			{Depth: 12, Score: Score{Centipawns: 31}, Moves: []string{"e2e4", "e7e5", "g1f3"}},
+			{Depth: 12, Score: Score{Mate: -3}, Moves: []string{"f2f3", "e7e5"}},
+		},
+	}
+	if !reflect.DeepEqual(analysis, want) {
+		t.Errorf("got %+v, want %+v", analysis, want)
+	}
+
+	// Going back to a single line drops the second one.
+	analysis, err = e.Analyse(context.Background(), Request{Depth: 12})
+	if err != nil {
+		t.Fatalf("failed to analyse: %v", err)
+	}
+	if len(analysis.Lines) != 1 {
+		t.Errorf("got %d lines, want 1", len(analysis.Lines))
+	}
+}
+
+func TestAnalyseCancel(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := e.Analyse(ctx, Request{}); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The search was stopped cleanly, so the engine can be used again.
+	analysis, err := e.Analyse(context.Background(), Request{Depth: 12})
+	if err != nil {
+		t.Fatalf("failed to analyse after a cancelled search: %v", err)
+	}
+	if analysis.BestMove != "e2e4" {
+		t.Errorf("got best move %q, want e2e4", analysis.BestMove)
+	}
+}
+
+func TestAnalyseStopTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { stopTimeout = timeout }(stopTimeout)
+	stopTimeout = 100 * time.Millisecond
+
+	e := startFakeEngine(t, "hang")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := e.Analyse(ctx, Request{})
+	if err == nil || err == context.DeadlineExceeded {
+		t.Fatalf("got error %v, want the engine not stopping", err)
+	}
+
+	// The output of the engine is out of step now, so it stays unusable.
+	if _, again := e.Analyse(context.Background(), Request{Depth: 1}); again != err {
+		t.Errorf("got error %v, want %v", again, err)
+	}
+}
+
+func TestAnalyseInvalidPosition(t *testing.T) {
+	e := startFakeEngine(t, "normal")
+
+	if _, err := e.Analyse(context.Background(), Request{Moves: []string{"e2e4\nquit"}}); err == nil {
+		t.Fatal("got no error for a move with a newline")
+	}
+	if _, err := e.Analyse(context.Background(), Request{Depth: 1}); err != nil {
+		t.Errorf("failed to analyse after an invalid request: %v", err)
+	}
+}
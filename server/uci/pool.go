@@ -0,0 +1,98 @@
+package uci
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPoolClosed is returned for requests to a closed pool.
+var ErrPoolClosed = errors.New("the engine pool is closed")
+
+// Pool runs up to a number of engine processes and hands each request to
+// an idle one. Engines are started on demand and kept for later requests.
+type Pool struct {
+	path    string
+	options map[string]string
+	slots   chan struct{}
+
+	lock   sync.Mutex
+	idle   []*Engine
+	closed bool
+}
+
+// NewPool creates a pool of at most size engines started from path with the
+// given options.
+func NewPool(path string, size int, options map[string]string) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		path:    path,
+		options: options,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// Analyse searches a position on an idle engine, waiting for one while all
+// are busy. An engine that fails is replaced by a new one for the next
+// request.
+func (p *Pool) Analyse(ctx context.Context, req Request) (*Analysis, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	e, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	analysis, err := e.Analyse(ctx, req)
+	p.put(e)
+	return analysis, err
+}
+
+func (p *Pool) get(ctx context.Context) (*Engine, error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		e := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.lock.Unlock()
+		return e, nil
+	}
+	p.lock.Unlock()
+
+	return Start(ctx, p.path, p.options)
+}
+
+func (p *Pool) put(e *Engine) {
+	p.lock.Lock()
+	if e.err == nil && !p.closed {
+		p.idle = append(p.idle, e)
+		p.lock.Unlock()
+		return
+	}
+	p.lock.Unlock()
+	e.Close()
+}
+
+// Close quits the idle engines. Engines still searching quit when their
+// request is done.
+func (p *Pool) Close() {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.lock.Unlock()
+
+	for _, e := range idle {
+		e.Close()
+	}
+}
@@ -0,0 +1,94 @@
+package uci
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPoolReusesEngines(t *testing.T) {
+	t.Setenv(fakeEngineEnv, "normal")
+	p := NewPool(os.Args[0], 2, nil)
+	defer p.Close()
+
+	first, err := p.Analyse(context.Background(), Request{Depth: 1})
+	if err != nil {
+		t.Fatalf("failed to analyse: %v", err)
+	}
+	second, err := p.Analyse(context.Background(), Request{Depth: 1})
+	if err != nil {
+		t.Fatalf("failed to analyse: %v", err)
+	}
+	if first.Engine != second.Engine {
+		t.Errorf("got engines %q and %q, want the idle one reused", first.Engine, second.Engine)
+	}
+}
+
+func TestPoolReplacesFailedEngine(t *testing.T) {
+	defer func(timeout time.Duration) { stopTimeout = timeout }(stopTimeout)
+	stopTimeout = 100 * time.Millisecond
+
+	t.Setenv(fakeEngineEnv, "hang")
+	p := NewPool(os.Args[0], 1, nil)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.Analyse(ctx, Request{}); err == nil {
+		t.Fatal("got no error from a hanging engine")
+	}
+	if len(p.idle) != 0 {
+		t.Fatal("kept the failed engine")
+	}
+
+	t.Setenv(fakeEngineEnv, "normal")
+	if _, err := p.Analyse(context.Background(), Request{Depth: 1}); err != nil {
+		t.Errorf("failed to analyse on a new engine: %v", err)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	t.Setenv(fakeEngineEnv, "normal")
+	p := NewPool(os.Args[0], 1, nil)
+
+	if _, err := p.Analyse(context.Background(), Request{Depth: 1}); err != nil {
+		t.Fatalf("failed to analyse: %v", err)
+	}
+	idle := p.idle[0]
+
+	p.Close()
+	if idle.cmd.ProcessState == nil {
+		t.Error("the idle engine is still running")
+	}
+	if _, err := p.Analyse(context.Background(), Request{Depth: 1}); err != ErrPoolClosed {
+		t.Errorf("got error %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestPoolCloseWhileSearching(t *testing.T) {
+	t.Setenv(fakeEngineEnv, "normal")
+	p := NewPool(os.Args[0], 1, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := p.Analyse(ctx, Request{})
+		done <- err
+	}()
+
+	// Give the search time to start before closing the pool under it.
+	for len(p.slots) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	p.Close()
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if len(p.idle) != 0 {
+		t.Error("kept an engine after the pool was closed")
+	}
+}
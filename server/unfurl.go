@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/chess"
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/eco"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	maxUnfurlsPerPost   = 3
+	maxUnfurledChapters = 20
+	unfurlTimeout       = 10 * time.Second
+)
+
+type linkKind int
+
+const (
+	gameLinkKind linkKind = iota
+	studyLinkKind
+	broadcastLinkKind
+)
+
+// unfurlLink is a link to a game, a study chapter or a broadcast round.
+// Color is the board orientation of game links and ChapterID is set when a
+// study or broadcast link points at a specific chapter.
+type unfurlLink struct {
+	Kind      linkKind
+	ID        string
+	ChapterID string
+	Color     string
+	position  int
+}
+
+var lowercaseWordRegexp = regexp.MustCompile(`^[a-z]+$`)
+
+const linkEnd = `(?:[\s)>\]#?]|$)`
+
+// linkRegexps are the patterns of links to one Lichess server.
+type linkRegexps struct {
+	baseURL   string
+	game      *regexp.Regexp
+	study     *regexp.Regexp
+	broadcast *regexp.Regexp
+}
+
+var (
+	linkRegexpsLock sync.Mutex
+	// cachedLinkRegexps are compiled for the last base URL asked for, which
+	// only changes with the configuration.
+	cachedLinkRegexps *linkRegexps
+)
+
+func linkRegexpsFor(baseURL string) *linkRegexps {
+	linkRegexpsLock.Lock()
+	defer linkRegexpsLock.Unlock()
+
+	if cachedLinkRegexps == nil || cachedLinkRegexps.baseURL != baseURL {
+		quoted := regexp.QuoteMeta(baseURL)
+		cachedLinkRegexps = &linkRegexps{
+			baseURL:   baseURL,
+			game:      regexp.MustCompile(quoted + `([a-zA-Z0-9]{8})(?:[a-zA-Z0-9]{4})?(?:/(white|black))?` + linkEnd),
+			study:     regexp.MustCompile(quoted + `study/([a-zA-Z0-9]{8})(?:/([a-zA-Z0-9]{8}))?` + linkEnd),
+			broadcast: regexp.MustCompile(quoted + `broadcast/[^/\s]+/[^/\s]+/([a-zA-Z0-9]{8})(?:/([a-zA-Z0-9]{8}))?` + linkEnd),
+		}
+	}
+	return cachedLinkRegexps
+}
+
+// gameLinkRegexp matches links to games on the Lichess server, including
+// the 12 character player URLs, the board orientation and move anchors.
+func gameLinkRegexp(baseURL string) *regexp.Regexp {
+	return linkRegexpsFor(baseURL).game
+}
+
+func findLinks(baseURL, message string) []unfurlLink {
+	var links []unfurlLink
+	seen := map[string]bool{}
+	add := func(link unfurlLink) {
+		key := fmt.Sprint(link.Kind, link.ID, link.ChapterID)
+		if !seen[key] {
+			seen[key] = true
+			links = append(links, link)
+		}
+	}
+
+	regexps := linkRegexpsFor(baseURL)
+	for _, match := range regexps.game.FindAllStringSubmatchIndex(message, -1) {
+		id := message[match[2]:match[3]]
+		// Site pages such as /training share the shape of game IDs, which
+		// are random and practically never all lower case.
+		if lowercaseWordRegexp.MatchString(id) {
+			continue
+		}
+		color := ""
+		if match[4] >= 0 {
+			color = message[match[4]:match[5]]
+		}
+		add(unfurlLink{Kind: gameLinkKind, ID: id, Color: color, position: match[0]})
+	}
+
+	for kind, re := range map[linkKind]*regexp.Regexp{
+		studyLinkKind:     regexps.study,
+		broadcastLinkKind: regexps.broadcast,
+	} {
+		for _, match := range re.FindAllStringSubmatchIndex(message, -1) {
+			link := unfurlLink{Kind: kind, ID: message[match[2]:match[3]], position: match[0]}
+			if match[4] >= 0 {
+				link.ChapterID = message[match[4]:match[5]]
+			}
+			add(link)
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].position < links[j].position })
+	if len(links) > maxUnfurlsPerPost {
+		links = links[:maxUnfurlsPerPost]
+	}
+	return links
+}
+
+// goUnfurl attaches the link previews of a post in the background, so a
+// slow Lichess server doesn't hold up the hook. The previews are given up
+// when the plugin deactivates.
+func (p *Plugin) goUnfurl(post *model.Post) {
+	p.unfurls.Add(1)
+	go func() {
+		defer p.unfurls.Done()
+		defer func() {
+			if x := recover(); x != nil {
+				p.API.LogError("recovered from a panic in link previews", "error", x)
+			}
+		}()
+		p.unfurlLinks(p.unfurlsCtx, post)
+	}()
+}
+
+// unfurlLinks attaches previews of the Lichess games, studies and broadcasts
+// linked in a post. It runs once the post is saved, so a slow Lichess server
+// doesn't hold up sending messages.
+func (p *Plugin) unfurlLinks(ctx context.Context, post *model.Post) {
+	if post.UserId == p.botUserID || len(post.Attachments()) > 0 {
+		return
+	}
+
+	baseURL := p.getConfiguration().getBaseURL()
+	if !strings.Contains(post.Message, baseURL) {
+		return
+	}
+
+	links := findLinks(baseURL, post.Message)
+	if len(links) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, unfurlTimeout)
+	defer cancel()
+
+	var attachments []*model.SlackAttachment
+	for _, link := range links {
+		var attachment *model.SlackAttachment
+		switch link.Kind {
+		case gameLinkKind:
+			attachment = p.unfurlGame(ctx, link)
+		case studyLinkKind:
+			attachment = p.unfurlStudy(ctx, link)
+		case broadcastLinkKind:
+			attachment = p.unfurlBroadcast(ctx, link)
+		}
+		if attachment != nil {
+			attachments = append(attachments, attachment)
+		}
+	}
+	if len(attachments) == 0 || ctx.Err() != nil {
+		return
+	}
+
+	// The post may have been edited or deleted while the previews were
+	// fetched.
+	latest, appErr := p.API.GetPost(post.Id)
+	if appErr != nil {
+		p.API.LogWarn("failed to get post for previews", "postID", post.Id, "error", appErr.Error())
+		return
+	}
+	if latest.DeleteAt != 0 || latest.Message != post.Message || len(latest.Attachments()) > 0 {
+		return
+	}
+	latest = latest.Clone()
+	model.ParseSlackAttachment(latest, attachments)
+	if _, appErr := p.API.UpdatePost(latest); appErr != nil {
+		p.API.LogWarn("failed to attach link previews", "postID", post.Id, "error", appErr.Error())
+	}
+}
+
+func (p *Plugin) unfurlGame(ctx context.Context, link unfurlLink) *model.SlackAttachment {
+	game, err := p.newLichessClient().ExportGame(ctx, link.ID)
+	if err != nil {
+		p.API.LogDebug("failed to fetch game for preview", "gameID", link.ID, "error", err.Error())
+		return nil
+	}
+	return p.gameAttachment(game, link.Color)
+}
+
+func (p *Plugin) unfurlStudy(ctx context.Context, link unfurlLink) *model.SlackAttachment {
+	pgn, err := p.newLichessClient().StudyPGN(ctx, link.ID)
+	if err != nil {
+		p.API.LogDebug("failed to fetch study for preview", "studyID", link.ID, "error", err.Error())
+		return nil
+	}
+	chapters, err := chess.ParsePGN(strings.NewReader(pgn))
+	if err != nil || len(chapters) == 0 {
+		p.API.LogDebug("failed to parse study for preview", "studyID", link.ID, "error", fmt.Sprint(err))
+		return nil
+	}
+
+	name := chapters[0].Tag("StudyName")
+	if name == "" {
+		name = strings.SplitN(chapters[0].Tag("Event"), ":", 2)[0]
+	}
+
+	selected := chapters[0]
+	var lines []string
+	for i, chapter := range chapters {
+		chapterURL := chapter.Tag("ChapterURL")
+		if chapterURL == "" {
+			chapterURL = chapter.Tag("Site")
+		}
+		if link.ChapterID != "" && strings.HasSuffix(chapterURL, "/"+link.ChapterID) {
+			selected = chapter
+		}
+		if i < maxUnfurledChapters {
+			lines = append(lines, fmt.Sprintf("%d. [%s](%s)", i+1, chapterName(chapter), chapterURL))
+		}
+	}
+	if len(chapters) > maxUnfurledChapters {
+		lines = append(lines, fmt.Sprintf("and %d more chapters", len(chapters)-maxUnfurledChapters))
+	}
+
+	return p.pgnAttachment(
+		name,
+		p.getConfiguration().getBaseURL()+"study/"+link.ID,
+		"Study chapters:\n"+strings.Join(lines, "\n"),
+		selected,
+	)
+}
+
+func (p *Plugin) unfurlBroadcast(ctx context.Context, link unfurlLink) *model.SlackAttachment {
+	pgn, err := p.newLichessClient().BroadcastRoundPGN(ctx, link.ID)
+	if err != nil {
+		p.API.LogDebug("failed to fetch broadcast for preview", "roundID", link.ID, "error", err.Error())
+		return nil
+	}
+	games, err := chess.ParsePGN(strings.NewReader(pgn))
+	if err != nil || len(games) == 0 {
+		p.API.LogDebug("failed to parse broadcast for preview", "roundID", link.ID, "error", fmt.Sprint(err))
+		return nil
+	}
+
+	name := games[0].Tag("BroadcastName")
+	if round := games[0].Tag("Event"); name == "" {
+		name = round
+	} else if round != "" && round != name {
+		name += " • " + round
+	}
+
+	selected := games[0]
+	var lines []string
+	for i, game := range games {
+		gameURL := game.Tag("GameURL")
+		if gameURL == "" {
+			gameURL = game.Tag("Site")
+		}
+		if link.ChapterID != "" && strings.HasSuffix(gameURL, "/"+link.ChapterID) {
+			selected = game
+		}
+		if i < maxUnfurledChapters {
+			lines = append(lines, fmt.Sprintf("%d. %s - %s %s", i+1, pgnPlayer(game, "White"), pgnPlayer(game, "Black"), game.Result))
+		}
+	}
+	if len(games) > maxUnfurledChapters {
+		lines = append(lines, fmt.Sprintf("and %d more games", len(games)-maxUnfurledChapters))
+	}
+
+	roundURL := games[0].Tag("BroadcastURL")
+	if roundURL == "" {
+		roundURL = p.getConfiguration().getBaseURL() + "broadcast/-/-/" + link.ID
+	}
+	return p.pgnAttachment(name, roundURL, strings.Join(lines, "\n"), selected)
+}
+
+// pgnAttachment previews a study or broadcast, showing the current position
+// of one of its chapters.
+func (p *Plugin) pgnAttachment(title, link, text string, chapter *chess.PGNGame) *model.SlackAttachment {
+	attachment := &model.SlackAttachment{
+		Fallback:  title + ": " + link,
+		Color:     "#629924",
+		Title:     title,
+		TitleLink: link,
+		Text:      text,
+	}
+
+	field := chapterName(chapter)
+	replayed, err := chapter.Replay()
+	if opening := chapter.Tag("Opening"); opening != "" {
+		field += "\n" + strings.TrimSpace(chapter.Tag("ECO")+" "+opening)
+	} else if err == nil {
+		if classified, ok := eco.Classify(replayed); ok {
+			field += "\n" + classified.String()
+		}
+	}
+	attachment.Fields = []*model.SlackAttachmentField{{Title: "Position", Value: field}}
+
+	if err != nil {
+		p.API.LogDebug("failed to replay chapter for preview", "link", link, "error", err.Error())
+		return attachment
+	}
+	lastMove := ""
+	if ucis := replayed.UCIMoves(); len(ucis) > 0 {
+		lastMove = ucis[len(ucis)-1]
+	}
+	attachment.ImageURL = p.boardImageURL(replayed.Position().FEN(), lastMove, chapter.Tag("Orientation") == "black")
+	return attachment
+}
+
+func chapterName(chapter *chess.PGNGame) string {
+	if name := chapter.Tag("ChapterName"); name != "" {
+		return name
+	}
+	if white, black := chapter.Tag("White"), chapter.Tag("Black"); white != "" || black != "" {
+		return pgnPlayer(chapter, "White") + " - " + pgnPlayer(chapter, "Black")
+	}
+	return chapter.Tag("Event")
+}
+
+// pgnPlayer formats the White or Black player of a PGN game with title and
+// rating.
+func pgnPlayer(game *chess.PGNGame, color string) string {
+	name := game.Tag(color)
+	if name == "" {
+		name = "?"
+	}
+	if title := game.Tag(color + "Title"); title != "" {
+		name = title + " " + name
+	}
+	if elo := game.Tag(color + "Elo"); elo != "" && elo != "?" {
+		name += " (" + elo + ")"
+	}
+	return name
+}
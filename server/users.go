@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/pkg/errors"
+)
+
+const (
+	lichessUsersKey = "lichessusers"
+	accountCacheTTL = 10 * time.Minute
+	teamCacheTTL    = 5 * time.Minute
+)
+
+// getConnectedUsers returns the Lichess usernames of all connected users by
+// Mattermost user ID.
+func (p *Plugin) getConnectedUsers() (map[string]string, error) {
+	users := map[string]string{}
+	if err := p.pluginAPI.KV.Get(lichessUsersKey, &users); err != nil {
+		return nil, errors.Wrap(err, "failed to get connected users from kv store")
+	}
+	return users, nil
+}
+
+// lichessMentions maps the lower case Lichess usernames of connected users to
+// mentions of their Mattermost accounts.
+func (p *Plugin) lichessMentions() map[string]string {
+	mentions := map[string]string{}
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return mentions
+	}
+	for userID, lichessUsername := range connected {
+		user, err := p.pluginAPI.User.Get(userID)
+		if err != nil || user.DeleteAt != 0 {
+			continue
+		}
+		mentions[strings.ToLower(lichessUsername)] = "@" + user.Username
+	}
+	return mentions
+}
+
+func (p *Plugin) addConnectedUser(userID, lichessUsername string) error {
+	err := p.pluginAPI.KV.SetAtomicWithRetries(lichessUsersKey, func(old []byte) (interface{}, error) {
+		users := map[string]string{}
+		if old != nil {
+			if err := json.Unmarshal(old, &users); err != nil {
+				return nil, err
+			}
+		}
+		users[userID] = lichessUsername
+		return users, nil
+	})
+	return errors.Wrap(err, "failed to store connected user")
+}
+
+// backfillConnectedUsers builds the index of connected users from the stored
+// tokens of accounts connected before the index existed.
+func (p *Plugin) backfillConnectedUsers() error {
+	existing, appErr := p.API.KVGet(lichessUsersKey)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get connected users from kv store")
+	}
+	if existing != nil {
+		return nil
+	}
+
+	const perPage = 1000
+	users := map[string]string{}
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, perPage)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to list kv store keys")
+		}
+		for _, key := range keys {
+			if !strings.HasSuffix(key, lichessTokenKey) {
+				continue
+			}
+			data, appErr := p.API.KVGet(key)
+			if appErr != nil || data == nil {
+				continue
+			}
+			var info LichessUserInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				continue
+			}
+			users[info.UserID] = info.LichessUsername
+		}
+		if len(keys) < perPage {
+			break
+		}
+	}
+
+	if _, err := p.pluginAPI.KV.Set(lichessUsersKey, users); err != nil {
+		return errors.Wrap(err, "failed to store connected users")
+	}
+	return nil
+}
+
+type cachedAccount struct {
+	account   lichess.LichessAccount
+	fetchedAt time.Time
+}
+
+// accountCache keeps public Lichess user data for a while, so commands over
+// many users need at most one bulk request.
+type accountCache struct {
+	lock     sync.Mutex
+	accounts map[string]cachedAccount
+}
+
+func newAccountCache() *accountCache {
+	return &accountCache{accounts: make(map[string]cachedAccount)}
+}
+
+// getLichessAccounts returns the public data of the given Lichess users,
+// keyed by lower case username. Unknown or closed accounts are missing.
+func (p *Plugin) getLichessAccounts(ctx context.Context, usernames []string) (map[string]lichess.LichessAccount, error) {
+	accounts := make(map[string]lichess.LichessAccount, len(usernames))
+	var missing []string
+
+	p.accounts.lock.Lock()
+	for _, username := range usernames {
+		id := strings.ToLower(username)
+		cached, ok := p.accounts.accounts[id]
+		if ok && time.Since(cached.fetchedAt) < accountCacheTTL {
+			accounts[id] = cached.account
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	p.accounts.lock.Unlock()
+
+	if len(missing) == 0 {
+		return accounts, nil
+	}
+
+	fetched, err := p.newLichessClient().Users(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	p.accounts.lock.Lock()
+	defer p.accounts.lock.Unlock()
+	for _, account := range fetched {
+		id := strings.ToLower(account.Username)
+		accounts[id] = account
+		p.accounts.accounts[id] = cachedAccount{account: account, fetchedAt: now}
+	}
+	return accounts, nil
+}
+
+type cachedTeams struct {
+	teamIDs   map[string]bool
+	fetchedAt time.Time
+}
+
+// teamCache keeps the teams of Mattermost users for a while, so the polled
+// statuses endpoint doesn't look them up on every request.
+type teamCache struct {
+	lock  sync.Mutex
+	teams map[string]cachedTeams
+}
+
+func newTeamCache() *teamCache {
+	return &teamCache{teams: make(map[string]cachedTeams)}
+}
+
+// getUserTeamIDs returns the IDs of the teams a user is a member of.
+func (p *Plugin) getUserTeamIDs(userID string) (map[string]bool, error) {
+	p.teams.lock.Lock()
+	cached, ok := p.teams.teams[userID]
+	p.teams.lock.Unlock()
+	if ok && time.Since(cached.fetchedAt) < teamCacheTTL {
+		return cached.teamIDs, nil
+	}
+
+	teams, appErr := p.API.GetTeamsForUser(userID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get teams")
+	}
+	teamIDs := make(map[string]bool, len(teams))
+	for _, team := range teams {
+		teamIDs[team.Id] = true
+	}
+
+	p.teams.lock.Lock()
+	defer p.teams.lock.Unlock()
+	p.teams.teams[userID] = cachedTeams{teamIDs: teamIDs, fetchedAt: time.Now()}
+	return teamIDs, nil
+}
@@ -92,3 +92,12 @@ func decrypt(key []byte, text string) (string, error) {
 	}
 	return string(unpadMsg), nil
 }
+
+// capitalize upper-cases the first letter of an ASCII word such as a Lichess
+// speed or colour name.
+func capitalize(s string) string {
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
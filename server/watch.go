@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Phrynobatrachus/mattermost-plugin-lichess/server/lichess"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	watchRunnerName   = "watch"
+	watchPostLifetime = 6 * time.Hour
+
+	watchEndedNote = "\n\n*Stopped following this game.*"
+)
+
+var gameIDRegexp = regexp.MustCompile(`^([a-zA-Z0-9]{8})(?:[a-zA-Z0-9]{4})?$`)
+
+// watchState is what is known about a watched game.
+type watchState struct {
+	game       lichess.GameStreamEvent
+	fen        string
+	lastMove   string
+	whiteClock int
+	blackClock int
+	clocks     bool
+	finished   bool
+}
+
+// streamWatchedGame follows a game until it is over and publishes its
+// position. The stream is retried if it breaks off before the end.
+func (p *Plugin) streamWatchedGame(ctx context.Context, gameID string, publish func(message string)) bool {
+	client := p.newStreamingLichessClient()
+	var state *watchState
+	for {
+		err := client.StreamGame(ctx, gameID, func(event *lichess.GameStreamEvent) error {
+			switch {
+			case event.Id != "":
+				if state == nil {
+					state = &watchState{}
+				}
+				state.game = *event
+				state.fen, state.lastMove = event.Fen, event.LastMove
+				state.finished = event.Status.Name != "" && event.Status.Name != "created" && event.Status.Name != "started"
+			case state != nil:
+				state.fen, state.lastMove = event.Fen, event.Lm
+				state.whiteClock, state.blackClock, state.clocks = event.Wc, event.Bc, true
+			default:
+				return nil
+			}
+			publish(p.watchMessage(state))
+			return nil
+		})
+		if state != nil && state.finished {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if lichess.IsNotFound(err) {
+			publish(fmt.Sprintf("Game %s was not found on Lichess.", gameID))
+			return true
+		}
+		if err != nil {
+			p.API.LogWarn("Lichess game stream failed", "gameID", gameID, "error", err.Error())
+		}
+		if !sleepOrDone(ctx, liveRetryInterval) {
+			return false
+		}
+	}
+}
+
+func (p *Plugin) watchMessage(state *watchState) string {
+	game := state.game
+	url := p.getConfiguration().getBaseURL() + game.Id
+
+	white := "**" + playerText(game.Players.White) + "**"
+	black := "**" + playerText(game.Players.Black) + "**"
+	if state.clocks && !state.finished {
+		white += ", " + formatSeconds(state.whiteClock)
+		black += ", " + formatSeconds(state.blackClock)
+	}
+
+	mode := "Casual"
+	if game.Rated {
+		mode = "Rated"
+	}
+	kind := capitalize(game.Speed)
+	if game.Variant.Key != "" && game.Variant.Key != "standard" {
+		kind = game.Variant.Name
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### [%s %s game](%s)\n", mode, kind, url)
+	b.WriteString(black + "\n\n")
+	b.WriteString(p.boardMarkdown(streamFEN(state.fen, state.lastMove), state.lastMove, false))
+	b.WriteString("\n\n" + white + "\n\n")
+	if state.finished {
+		result := gameResultText(&lichess.Game{Status: game.Status.Name, Winner: game.Winner})
+		fmt.Fprintf(&b, "**%s**. [Analyse on Lichess](%s)", result, url)
+	} else {
+		if state.lastMove != "" {
+			fmt.Fprintf(&b, "Last move: %s. ", state.lastMove)
+		}
+		fmt.Fprintf(&b, "[Watch on Lichess](%s)", url)
+	}
+	return b.String()
+}
+
+// executeWatchCommand posts a game that follows its moves live. The game is
+// given by ID or link, or as the current game of a connected user.
+func (p *Plugin) executeWatchCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return ephemeralResponse("Use `/lichess watch <game ID or link>` or `/lichess watch @user`.")
+	}
+	if params[0] == "stop" {
+		return p.executeWatchStopCommand(args)
+	}
+
+	gameID, resp := p.resolveGameID(params[0])
+	if resp != nil {
+		return resp
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: args.ChannelId,
+		Message:   fmt.Sprintf("Connecting to [the game](%s%s)...", p.getConfiguration().getBaseURL(), gameID),
+	}
+	if err := p.pluginAPI.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("failed to create watch post", "error", err.Error())
+		return ephemeralResponse("Failed to post the game.")
+	}
+
+	err := p.watch.add(LivePost{
+		PostID:    post.Id,
+		ChannelID: args.ChannelId,
+		Key:       gameID,
+		ExpiresAt: model.GetMillis() + watchPostLifetime.Milliseconds(),
+	})
+	if err != nil {
+		p.API.LogWarn("failed to store watch post", "error", err.Error())
+		return ephemeralResponse("Failed to follow the game.")
+	}
+	return &model.CommandResponse{}
+}
+
+// resolveGameID finds a game from a game ID, a game link or a mention of a
+// connected user who is playing.
+func (p *Plugin) resolveGameID(param string) (string, *model.CommandResponse) {
+	if !strings.HasPrefix(param, "@") {
+		if match := gameLinkRegexp(p.getConfiguration().getBaseURL()).FindStringSubmatch(param); match != nil {
+			return match[1], nil
+		}
+		if match := gameIDRegexp.FindStringSubmatch(param); match != nil {
+			return match[1], nil
+		}
+		return "", ephemeralResponsef("%q is not a Lichess game. Use a game ID, a game link or @user.", param)
+	}
+
+	user, err := p.userFromMention(param)
+	if err != nil {
+		return "", ephemeralResponse(err.Error())
+	}
+	connected, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogWarn("failed to get connected users", "error", err.Error())
+		return "", ephemeralResponse("Failed to load the connected users.")
+	}
+	lichessUsername, ok := connected[user.Id]
+	if !ok {
+		return "", ephemeralResponsef("@%s has not connected a Lichess account.", user.Username)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	game, err := p.newLichessClient().CurrentGame(ctx, lichessUsername)
+	if err != nil && !lichess.IsNotFound(err) {
+		p.API.LogWarn("failed to get current game", "lichessUsername", lichessUsername, "error", err.Error())
+		return "", ephemeralResponse("Failed to find the game on Lichess.")
+	}
+	if err != nil || isGameFinished(game) {
+		return "", ephemeralResponsef("@%s is not playing on Lichess right now.", user.Username)
+	}
+	return game.Id, nil
+}
+
+func (p *Plugin) executeWatchStopCommand(args *model.CommandArgs) *model.CommandResponse {
+	stopped, err := p.watch.remove(func(post LivePost) bool {
+		return post.ChannelID == args.ChannelId
+	})
+	if err != nil {
+		p.API.LogWarn("failed to stop watch posts", "error", err.Error())
+		return ephemeralResponse("Failed to stop following the games.")
+	}
+	if stopped == 0 {
+		return ephemeralResponse("No games are followed in this channel.")
+	}
+	return ephemeralResponse("Stopped following the games in this channel.")
+}